@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// TestQuality is the assertion-quality score of one generated test.
+type TestQuality struct {
+	Name       string
+	Assertions int
+	Score      int // 0 to 100
+	Issues     []string
+}
+
+// Score penalties, out of 100.
+const (
+	errorOnlyPenalty       = 60
+	vacuousCheckPenalty    = 40
+	discardedResultPenalty = 20
+)
+
+// testingFailures are the testing.T methods that report a failure.
+var testingFailures = map[string]bool{"Error": true, "Errorf": true, "Fatal": true, "Fatalf": true, "Fail": true, "FailNow": true}
+
+// testifyErrorChecks are the testify assertions that only look at an error.
+var testifyErrorChecks = map[string]bool{"NoError": true, "Error": true, "NoErrorf": true, "Errorf": true}
+
+// AnalyzeTestQuality scores the Test functions in src by their assertions:
+// a test that asserts nothing scores 0, and checks that only look at an
+// error, comparisons that can never fail and discarded results cost points.
+// Checking only the error is fine for functions that return nothing else.
+// Tests that skip themselves first, like quarantined ones, are not scored.
+func AnalyzeTestQuality(src string, functions []FunctionInfo) ([]TestQuality, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "generated_test.go", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated code: %v", err)
+	}
+
+	errorOnlyFine := make(map[string]bool)
+	for _, fn := range functions {
+		errorOnlyFine[fn.TestName()] = len(fn.Results) == 0 || (len(fn.Results) == 1 && fn.ReturnsError)
+	}
+
+	var qualities []TestQuality
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Body == nil || !strings.HasPrefix(fn.Name.Name, "Test") || skipsFirst(fn.Body) {
+			continue
+		}
+		quality := analyzeTest(fn)
+		if errorOnlyFine[fn.Name.Name] {
+			quality.Issues = removeIssue(quality.Issues, issueErrorOnly)
+		}
+		quality.Score = scoreTest(quality)
+		qualities = append(qualities, quality)
+	}
+	return qualities, nil
+}
+
+// Issue texts, matched by scoreTest.
+const (
+	issueNoAssertions = "makes no assertions"
+	issueErrorOnly    = "only checks errors"
+)
+
+func analyzeTest(fn *ast.FuncDecl) TestQuality {
+	quality := TestQuality{Name: fn.Name.Name}
+	tNames := testingParams(fn)
+	valueChecks := 0
+
+	var stack []ast.Node
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			if discarded := discardedCall(n, tNames); discarded != "" {
+				quality.Issues = append(quality.Issues, fmt.Sprintf("discards the result of %s", discarded))
+			}
+		case *ast.BinaryExpr:
+			if vacuous := vacuousComparison(n); vacuous != "" {
+				quality.Issues = append(quality.Issues, vacuous)
+			}
+		case *ast.CallExpr:
+			if vacuous := vacuousCall(n); vacuous != "" {
+				quality.Issues = append(quality.Issues, vacuous)
+			}
+			errorOnly, ok := assertionKind(n, tNames)
+			if !ok {
+				return true
+			}
+			quality.Assertions++
+			if !errorOnly {
+				if cond := guardCondition(stack); cond == nil || !mentionsOnlyErrors(cond) {
+					valueChecks++
+				}
+			}
+		}
+		return true
+	})
+
+	switch {
+	case quality.Assertions == 0:
+		quality.Issues = append(quality.Issues, issueNoAssertions)
+	case valueChecks == 0:
+		quality.Issues = append(quality.Issues, issueErrorOnly)
+	}
+	return quality
+}
+
+func scoreTest(quality TestQuality) int {
+	score := 100
+	for _, issue := range quality.Issues {
+		switch {
+		case issue == issueNoAssertions:
+			return 0
+		case issue == issueErrorOnly:
+			score -= errorOnlyPenalty
+		case strings.HasPrefix(issue, "discards"):
+			score -= discardedResultPenalty
+		default:
+			score -= vacuousCheckPenalty
+		}
+	}
+	return max(score, 0)
+}
+
+func removeIssue(issues []string, issue string) []string {
+	var kept []string
+	for _, i := range issues {
+		if i != issue {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+// skipsFirst reports whether body starts by skipping the test.
+func skipsFirst(body *ast.BlockStmt) bool {
+	if len(body.List) == 0 {
+		return false
+	}
+	stmt, ok := body.List[0].(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	call, ok := stmt.X.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && strings.HasPrefix(sel.Sel.Name, "Skip")
+}
+
+// testingParams returns the names of the *testing.T and testing.TB
+// parameters of fn and of the function literals in it, such as subtests.
+func testingParams(fn *ast.FuncDecl) map[string]bool {
+	names := make(map[string]bool)
+	add := func(params *ast.FieldList) {
+		for _, field := range params.List {
+			t := types.ExprString(field.Type)
+			if t != "*testing.T" && t != "testing.TB" {
+				continue
+			}
+			for _, name := range field.Names {
+				names[name.Name] = true
+			}
+		}
+	}
+	add(fn.Type.Params)
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.FuncLit); ok {
+			add(lit.Type.Params)
+		}
+		return true
+	})
+	return names
+}
+
+// assertionKind reports whether call can fail the test, and if so whether
+// it only looks at an error. Calls to helpers that are handed the
+// *testing.T count as assertions.
+func assertionKind(call *ast.CallExpr, tNames map[string]bool) (errorOnly, ok bool) {
+	if sel, isSel := call.Fun.(*ast.SelectorExpr); isSel {
+		if x, isIdent := sel.X.(*ast.Ident); isIdent {
+			switch {
+			case tNames[x.Name]:
+				return false, testingFailures[sel.Sel.Name]
+			case x.Name == "assert" || x.Name == "require":
+				return testifyErrorChecks[sel.Sel.Name], true
+			}
+		}
+	}
+	for _, arg := range call.Args {
+		if ident, isIdent := arg.(*ast.Ident); isIdent && tNames[ident.Name] {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// guardCondition returns the condition of the innermost if statement whose
+// body or else branch contains the top of stack.
+func guardCondition(stack []ast.Node) ast.Expr {
+	for i := len(stack) - 2; i >= 0; i-- {
+		if ifStmt, ok := stack[i].(*ast.IfStmt); ok && stack[i+1] != ifStmt.Cond && stack[i+1] != ifStmt.Init {
+			return ifStmt.Cond
+		}
+	}
+	return nil
+}
+
+// mentionsOnlyErrors reports whether every value in cond is an error or an
+// expectation about one, such as err != nil or (err != nil) != tt.wantErr.
+func mentionsOnlyErrors(cond ast.Expr) bool {
+	onlyErrors, values := true, 0
+	var visit func(ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			// Look at the arguments, not at the function called
+			for _, arg := range n.Args {
+				ast.Inspect(arg, visit)
+			}
+			return false
+		case *ast.SelectorExpr:
+			values++
+			onlyErrors = onlyErrors && isErrorName(n.Sel.Name)
+			return false
+		case *ast.Ident:
+			if n.Name != "nil" && n.Name != "true" && n.Name != "false" {
+				values++
+				onlyErrors = onlyErrors && isErrorName(n.Name)
+			}
+		}
+		return true
+	}
+	ast.Inspect(cond, visit)
+	return values > 0 && onlyErrors
+}
+
+// isErrorName reports whether name has err or error as one of its words,
+// as in err, errMsg, wantErr, HTTPError or got_error, but not ferry or
+// deferred.
+func isErrorName(name string) bool {
+	for _, word := range nameWords(name) {
+		switch strings.ToLower(word) {
+		case "err", "error", "errs", "errors":
+			return true
+		}
+	}
+	return false
+}
+
+// nameWords splits an identifier into its camel-case and underscore
+// separated words, leaving out digits: errMsg2 and HTTPError_x become
+// err Msg and HTTP Error x.
+func nameWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			words = append(words, string(runes[start:end]))
+		}
+		start = end
+	}
+	for i, r := range runes {
+		switch {
+		case r == '_' || unicode.IsDigit(r):
+			flush(i)
+			start = i + 1
+		case i > start && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])):
+			flush(i)
+		}
+	}
+	flush(len(runes))
+	return words
+}
+
+// vacuousComparison describes a comparison that can never fail, such as
+// got == got or 1 == 1, or returns "".
+func vacuousComparison(expr *ast.BinaryExpr) string {
+	switch expr.Op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+	default:
+		return ""
+	}
+	x, y := types.ExprString(expr.X), types.ExprString(expr.Y)
+	if x == y {
+		return fmt.Sprintf("compares %s with itself", x)
+	}
+	if isConstant(expr.X) && isConstant(expr.Y) {
+		return fmt.Sprintf("compares constants %s and %s", x, y)
+	}
+	return ""
+}
+
+// vacuousCall describes an equality check between a value and itself,
+// e.g. reflect.DeepEqual(got, got) or assert.Equal(t, got, got), or
+// returns "".
+func vacuousCall(call *ast.CallExpr) string {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	name := types.ExprString(sel)
+	var args []ast.Expr
+	switch {
+	case name == "reflect.DeepEqual" && len(call.Args) == 2:
+		args = call.Args
+	case (strings.HasPrefix(name, "assert.") || strings.HasPrefix(name, "require.")) &&
+		strings.Contains(sel.Sel.Name, "Equal") && len(call.Args) >= 3:
+		args = call.Args[1:3]
+	default:
+		return ""
+	}
+	if x := types.ExprString(args[0]); x == types.ExprString(args[1]) {
+		return fmt.Sprintf("compares %s with itself", x)
+	}
+	return ""
+}
+
+func isConstant(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident:
+		return e.Name == "true" || e.Name == "false" || e.Name == "nil"
+	case *ast.ParenExpr:
+		return isConstant(e.X)
+	case *ast.UnaryExpr:
+		return isConstant(e.X)
+	}
+	return false
+}
+
+// discardedCall returns the function whose results assign throws away
+// entirely, as in _ = Add(1, 2), or "".
+func discardedCall(assign *ast.AssignStmt, tNames map[string]bool) string {
+	if len(assign.Rhs) != 1 {
+		return ""
+	}
+	call, ok := assign.Rhs[0].(*ast.CallExpr)
+	if !ok {
+		return ""
+	}
+	for _, lhs := range assign.Lhs {
+		if ident, ok := lhs.(*ast.Ident); !ok || ident.Name != "_" {
+			return ""
+		}
+	}
+	if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+		if x, ok := sel.X.(*ast.Ident); ok && tNames[x.Name] {
+			return ""
+		}
+	}
+	return types.ExprString(call.Fun)
+}
+
+// enforceQuality scores the tests in content and gives the ones below
+// MinQuality one chance to be repaired by the model, in code mode, before
+// dropping those still below it. It returns the final scores.
+func (tg *TestGenerator) enforceQuality(ctx context.Context, prompt Prompt, content string, functions []FunctionInfo, renderer TableRenderer, postProcessor PostProcessor) (string, []TestQuality, []DroppedTest, error) {
+	qualities, err := AnalyzeTestQuality(content, functions)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	low := tg.lowQuality(qualities)
+	if len(low) == 0 {
+		return content, qualities, nil, nil
+	}
+
+	if tg.options.QualityRepair && tg.options.OutputMode == OutputModeCode {
+		repaired, err := tg.repairTests(ctx, prompt, content, low, functions, renderer, postProcessor)
+		if err != nil {
+			// The unrepaired tests are dropped below
+			log.Printf("Could not repair low-quality tests: %v", err)
+		} else {
+			content = repaired
+			if qualities, err = AnalyzeTestQuality(content, functions); err != nil {
+				return "", nil, nil, err
+			}
+			low = tg.lowQuality(qualities)
+		}
+	}
+	if len(low) == 0 {
+		return content, qualities, nil, nil
+	}
+	if len(low) == len(qualities) {
+		return "", qualities, nil, fmt.Errorf("no generated test reached the assertion quality threshold of %d", tg.options.MinQuality)
+	}
+
+	drop := make(map[string]bool)
+	var dropped []DroppedTest
+	for _, quality := range low {
+		drop[quality.Name] = true
+		dropped = append(dropped, DroppedTest{
+			Name:   quality.Name,
+			Reason: fmt.Sprintf("assertion quality %d is below %d: %s", quality.Score, tg.options.MinQuality, strings.Join(quality.Issues, "; ")),
+		})
+	}
+	if content, err = postProcessor.removeDeclarations(content, drop); err != nil {
+		return "", nil, nil, err
+	}
+	return content, qualities, dropped, nil
+}
+
+func (tg *TestGenerator) lowQuality(qualities []TestQuality) []TestQuality {
+	var low []TestQuality
+	for _, quality := range qualities {
+		if quality.Score < tg.options.MinQuality {
+			low = append(low, quality)
+		}
+	}
+	return low
+}
+
+// repairTests asks the model to rewrite the low-scoring tests and swaps the
+// rewrites into content. The rewrites must pass the safety policy.
+func (tg *TestGenerator) repairTests(ctx context.Context, prompt Prompt, content string, low []TestQuality, functions []FunctionInfo, renderer TableRenderer, postProcessor PostProcessor) (string, error) {
+	data := newDataDelimiter()
+	names := make(map[string]bool)
+	var request strings.Builder
+	request.WriteString(prompt.User)
+	request.WriteString("\nYour previous tests:\n")
+	request.WriteString(data.block("previous tests", content))
+	request.WriteString("\nThese tests were rejected for weak assertions:\n")
+	for _, quality := range low {
+		names[quality.Name] = true
+		request.WriteString(fmt.Sprintf("- %s: %s\n", quality.Name, strings.Join(quality.Issues, "; ")))
+	}
+	request.WriteString("Rewrite ONLY these tests so that each one compares the actual results with specific expected values. ")
+	request.WriteString("Keep their names and respond with a complete test file containing just them.\n")
+
+	rewritten, err := tg.generateWithModel(ctx, Prompt{System: prompt.System, User: request.String()}, defaultTemperature, functions, renderer, postProcessor)
+	if err != nil {
+		return "", err
+	}
+	violations, err := tg.options.Safety.Check(rewritten)
+	if err != nil {
+		return "", err
+	}
+	if len(violations) > 0 {
+		return "", fmt.Errorf("rewritten tests violate the safety policy: %s", violations[0])
+	}
+
+	replacements, err := extractDeclarations(rewritten, names)
+	if err != nil {
+		return "", err
+	}
+	if replacements == "" {
+		return "", fmt.Errorf("the model did not rewrite any of the tests")
+	}
+
+	// Keep the originals of tests the model left out
+	replaced, err := declaredFunctions("package p\n" + replacements)
+	if err != nil {
+		return "", err
+	}
+	if content, err = postProcessor.removeDeclarations(content, replaced); err != nil {
+		return "", err
+	}
+	return postProcessor.Process(content + "\n" + replacements)
+}
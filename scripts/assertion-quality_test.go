@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeTestQuality(t *testing.T) {
+	src := `package calc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int
+		want int
+	}{{"positive", 1, 2, 3}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Add(tt.a, tt.b); got != tt.want {
+				t.Errorf("Add() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDivide(t *testing.T) {
+	_, err := Divide(4, 2)
+	if err != nil {
+		t.Fatalf("Divide() error = %v", err)
+	}
+	if _, err := Divide(1, 0); !errors.Is(err, ErrDivideByZero) {
+		t.Errorf("Divide() error = %v", err)
+	}
+}
+
+func TestSave(t *testing.T) {
+	if err := Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMultiply(t *testing.T) {
+	Multiply(2, 3)
+	_ = Multiply(2, 3)
+}
+
+func TestSubtract(t *testing.T) {
+	got := Subtract(3, 1)
+	if !reflect.DeepEqual(got, got) {
+		t.Errorf("Subtract() = %d", got)
+	}
+	if 2 < 1 {
+		t.Error("math is broken")
+	}
+}
+
+func TestFlaky(t *testing.T) {
+	t.Skip("quarantined as flaky")
+}
+`
+	functions := []FunctionInfo{
+		{Name: "Divide", Results: []Param{{Type: "int"}, {Type: "error"}}, ReturnsError: true},
+		{Name: "Save", Results: []Param{{Type: "error"}}, ReturnsError: true},
+	}
+	qualities, err := AnalyzeTestQuality(src, functions)
+	if err != nil {
+		t.Fatalf("AnalyzeTestQuality() error = %v", err)
+	}
+
+	want := []TestQuality{
+		{Name: "TestAdd", Assertions: 1, Score: 100},
+		{Name: "TestDivide", Assertions: 2, Score: 40, Issues: []string{issueErrorOnly}},
+		{Name: "TestSave", Assertions: 1, Score: 100},
+		{Name: "TestMultiply", Score: 0, Issues: []string{"discards the result of Multiply", issueNoAssertions}},
+		{Name: "TestSubtract", Assertions: 2, Score: 20, Issues: []string{"compares got with itself", "compares constants 2 and 1"}},
+	}
+	if !reflect.DeepEqual(qualities, want) {
+		t.Errorf("AnalyzeTestQuality() =\n%+v\nwant\n%+v", qualities, want)
+	}
+}
+
+func TestIsErrorName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"err":         true,
+		"errMsg":      true,
+		"err2":        true,
+		"wantErr":     true,
+		"ErrNotFound": true,
+		"HTTPError":   true,
+		"got_error":   true,
+		"errs":        true,
+		"ferry":       false,
+		"deferred":    false,
+		"interrupt":   false,
+		"referrer":    false,
+		"Errorf":      false,
+		"result":      false,
+	} {
+		if got := isErrorName(name); got != want {
+			t.Errorf("isErrorName(%q) = %v, want %v (words %q)", name, got, want, nameWords(name))
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/token"
+	"go/types"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/tools/benchmark/parse"
+)
+
+// BenchmarkResult is the baseline measurement of one generated benchmark.
+type BenchmarkResult struct {
+	Name        string
+	NsPerOp     float64
+	BytesPerOp  uint64
+	AllocsPerOp uint64
+}
+
+// BenchmarkName returns the Benchmark function name matching TestName, e.g.
+// "BenchmarkCalculator_Add".
+func (fi FunctionInfo) BenchmarkName() string {
+	return "Benchmark" + strings.TrimPrefix(fi.TestName(), "Test")
+}
+
+// benchmarkTarget is the template data for one Benchmark function.
+type benchmarkTarget struct {
+	BenchmarkName string
+	Receiver      string // expression building the receiver, empty for functions
+	Call          string
+	Args          []string
+}
+
+var benchmarkTemplate = template.Must(template.New("benchmark").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`
+func {{.BenchmarkName}}(b *testing.B) {
+	{{- if .Receiver}}
+	recv := {{.Receiver}}
+	{{- end}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		{{.Call}}({{join .Args ", "}})
+	}
+}
+`))
+
+// BenchmarkGenerator emits a Benchmark function per selected function that
+// calls it in a loop with simple non-zero inputs, for a reviewer to refine
+// into a realistic workload.
+type BenchmarkGenerator struct {
+	Qualifier string       // package name prefix for external tests, empty otherwise
+	Build     BuildContext // tags and platform the package is loaded with
+}
+
+// Generate renders benchmarks for the functions whose benchmark is not
+// already declared in the test file, as declarations to append to it.
+func (bg BenchmarkGenerator) Generate(ctx context.Context, dir string, functions []FunctionInfo, declared map[string]bool) (string, error) {
+	var todo []FunctionInfo
+	for _, fn := range functions {
+		if !declared[fn.BenchmarkName()] {
+			todo = append(todo, fn)
+		}
+	}
+	if len(todo) == 0 {
+		return "", nil
+	}
+
+	pkg, err := loadPackageDir(ctx, dir, bg.Build)
+	if err != nil {
+		return "", err
+	}
+	objects := make(map[string]*types.Func)
+	for fn, qualified := range packageFunctions(pkg) {
+		objects[qualified] = fn
+	}
+
+	qualifier := func(p *types.Package) string {
+		if p == pkg.Types {
+			return bg.Qualifier
+		}
+		return p.Name()
+	}
+
+	var buf bytes.Buffer
+	for _, fn := range todo {
+		obj, ok := objects[fn.QualifiedName()]
+		if !ok {
+			continue
+		}
+		sig := obj.Type().(*types.Signature)
+		if sig.TypeParams().Len() > 0 || (sig.Recv() != nil && sig.RecvTypeParams().Len() > 0) {
+			log.Printf("Skipping benchmark for generic %s", fn.QualifiedName())
+			continue
+		}
+		if bg.Qualifier != "" && fn.Receiver != "" && !token.IsExported(fn.Receiver) {
+			continue
+		}
+
+		target := benchmarkTarget{BenchmarkName: fn.BenchmarkName(), Call: fn.Name}
+		if bg.Qualifier != "" {
+			target.Call = bg.Qualifier + "." + fn.Name
+		}
+		if fn.Receiver != "" {
+			target.Call = "recv." + fn.Name
+			target.Receiver = receiverValue(pkg, fn, bg.Qualifier)
+		}
+		for i := 0; i < sig.Params().Len(); i++ {
+			if sig.Variadic() && i == sig.Params().Len()-1 {
+				break
+			}
+			t := sig.Params().At(i).Type()
+			if _, ok := t.(*types.Basic); ok {
+				// Non-zero values stay clear of the usual zero-value error paths
+				target.Args = append(target.Args, seedValue(t, 1))
+				continue
+			}
+			target.Args = append(target.Args, zeroValue(t, qualifier))
+		}
+
+		if err := benchmarkTemplate.Execute(&buf, target); err != nil {
+			return "", fmt.Errorf("failed to render benchmark for %s: %v", fn.QualifiedName(), err)
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// selectBenchmarkFunctions returns the functions matching one of patterns,
+// or all of them when there are no patterns.
+func selectBenchmarkFunctions(functions []FunctionInfo, patterns []*regexp.Regexp) []FunctionInfo {
+	if len(patterns) == 0 {
+		return functions
+	}
+	var selected []FunctionInfo
+	for _, fn := range functions {
+		for _, re := range patterns {
+			if re.MatchString(fn.QualifiedName()) {
+				selected = append(selected, fn)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// addBenchmarks completes content with benchmarks for the selected functions,
+// checks that every Benchmark function in it runs once without failing and
+// removes the ones that do not. With BenchmarkBaseline set it also measures
+// the remaining benchmarks.
+func (tg *TestGenerator) addBenchmarks(ctx context.Context, gt generationTarget, content string) (string, []BenchmarkResult, []DroppedTest, error) {
+	declared, err := declaredFunctions(content)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	selected := selectBenchmarkFunctions(gt.functions, tg.options.BenchmarkPatterns)
+	extra, err := BenchmarkGenerator{Qualifier: gt.qualifier, Build: gt.build}.Generate(ctx, gt.dir, selected, declared)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if extra != "" {
+		if content, err = gt.postProcessor.Process(content + extra); err != nil {
+			return "", nil, nil, err
+		}
+		if declared, err = declaredFunctions(content); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	var names []string
+	for name := range declared {
+		if strings.HasPrefix(name, "Benchmark") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return content, nil, nil, nil
+	}
+
+	runner := gt.runner(5 * time.Minute)
+	var dropped []DroppedTest
+	var failed []string
+	if _, err := runner.Run(ctx, gt.dir, content, "-run=^$", "-bench="+namesPattern(names), "-benchtime=1x"); err != nil {
+		// Find the culprits one by one; a compile error fails all of them
+		for _, name := range names {
+			output, err := runner.Run(ctx, gt.dir, content, "-run=^$", "-bench="+namesPattern([]string{name}), "-benchtime=1x")
+			if err != nil {
+				log.Printf("Benchmark %s failed, dropping it: %v\n%s", name, err, output)
+				failed = append(failed, name)
+				dropped = append(dropped, DroppedTest{Name: name, Reason: "failed a -benchtime=1x run"})
+			}
+		}
+	}
+	if len(failed) > 0 {
+		drop := make(map[string]bool)
+		for _, name := range failed {
+			drop[name] = true
+		}
+		if content, err = gt.postProcessor.removeDeclarations(content, drop); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	if len(failed) == len(names) || !tg.options.BenchmarkBaseline {
+		return content, nil, dropped, nil
+	}
+
+	var remaining []string
+	for _, name := range names {
+		if !slices.Contains(failed, name) {
+			remaining = append(remaining, name)
+		}
+	}
+	output, err := runner.Run(ctx, gt.dir, content, "-run=^$", "-bench="+namesPattern(remaining), "-benchmem")
+	if err != nil {
+		// The benchmarks already passed once; a baseline is optional
+		log.Printf("Could not record benchmark baseline: %v\n%s", err, output)
+		return content, nil, dropped, nil
+	}
+
+	return content, parseBenchmarkResults(output), dropped, nil
+}
+
+// namesPattern returns a -run or -bench regexp matching exactly names.
+func namesPattern(names []string) string {
+	return "^(" + strings.Join(names, "|") + ")$"
+}
+
+// parseBenchmarkResults extracts the measurements from go test -bench
+// output, with the GOMAXPROCS suffix removed from the names.
+func parseBenchmarkResults(output string) []BenchmarkResult {
+	set, err := parse.ParseSet(strings.NewReader(output))
+	if err != nil {
+		return nil
+	}
+
+	var results []BenchmarkResult
+	for name, runs := range set {
+		if i := strings.LastIndex(name, "-"); i > 0 && strings.Trim(name[i+1:], "0123456789") == "" {
+			name = name[:i]
+		}
+		last := runs[len(runs)-1]
+		results = append(results, BenchmarkResult{
+			Name:        name,
+			NsPerOp:     last.NsPerOp,
+			BytesPerOp:  last.AllocedBytesPerOp,
+			AllocsPerOp: last.AllocsPerOp,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseBenchmarkResults(t *testing.T) {
+	output := `goos: linux
+goarch: amd64
+pkg: example.com/calc
+BenchmarkCalculator_Add-8      	 2611228	       458.7 ns/op	     105 B/op	       1 allocs/op
+BenchmarkParse/small-8         	  100000	      1200 ns/op	      64 B/op	       2 allocs/op
+PASS
+ok  	example.com/calc	2.514s
+`
+	want := []BenchmarkResult{
+		{Name: "BenchmarkCalculator_Add", NsPerOp: 458.7, BytesPerOp: 105, AllocsPerOp: 1},
+		{Name: "BenchmarkParse/small", NsPerOp: 1200, BytesPerOp: 64, AllocsPerOp: 2},
+	}
+
+	if got := parseBenchmarkResults(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseBenchmarkResults() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSelectBenchmarkFunctions(t *testing.T) {
+	functions := []FunctionInfo{
+		{Name: "Add", Receiver: "Calculator"},
+		{Name: "Divide", Receiver: "Calculator"},
+		{Name: "parse"},
+	}
+
+	if got := selectBenchmarkFunctions(functions, nil); len(got) != 3 {
+		t.Errorf("selectBenchmarkFunctions() without patterns = %d functions, want 3", len(got))
+	}
+
+	got := selectBenchmarkFunctions(functions, []*regexp.Regexp{regexp.MustCompile(`^Calculator\.Div`), regexp.MustCompile(`^parse$`)})
+	var names []string
+	for _, fn := range got {
+		names = append(names, fn.QualifiedName())
+	}
+	if want := []string{"Calculator.Divide", "parse"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("selectBenchmarkFunctions() = %v, want %v", names, want)
+	}
+}
+
+func TestBenchmarkGenerator_Generate(t *testing.T) {
+	dir, functions := writeCalcModule(t, `package calc
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Calculator struct{ history []string }
+
+func NewCalculator() *Calculator { return &Calculator{} }
+
+func (c *Calculator) Add(a, b int) int {
+	c.history = append(c.history, strconv.Itoa(a+b))
+	return a + b
+}
+
+func Parse(s string) (int, error) { return strconv.Atoi(strings.TrimSpace(s)) }
+
+func Sum(values ...float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func Max[T int | float64](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+type cache struct{ m map[string]int }
+
+func (c *cache) get(k string) int { return c.m[k] }
+`)
+
+	// Sum already has a benchmark, generics and methods of unexported types
+	// are left out
+	declared := map[string]bool{"BenchmarkSum": true}
+	src, err := BenchmarkGenerator{Qualifier: "calc"}.Generate(context.Background(), dir, functions, declared)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	var names []string
+	for _, part := range strings.Split(src, "\nfunc ")[1:] {
+		names = append(names, part[:strings.Index(part, "(")])
+	}
+	sort.Strings(names)
+	if want := []string{"BenchmarkCalculator_Add", "BenchmarkNewCalculator", "BenchmarkParse"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Generate() benchmarks = %v, want %v:\n%s", names, want, src)
+	}
+
+	pp := PostProcessor{PackageName: "calc_test", ImportName: "calc", ImportPath: "example.com/calc"}
+	content, err := pp.Process("package calc_test\n" + src)
+	if err != nil {
+		t.Fatalf("generated benchmarks are invalid: %v\n%s", err, src)
+	}
+	runner := TestRunner{TestFile: "calc_gen_test.go"}
+	if output, err := runner.Run(context.Background(), dir, content, "-run=^$", "-bench=.", "-benchtime=1x"); err != nil {
+		t.Fatalf("generated benchmarks fail: %v\n%s\n%s", err, output, content)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/build/constraint"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// knownOS and knownArch are the GOOS and GOARCH values go/build recognizes
+// in build constraints and file name suffixes.
+var (
+	knownOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true,
+		"hurd": true, "illumos": true, "ios": true, "js": true, "linux": true, "nacl": true,
+		"netbsd": true, "openbsd": true, "plan9": true, "solaris": true, "wasip1": true,
+		"windows": true, "zos": true,
+	}
+	knownArch = map[string]bool{
+		"386": true, "amd64": true, "amd64p32": true, "arm": true, "armbe": true, "arm64": true,
+		"arm64be": true, "loong64": true, "mips": true, "mipsle": true, "mips64": true,
+		"mips64le": true, "mips64p32": true, "mips64p32le": true, "ppc": true, "ppc64": true,
+		"ppc64le": true, "riscv": true, "riscv64": true, "s390": true, "s390x": true,
+		"sparc": true, "sparc64": true, "wasm": true,
+	}
+	unixOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true,
+		"hurd": true, "illumos": true, "ios": true, "linux": true, "netbsd": true,
+		"openbsd": true, "solaris": true,
+	}
+)
+
+// maxCustomTags bounds the tags of a constraint tried in combination.
+const maxCustomTags = 10
+
+// BuildContext is what it takes to build a file behind build constraints:
+// the tags passed with -tags and the platform, if not the host's.
+type BuildContext struct {
+	Constraint string   // expression of the file's //go:build line, empty if it has none
+	Tags       []string // tags that satisfy it
+	GOOS       string   // set only if the file does not build for the host
+	GOARCH     string
+
+	fileOS, fileArch string // required by the file name, e.g. x_linux_amd64.go
+}
+
+// ReadBuildContext reads the build constraints of the Go file at path, from
+// its //go:build (or legacy // +build) lines and its name, and finds tags
+// and a platform satisfying them, preferring the host platform and as few
+// tags as possible.
+func ReadBuildContext(path string) (BuildContext, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return BuildContext{}, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	var bc BuildContext
+	bc.fileOS, bc.fileArch = fileNamePlatform(filepath.Base(path))
+
+	expr, err := readConstraint(content)
+	if err != nil {
+		return BuildContext{}, fmt.Errorf("invalid build constraint in %s: %v", filepath.Base(path), err)
+	}
+	if expr != nil {
+		bc.Constraint = expr.String()
+	}
+
+	goos, goarch := runtime.GOOS, runtime.GOARCH
+	if bc.fileOS != "" {
+		goos = bc.fileOS
+	}
+	if bc.fileArch != "" {
+		goarch = bc.fileArch
+	}
+	if expr != nil {
+		var ok bool
+		if goos, goarch, bc.Tags, ok = satisfyConstraint(expr, goos, goarch, bc.fileOS != "", bc.fileArch != ""); !ok {
+			return BuildContext{}, fmt.Errorf("no tags or platform satisfy the build constraint %q of %s", bc.Constraint, filepath.Base(path))
+		}
+	}
+	if goos != runtime.GOOS {
+		bc.GOOS = goos
+	}
+	if goarch != runtime.GOARCH {
+		bc.GOARCH = goarch
+	}
+	return bc, nil
+}
+
+// readConstraint parses the build constraint lines in the header of a Go
+// file, before its package clause. Several // +build lines must all hold.
+func readConstraint(content []byte) (constraint.Expr, error) {
+	var goBuild constraint.Expr
+	var plusBuild []constraint.Expr
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		switch {
+		case constraint.IsGoBuild(line):
+			expr, err := constraint.Parse(line)
+			if err != nil {
+				return nil, err
+			}
+			goBuild = expr
+		case constraint.IsPlusBuild(line):
+			expr, err := constraint.Parse(line)
+			if err != nil {
+				return nil, err
+			}
+			plusBuild = append(plusBuild, expr)
+		}
+	}
+
+	// //go:build takes precedence, as for the go command
+	if goBuild != nil {
+		return goBuild, nil
+	}
+	var expr constraint.Expr
+	for _, e := range plusBuild {
+		expr = andExpr(expr, e)
+	}
+	return expr, nil
+}
+
+// andExpr returns x && y, or y if x is nil.
+func andExpr(x, y constraint.Expr) constraint.Expr {
+	if x == nil {
+		return y
+	}
+	return &constraint.AndExpr{X: x, Y: y}
+}
+
+// fileNamePlatform returns the GOOS and GOARCH a file name restricts the
+// file to, following go/build: name_GOOS, name_GOARCH or name_GOOS_GOARCH,
+// before any _test suffix.
+func fileNamePlatform(name string) (goos, goarch string) {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".go"), "_test")
+	parts := strings.Split(name, "_")
+	n := len(parts)
+	switch {
+	case n >= 3 && knownOS[parts[n-2]] && knownArch[parts[n-1]]:
+		return parts[n-2], parts[n-1]
+	case n >= 2 && knownOS[parts[n-1]]:
+		return parts[n-1], ""
+	case n >= 2 && knownArch[parts[n-1]]:
+		return "", parts[n-1]
+	}
+	return "", ""
+}
+
+// satisfyConstraint looks for a platform and custom tags under which expr
+// holds. It tries the given platform first and changes GOOS or GOARCH
+// only if they are not fixed, to values expr mentions.
+func satisfyConstraint(expr constraint.Expr, goos, goarch string, fixedOS, fixedArch bool) (string, string, []string, bool) {
+	mentioned := make(map[string]bool)
+	collectTags(expr, mentioned)
+
+	oses, arches := []string{goos}, []string{goarch}
+	var custom []string
+	for tag := range mentioned {
+		switch {
+		case knownOS[tag]:
+			if !fixedOS && tag != goos {
+				oses = append(oses, tag)
+			}
+		case knownArch[tag]:
+			if !fixedArch && tag != goarch {
+				arches = append(arches, tag)
+			}
+		case !impliedTag(tag):
+			custom = append(custom, tag)
+		}
+	}
+	sort.Strings(oses[1:])
+	sort.Strings(arches[1:])
+	sort.Strings(custom)
+	if len(custom) > maxCustomTags {
+		custom = custom[:maxCustomTags]
+	}
+
+	// Subsets of the custom tags by size, so the fewest tags win
+	var subsets [][]string
+	for mask := 0; mask < 1<<len(custom); mask++ {
+		var subset []string
+		for i, tag := range custom {
+			if mask&(1<<i) != 0 {
+				subset = append(subset, tag)
+			}
+		}
+		subsets = append(subsets, subset)
+	}
+	sort.SliceStable(subsets, func(i, j int) bool { return len(subsets[i]) < len(subsets[j]) })
+
+	for _, candidateOS := range oses {
+		for _, candidateArch := range arches {
+			for _, tags := range subsets {
+				if expr.Eval(func(tag string) bool { return tagHolds(tag, candidateOS, candidateArch, tags) }) {
+					return candidateOS, candidateArch, tags, true
+				}
+			}
+		}
+	}
+	return "", "", nil, false
+}
+
+// impliedTag reports whether tag is not to be set with -tags: the go
+// command decides it, or it is ignore, which by convention keeps a file out
+// of every build.
+func impliedTag(tag string) bool {
+	return tag == "unix" || tag == "cgo" || tag == "gc" || tag == "gccgo" || tag == "ignore" || strings.HasPrefix(tag, "go1.")
+}
+
+// tagHolds reports whether tag is set when building for goos/goarch with
+// the custom tags. Release tags are assumed to hold, as is cgo.
+func tagHolds(tag, goos, goarch string, tags []string) bool {
+	switch {
+	case tag == goos || tag == goarch:
+		return true
+	case tag == "linux" && goos == "android", tag == "darwin" && goos == "ios", tag == "solaris" && goos == "illumos":
+		return true
+	case tag == "unix":
+		return unixOS[goos]
+	case knownOS[tag] || knownArch[tag]:
+		return false
+	case tag == "cgo" || tag == "gc" || strings.HasPrefix(tag, "go1."):
+		return true
+	case tag == "gccgo" || tag == "ignore":
+		return false
+	}
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func collectTags(expr constraint.Expr, tags map[string]bool) {
+	switch e := expr.(type) {
+	case *constraint.TagExpr:
+		tags[e.Tag] = true
+	case *constraint.NotExpr:
+		collectTags(e.X, tags)
+	case *constraint.AndExpr:
+		collectTags(e.X, tags)
+		collectTags(e.Y, tags)
+	case *constraint.OrExpr:
+		collectTags(e.X, tags)
+		collectTags(e.Y, tags)
+	}
+}
+
+// Flags returns the go command flags selecting the tags.
+func (bc BuildContext) Flags() []string {
+	if len(bc.Tags) == 0 {
+		return nil
+	}
+	return []string{"-tags=" + strings.Join(bc.Tags, ",")}
+}
+
+// Env returns the environment variables selecting the platform.
+func (bc BuildContext) Env() []string {
+	var env []string
+	if bc.GOOS != "" {
+		env = append(env, "GOOS="+bc.GOOS)
+	}
+	if bc.GOARCH != "" {
+		env = append(env, "GOARCH="+bc.GOARCH)
+	}
+	return env
+}
+
+// CrossCompiled reports whether the platform is not the host's, so test
+// binaries can be built but not run.
+func (bc BuildContext) CrossCompiled() bool {
+	return bc.GOOS != "" || bc.GOARCH != ""
+}
+
+// Line returns the //go:build line for test files of the source file, so
+// that they build exactly when it does, or "" if it needs none. It repeats
+// the platform of the source's name, which helper and internal test file
+// names do not carry.
+func (bc BuildContext) Line() string {
+	var expr constraint.Expr
+	if bc.fileOS != "" {
+		expr = andExpr(expr, &constraint.TagExpr{Tag: bc.fileOS})
+	}
+	if bc.fileArch != "" {
+		expr = andExpr(expr, &constraint.TagExpr{Tag: bc.fileArch})
+	}
+	if bc.Constraint != "" {
+		own, err := constraint.Parse("//go:build " + bc.Constraint)
+		if err == nil {
+			expr = andExpr(expr, own)
+		}
+	}
+	if expr == nil {
+		return ""
+	}
+	return "//go:build " + expr.String()
+}
+
+// String describes the context for logs and the PR description, e.g.
+// "-tags=integration GOOS=windows", or "" for a plain build.
+func (bc BuildContext) String() string {
+	return strings.Join(append(bc.Flags(), bc.Env()...), " ")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReadBuildContext(t *testing.T) {
+	otherOS := "windows"
+	if runtime.GOOS == otherOS {
+		otherOS = "linux"
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		want   BuildContext
+		line   string
+	}{
+		{"unconstrained", "// Package calc does arithmetic.\n", BuildContext{}, ""},
+		{"custom tag", "//go:build integration\n", BuildContext{Constraint: "integration", Tags: []string{"integration"}}, "//go:build integration"},
+		{"fewest tags", "//go:build (slow || e2e) && !nodb\n", BuildContext{Constraint: "(slow || e2e) && !nodb", Tags: []string{"e2e"}}, "//go:build (slow || e2e) && !nodb"},
+		{"host platform", "//go:build " + runtime.GOOS + " || " + otherOS + "\n", BuildContext{Constraint: runtime.GOOS + " || " + otherOS}, "//go:build " + runtime.GOOS + " || " + otherOS},
+		{"other platform", "//go:build " + otherOS + "\n", BuildContext{Constraint: otherOS, GOOS: otherOS}, "//go:build " + otherOS},
+		{"legacy lines", "// +build one\n// +build two\n", BuildContext{Constraint: "one && two", Tags: []string{"one", "two"}}, "//go:build one && two"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "calc.go")
+			if err := os.WriteFile(path, []byte(tt.header+"\npackage calc\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadBuildContext(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadBuildContext() = %+v, want %+v", got, tt.want)
+			}
+			if line := got.Line(); line != tt.line {
+				t.Errorf("Line() = %q, want %q", line, tt.line)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "gen.go")
+	if err := os.WriteFile(path, []byte("//go:build ignore\n\npackage main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBuildContext(path); err == nil {
+		t.Errorf("ReadBuildContext() of an ignored file did not fail")
+	}
+}
+
+func TestBuildContextFileName(t *testing.T) {
+	for name, want := range map[string][2]string{
+		"calc.go":                     {"", ""},
+		"linux.go":                    {"", ""},
+		"calc_linux.go":               {"linux", ""},
+		"calc_arm64_test.go":          {"", "arm64"},
+		"calc_windows_amd64.go":       {"windows", "amd64"},
+		"calc_linux_internal_test.go": {"", ""},
+	} {
+		if goos, goarch := fileNamePlatform(name); goos != want[0] || goarch != want[1] {
+			t.Errorf("fileNamePlatform(%s) = %s, %s, want %s, %s", name, goos, goarch, want[0], want[1])
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "calc_"+runtime.GOOS+".go")
+	if err := os.WriteFile(path, []byte("//go:build a || b\n\npackage calc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bc, err := ReadBuildContext(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "//go:build " + runtime.GOOS + " && (a || b)"; bc.Line() != want {
+		t.Errorf("Line() = %q, want %q", bc.Line(), want)
+	}
+	if bc.String() != "-tags=a" {
+		t.Errorf("String() = %q, want -tags=a", bc.String())
+	}
+}
+
+func TestPostProcessorBuildConstraint(t *testing.T) {
+	pp := PostProcessor{PackageName: "calc", BuildConstraint: "//go:build integration"}
+	got, err := pp.Process("//go:build linux\n\n// Tests of calc.\npackage calc\n\nimport \"testing\"\n\nfunc TestX(t *testing.T) {}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "//go:build integration\n\n// Tests of calc.\npackage calc\n") || strings.Contains(got, "linux") {
+		t.Errorf("Process() =\n%s", got)
+	}
+}
+
+func TestTestRunner_CrossCompiled(t *testing.T) {
+	otherOS := "windows"
+	if runtime.GOOS == otherOS {
+		otherOS = "linux"
+	}
+	dir := t.TempDir()
+	source := "calc_" + otherOS + ".go"
+	for name, content := range map[string]string{
+		"go.mod": "module example.com/calc\n\ngo 1.22\n",
+		source:   "package calc\n\nfunc Add(a, b int) int { return a + b }\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build, err := ReadBuildContext(filepath.Join(dir, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !build.CrossCompiled() {
+		t.Fatalf("ReadBuildContext(%s) = %+v, want a cross-compiled context", source, build)
+	}
+	runner := TestRunner{TestFile: "calc_gen_test.go", Build: build}
+
+	for _, tt := range []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		// Never run: it would fail if it were
+		{"compiles", `t.Fatal("ran on the host")`, false},
+		{"type error", `var s string = Add(1, 2); _ = s`, true},
+		{"vet error", `t.Errorf("%d", "two")`, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			content := build.Line() + "\n\npackage calc\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {\n\t" + tt.body + "\n}\n"
+			output, err := runner.Run(context.Background(), dir, content, "-run=^TestAdd$", "-count=1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Run() error = %v, wantErr %v\n%s", err, tt.wantErr, output)
+			}
+		})
+	}
+}
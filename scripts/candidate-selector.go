@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultTemperature keeps a single generation consistent; extra candidates
+// sample hotter to reach branches the first one missed.
+const defaultTemperature = 0.3
+
+// candidateTemperature returns the temperature of the i-th candidate: 0.3,
+// 0.6, 0.9, then 1.0 for the rest.
+func candidateTemperature(i int) float32 {
+	temperature := defaultTemperature + 0.3*float32(i)
+	if temperature > 1 {
+		temperature = 1
+	}
+	return temperature
+}
+
+// CandidateResult describes one of several test files generated for the
+// same source, as measured when choosing between them.
+type CandidateResult struct {
+	Index       int
+	Temperature float32
+	Prompt      string // version of the prompt variant used
+	Tests       int    // Test functions left after the safety and quality gates
+	Passing     int
+	Covered     int // statements of the target functions the passing tests cover
+	Total       int // statements of the target functions
+	Selected    bool
+	Merged      []string // tests of this candidate merged into the selected one
+	Error       string   // why the candidate was discarded
+}
+
+// candidate is a generated test file that passed the safety and quality
+// gates, with the coverage of its passing tests once measured.
+type candidate struct {
+	prompt     string // version of the prompt it was generated from
+	content    string
+	violations []Violation
+	style      []Violation
+	quality    []TestQuality
+	dropped    []DroppedTest
+
+	passing []string
+	covered map[string]int // statements of each covered block of the target functions
+}
+
+func (c candidate) statements() int {
+	total := 0
+	for _, statements := range c.covered {
+		total += statements
+	}
+	return total
+}
+
+// generateCandidate runs one generation through the gates every model
+// output has to pass.
+func (tg *TestGenerator) generateCandidate(ctx context.Context, gt generationTarget, prompt Prompt, temperature float32, renderer TableRenderer, fakes []Fake) (candidate, error) {
+	c := candidate{prompt: prompt.Version}
+	postProcessor := gt.postProcessor
+	content, err := tg.generateWithModel(ctx, prompt, temperature, gt.functions, renderer, postProcessor)
+	if err != nil {
+		return c, err
+	}
+	if len(fakes) > 0 {
+		// Models sometimes paste the fakes in again
+		if content, err = postProcessor.removeDeclarations(content, fakeDeclarations(fakes)); err != nil {
+			return c, err
+		}
+	}
+	// Nothing the model wrote runs before passing the policy
+	if content, c.violations, c.dropped, err = tg.enforceSafetyPolicy(content, postProcessor); err != nil {
+		return c, err
+	}
+	// In JSON mode the renderer decides the style
+	if tg.options.OutputMode == OutputModeCode {
+		var offStyle []DroppedTest
+		if content, c.style, offStyle, err = tg.enforceStyle(content, gt.style, postProcessor); err != nil {
+			return c, err
+		}
+		c.dropped = append(c.dropped, offStyle...)
+	}
+	var weak []DroppedTest
+	if c.content, c.quality, weak, err = tg.enforceQuality(ctx, prompt, content, gt.functions, renderer, postProcessor); err != nil {
+		return c, err
+	}
+	c.dropped = append(c.dropped, weak...)
+	return c, nil
+}
+
+// generateCandidates asks the model for Candidates test files at rising
+// temperatures, cycling through the prompt variants, measures how much of
+// the target functions the passing tests of each cover, and returns the
+// best one with the passing tests of the others that reach statements it
+// does not merged in. With a single candidate, or tests for another
+// platform, nothing is run and no results are returned.
+func (tg *TestGenerator) generateCandidates(ctx context.Context, gt generationTarget, prompts []Prompt, renderer TableRenderer, sourcePath string, fakes []Fake) (candidate, []CandidateResult, error) {
+	// Choosing runs the tests, which is impossible for another platform
+	if tg.options.Candidates <= 1 || gt.build.CrossCompiled() {
+		c, err := tg.generateCandidate(ctx, gt, prompts[0], defaultTemperature, renderer, fakes)
+		return c, nil, err
+	}
+
+	var results []CandidateResult
+	var candidates []*candidate
+	var firstErr error
+	for i := 0; i < tg.options.Candidates; i++ {
+		prompt := prompts[i%len(prompts)]
+		result := CandidateResult{Index: i + 1, Temperature: candidateTemperature(i), Prompt: prompt.Version}
+		c, err := tg.generateCandidate(ctx, gt, prompt, result.Temperature, renderer, fakes)
+		if err == nil {
+			result.Total, err = tg.measureCandidate(ctx, gt, sourcePath, &c)
+		}
+		if err != nil {
+			log.Printf("Discarding test candidate %d for %s: %v", result.Index, sourcePath, err)
+			result.Error = err.Error()
+			if firstErr == nil {
+				firstErr = err
+			}
+			results = append(results, result)
+			candidates = append(candidates, nil)
+			continue
+		}
+
+		declared, err := declaredFunctions(c.content)
+		if err != nil {
+			return candidate{}, nil, err
+		}
+		result.Tests = len(testFunctions(declared))
+		result.Passing = len(c.passing)
+		result.Covered = c.statements()
+		log.Printf("Test candidate %d for %s (prompt %s, temperature %.1f): %d of %d tests pass, covering %d of %d statements",
+			result.Index, sourcePath, result.Prompt, result.Temperature, result.Passing, result.Tests, result.Covered, result.Total)
+		results = append(results, result)
+		candidates = append(candidates, &c)
+	}
+
+	// Most statements covered wins, then most passing tests, then the
+	// cooler candidate
+	order := make([]int, 0, len(candidates))
+	for i, c := range candidates {
+		if c != nil {
+			order = append(order, i)
+		}
+	}
+	if len(order) == 0 {
+		return candidate{}, results, firstErr
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ca, cb := candidates[order[a]], candidates[order[b]]
+		if ca.statements() != cb.statements() {
+			return ca.statements() > cb.statements()
+		}
+		return len(ca.passing) > len(cb.passing)
+	})
+
+	best := *candidates[order[0]]
+	results[order[0]].Selected = true
+	merged := false
+	for _, i := range order[1:] {
+		tests, err := tg.mergeCandidate(ctx, gt, sourcePath, &best, *candidates[i])
+		if err != nil {
+			log.Printf("Could not merge tests of candidate %d for %s: %v", i+1, sourcePath, err)
+			continue
+		}
+		results[i].Merged = tests
+		merged = merged || len(tests) > 0
+	}
+	if merged && len(best.quality) > 0 {
+		quality, err := AnalyzeTestQuality(best.content, gt.functions)
+		if err != nil {
+			return candidate{}, nil, err
+		}
+		best.quality = quality
+	}
+	return best, results, nil
+}
+
+// mergeCandidate adds the passing tests of other that cover statements best
+// does not to best, one at a time, keeping each only if it still passes
+// next to best's tests. Tests are renamed when best already declares the
+// name, and other's helper functions come along when best lacks them.
+func (tg *TestGenerator) mergeCandidate(ctx context.Context, gt generationTarget, sourcePath string, best *candidate, other candidate) ([]string, error) {
+	if !coversNew(other.covered, best.covered) {
+		return nil, nil
+	}
+	declared, err := declaredFunctions(other.content)
+	if err != nil {
+		return nil, err
+	}
+	helpers := make(map[string]bool)
+	for name := range declared {
+		if !strings.HasPrefix(name, "Test") {
+			helpers[name] = true
+		}
+	}
+
+	var merged []string
+	for _, test := range other.passing {
+		existing, err := declaredFunctions(best.content)
+		if err != nil {
+			return merged, err
+		}
+		name := test
+		for n := 2; existing[name]; n++ {
+			name = fmt.Sprintf("%s%d", test, n)
+		}
+		extra, err := extractDeclarations(other.content, map[string]bool{test: true})
+		if err != nil {
+			return merged, err
+		}
+		extra = strings.Replace(extra, "func "+test+"(", "func "+name+"(", 1)
+		needed := make(map[string]bool)
+		for helper := range helpers {
+			if !existing[helper] {
+				needed[helper] = true
+			}
+		}
+		if len(needed) > 0 {
+			helperSource, err := extractDeclarations(other.content, needed)
+			if err != nil {
+				return merged, err
+			}
+			extra += helperSource
+		}
+
+		content, err := gt.postProcessor.Process(best.content + "\n" + extra)
+		if err != nil {
+			log.Printf("Not merging %s: %v", test, err)
+			continue
+		}
+		outcomes, blocks, err := tg.runWithCoverage(ctx, gt, content, []string{name})
+		if err != nil {
+			return merged, err
+		}
+		if outcomes.passed[name] == 0 || outcomes.failed[name] > 0 {
+			log.Printf("Not merging %s: it does not pass next to the selected tests", test)
+			continue
+		}
+		covered, _ := targetBlocks(blocks, sourcePath, gt.functions)
+		if !coversNew(covered, best.covered) {
+			continue
+		}
+
+		best.content = content
+		best.passing = append(best.passing, name)
+		for block, statements := range covered {
+			best.covered[block] = statements
+		}
+		merged = append(merged, name)
+	}
+	return merged, nil
+}
+
+// measureCandidate records which tests of c pass and the blocks of the
+// target functions they cover, and returns the statement count of the
+// target functions. A candidate that does not compile covers nothing.
+func (tg *TestGenerator) measureCandidate(ctx context.Context, gt generationTarget, sourcePath string, c *candidate) (int, error) {
+	declared, err := declaredFunctions(c.content)
+	if err != nil {
+		return 0, err
+	}
+	tests := testFunctions(declared)
+	c.covered = make(map[string]int)
+	if len(tests) == 0 {
+		return 0, nil
+	}
+
+	outcomes, blocks, err := tg.runWithCoverage(ctx, gt, c.content, tests)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range tests {
+		if outcomes.passed[name] > 0 && outcomes.failed[name] == 0 {
+			c.passing = append(c.passing, name)
+		}
+	}
+	if len(c.passing) == 0 {
+		_, total := targetBlocks(blocks, sourcePath, gt.functions)
+		return total, nil
+	}
+	if len(c.passing) < len(tests) {
+		// Failing tests may cover what only a broken assertion reaches
+		if _, blocks, err = tg.runWithCoverage(ctx, gt, c.content, c.passing); err != nil {
+			return 0, err
+		}
+	}
+	var total int
+	c.covered, total = targetBlocks(blocks, sourcePath, gt.functions)
+	return total, nil
+}
+
+// runWithCoverage runs the named tests of content with a coverage profile.
+// When the tests do not build there are no outcomes and no blocks.
+func (tg *TestGenerator) runWithCoverage(ctx context.Context, gt generationTarget, content string, tests []string) (testOutcomes, []coverBlock, error) {
+	dir, err := os.MkdirTemp("", "autotest-cover-*")
+	if err != nil {
+		return testOutcomes{}, nil, fmt.Errorf("failed to create coverage directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	profile := filepath.Join(dir, "coverage.out")
+
+	runner := gt.runner(5 * time.Minute)
+	runner.MaxOutput = flakeRunMaxOutput
+	output, runErr := runner.Run(ctx, gt.dir, content, "-json", "-count=1", "-run="+namesPattern(tests), "-coverprofile="+profile)
+	outcomes := parseTestEvents(output)
+	if _, err := os.Stat(profile); err != nil {
+		log.Printf("No coverage profile from the generated tests: %v", runErr)
+		return outcomes, nil, nil
+	}
+	blocks, err := parseCoverProfile(profile)
+	if err != nil {
+		return outcomes, nil, err
+	}
+	return outcomes, blocks, nil
+}
+
+// targetBlocks picks the blocks of the source file that lie in the target
+// functions out of a coverage profile. It returns the statements of each
+// covered block, keyed by position, and the statements of all of them.
+func targetBlocks(blocks []coverBlock, sourcePath string, functions []FunctionInfo) (map[string]int, int) {
+	covered := make(map[string]int)
+	total := 0
+	base := "/" + filepath.Base(sourcePath)
+	for _, block := range blocks {
+		if !strings.HasSuffix(block.file, base) {
+			continue
+		}
+		inTarget := false
+		for _, fn := range functions {
+			if block.startLine >= fn.StartLine && block.endLine <= fn.EndLine {
+				inTarget = true
+				break
+			}
+		}
+		if !inTarget {
+			continue
+		}
+		total += block.statements
+		if block.count > 0 {
+			covered[block.pos] = block.statements
+		}
+	}
+	return covered, total
+}
+
+// coversNew reports whether covered reaches a block that existing does not.
+func coversNew(covered, existing map[string]int) bool {
+	for block := range covered {
+		if _, ok := existing[block]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// testFunctions returns the sorted Test functions among the declared names.
+func testFunctions(declared map[string]bool) []string {
+	var tests []string
+	for name := range declared {
+		if strings.HasPrefix(name, "Test") {
+			tests = append(tests, name)
+		}
+	}
+	sort.Strings(tests)
+	return tests
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTargetBlocks(t *testing.T) {
+	blocks := []coverBlock{
+		{file: "example.com/calc/calc.go", pos: "3.30,5.2", startLine: 3, endLine: 5, statements: 1, count: 1},
+		{file: "example.com/calc/calc.go", pos: "5.2,7.3", startLine: 5, endLine: 7, statements: 2, count: 0},
+		{file: "example.com/calc/calc.go", pos: "7.3,7.20", startLine: 7, endLine: 7, statements: 1, count: 3},
+		// Outside the target function
+		{file: "example.com/calc/calc.go", pos: "10.20,12.2", startLine: 10, endLine: 12, statements: 4, count: 1},
+		// Same lines in another file
+		{file: "example.com/calc/other.go", pos: "3.30,5.2", startLine: 3, endLine: 5, statements: 1, count: 1},
+	}
+	functions := []FunctionInfo{{Name: "Divide", StartLine: 3, EndLine: 8}}
+
+	covered, total := targetBlocks(blocks, "pkg/calc/calc.go", functions)
+	want := map[string]int{"3.30,5.2": 1, "7.3,7.20": 1}
+	if !reflect.DeepEqual(covered, want) {
+		t.Errorf("covered = %v, want %v", covered, want)
+	}
+	if total != 4 {
+		t.Errorf("total = %d, want 4", total)
+	}
+
+	if coversNew(map[string]int{"3.30,5.2": 1}, covered) {
+		t.Errorf("coversNew() = true for a block already covered")
+	}
+	if !coversNew(map[string]int{"5.2,7.3": 2}, covered) {
+		t.Errorf("coversNew() = false for an uncovered block")
+	}
+}
+
+func TestCandidateTemperature(t *testing.T) {
+	var got []float32
+	for i := 0; i < 5; i++ {
+		got = append(got, candidateTemperature(i))
+	}
+	want := []float32{0.3, 0.6, 0.90000004, 1, 1}
+	for i := range want {
+		if diff := got[i] - want[i]; diff > 0.001 || diff < -0.001 {
+			t.Errorf("candidateTemperature(%d) = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// stubModel replies to the i-th request with replies[i].
+type stubModel struct {
+	replies      []string
+	temperatures []float32
+}
+
+func (m *stubModel) Generate(ctx context.Context, prompt Prompt, temperature float32) (string, error) {
+	m.temperatures = append(m.temperatures, temperature)
+	return m.replies[len(m.temperatures)-1], nil
+}
+
+func TestGenerateCandidates(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod": "module example.com/calc\n\ngo 1.22\n",
+		"calc.go": `package calc
+
+func Abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	model := &stubModel{replies: []string{
+		// Covers x >= 0
+		`package calc
+
+import "testing"
+
+func TestAbs(t *testing.T) {
+	if got := Abs(2); got != 2 {
+		t.Errorf("Abs(2) = %d, want 2", got)
+	}
+}
+`,
+		// Covers x < 0 as much as the first covers x >= 0, through a helper
+		`package calc
+
+import "testing"
+
+func TestAbs(t *testing.T) {
+	expect(t, Abs(-2), 2)
+}
+
+func expect(t *testing.T, got, want int) {
+	t.Helper()
+	if got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+`,
+		// Covers everything, but fails
+		`package calc
+
+import "testing"
+
+func TestAbs(t *testing.T) {
+	if Abs(-1) != -1 || Abs(1) != 1 {
+		t.Error("wrong")
+	}
+}
+`,
+	}}
+	tg := &TestGenerator{model: model, options: GeneratorOptions{Candidates: 3}}
+	gt := generationTarget{
+		dir:           dir,
+		testFile:      "calc_gen_test.go",
+		functions:     []FunctionInfo{{Name: "Abs", StartLine: 3, EndLine: 8}},
+		postProcessor: PostProcessor{PackageName: "calc"},
+	}
+	best, results, err := tg.generateCandidates(context.Background(), gt, []Prompt{{Version: "default/5"}}, TableRenderer{PackageName: "calc"}, filepath.Join(dir, "calc.go"), nil)
+	if err != nil {
+		t.Fatalf("generateCandidates returned error: %v", err)
+	}
+
+	if want := []float32{0.3, 0.6, 0.90000004}; !reflect.DeepEqual(model.temperatures, want) {
+		t.Errorf("temperatures = %v, want %v", model.temperatures, want)
+	}
+	want := []CandidateResult{
+		// Tied with the second, and cooler
+		{Index: 1, Temperature: 0.3, Prompt: "default/5", Tests: 1, Passing: 1, Covered: 2, Total: 3, Selected: true},
+		{Index: 2, Temperature: 0.6, Prompt: "default/5", Tests: 1, Passing: 1, Covered: 2, Total: 3, Merged: []string{"TestAbs2"}},
+		{Index: 3, Temperature: 0.90000004, Prompt: "default/5", Tests: 1, Passing: 0, Covered: 0, Total: 3},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results =\n%+v\nwant\n%+v", results, want)
+	}
+
+	// TestAbs of the second candidate is renamed and brings its helper
+	if !reflect.DeepEqual(best.passing, []string{"TestAbs", "TestAbs2"}) || best.statements() != 3 {
+		t.Errorf("best passing = %v covering %d statements, want TestAbs and TestAbs2 covering 3", best.passing, best.statements())
+	}
+	for _, want := range []string{"if got := Abs(2); got != 2", "func TestAbs2(t *testing.T) {\n\texpect(t, Abs(-2), 2)", "func expect(t *testing.T, got, want int)"} {
+		if !strings.Contains(best.content, want) {
+			t.Errorf("best content is missing %q:\n%s", want, best.content)
+		}
+	}
+	if strings.Contains(best.content, "wrong") {
+		t.Errorf("failing test was merged:\n%s", best.content)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -15,33 +16,22 @@ import (
 )
 
 type CoverageAnalyzer struct {
-	fileSet *token.FileSet
+	fileSet  *token.FileSet
+	policy   SelectionPolicy
+	repoRoot string
 }
 
-type FunctionInfo struct {
-	Name     string
-	Content  string
-	StartLine int
-	EndLine   int
-}
-
-func NewCoverageAnalyzer() *CoverageAnalyzer {
+func NewCoverageAnalyzer(policy SelectionPolicy, repoRoot string) *CoverageAnalyzer {
 	return &CoverageAnalyzer{
-		fileSet: token.NewFileSet(),
+		fileSet:  token.NewFileSet(),
+		policy:   policy,
+		repoRoot: repoRoot,
 	}
 }
 
-// resolveFilePath converts relative paths to absolute paths from repo root
+// resolveFilePath converts paths relative to the repo root to paths usable from here
 func (ca *CoverageAnalyzer) resolveFilePath(filePath string) string {
-	// Check if we're running from scripts directory
-	if wd, err := os.Getwd(); err == nil {
-		if strings.HasSuffix(wd, "/scripts") || strings.HasSuffix(wd, "\\scripts") {
-			// We're in scripts directory, so prepend ../ to access repo root files
-			return filepath.Join("..", filePath)
-		}
-	}
-	// Otherwise, use the path as-is
-	return filePath
+	return resolveRepoPath(ca.repoRoot, filePath)
 }
 
 func (ca *CoverageAnalyzer) AnalyzeFile(ctx context.Context, filePath string, threshold float64) (needsTests bool, coverage float64, err error) {
@@ -55,37 +45,51 @@ func (ca *CoverageAnalyzer) AnalyzeFile(ctx context.Context, filePath string, th
 		return true, 0.0, nil
 	}
 
-	// Run coverage analysis for the specific package
-	packageDir := filepath.Dir(resolvedPath)
-	if packageDir == "" {
-		packageDir = "."
+	// Build the package the way the file is built, or it may not be in it
+	build, err := ReadBuildContext(resolvedPath)
+	if err != nil {
+		return false, 0.0, err
 	}
 
-	// Change to the package directory for running tests
-	originalDir, err := os.Getwd()
-	if err != nil {
-		return false, 0.0, fmt.Errorf("failed to get current directory: %v", err)
+	// Tests built for another platform cannot run here, so their coverage
+	// is unknown; tests of functions they already cover are left out later
+	if build.CrossCompiled() {
+		log.Printf("Cannot measure the coverage of %s: its tests do not run on this host (%s)", filePath, build)
+		return true, 0.0, nil
 	}
 
-	// Convert package directory to absolute path
-	absPackageDir, err := filepath.Abs(packageDir)
+	// Run coverage analysis for the specific package
+	absPackageDir, err := filepath.Abs(filepath.Dir(resolvedPath))
 	if err != nil {
 		return false, 0.0, fmt.Errorf("failed to get absolute path: %v", err)
 	}
 
-	// Change to package directory
-	if err := os.Chdir(absPackageDir); err != nil {
-		return false, 0.0, fmt.Errorf("failed to change to package directory: %v", err)
+	// Run from the root of the package's module, which need not be the
+	// repository's: nested modules and workspace members have their own
+	moduleRoot, err := findModuleRoot(absPackageDir)
+	if err != nil {
+		return false, 0.0, err
+	}
+	relDir, err := filepath.Rel(moduleRoot, absPackageDir)
+	if err != nil {
+		return false, 0.0, fmt.Errorf("failed to get package path: %v", err)
+	}
+	pattern := "."
+	if relDir != "." {
+		pattern = "./" + filepath.ToSlash(relDir)
 	}
 
-	// Ensure we change back to original directory
-	defer func() {
-		os.Chdir(originalDir)
-		// Clean up coverage file
-		os.Remove("coverage.out")
-	}()
+	profile, err := os.CreateTemp("", "coverage-*.out")
+	if err != nil {
+		return false, 0.0, fmt.Errorf("failed to create coverage profile: %v", err)
+	}
+	profile.Close()
+	defer os.Remove(profile.Name())
 
-	cmd := exec.CommandContext(ctx, "go", "test", "-cover", "-coverprofile=coverage.out", ".")
+	args := append([]string{"test", "-cover", "-coverprofile=" + profile.Name()}, build.Flags()...)
+	cmd := exec.CommandContext(ctx, "go", append(args, pattern)...)
+	cmd.Dir = moduleRoot
+	cmd.Env = commandEnv(moduleRoot, build)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		// If tests fail to run, we might still want to generate tests
@@ -141,60 +145,32 @@ func (ca *CoverageAnalyzer) ExtractModifiedFunctions(ctx context.Context, filePa
 		return nil, fmt.Errorf("failed to parse file: %v", err)
 	}
 
+	// Generated code is regenerated rather than edited, so it is never a target
+	if ast.IsGenerated(node) {
+		return nil, nil
+	}
+
 	var functions []FunctionInfo
 
-	// Extract all functions
-	ast.Inspect(node, func(n ast.Node) bool {
-		switch fn := n.(type) {
-		case *ast.FuncDecl:
-			if fn.Name.IsExported() || ca.shouldIncludeFunction(fn) {
-				startPos := ca.fileSet.Position(fn.Pos())
-				endPos := ca.fileSet.Position(fn.End())
-				
-				// Extract function content
-				lines := strings.Split(string(content), "\n")
-				var funcContent strings.Builder
-				
-				for i := startPos.Line - 1; i < endPos.Line && i < len(lines); i++ {
-					funcContent.WriteString(lines[i])
-					funcContent.WriteString("\n")
-				}
-
-				functions = append(functions, FunctionInfo{
-					Name:      fn.Name.Name,
-					Content:   funcContent.String(),
-					StartLine: startPos.Line,
-					EndLine:   endPos.Line,
-				})
-			}
+	for _, decl := range node.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
 		}
-		return true
-	})
-
-	return functions, nil
-}
-
-func (ca *CoverageAnalyzer) shouldIncludeFunction(fn *ast.FuncDecl) bool {
-	// Include functions that are:
-	// 1. Exported (public)
-	// 2. Have significant logic (more than just getters/setters)
-	// 3. Are not test functions
-	
-	if fn.Name == nil {
-		return false
+		info := ca.newFunctionInfo(fn, content)
+		if include, reason := ca.policy.Include(fn, info); !include {
+			log.Printf("Skipping %s: %s", info.QualifiedName(), reason)
+			continue
+		}
+		functions = append(functions, info)
 	}
 
-	name := fn.Name.Name
-	
-	// Skip test functions
-	if strings.HasPrefix(name, "Test") || strings.HasPrefix(name, "Benchmark") {
-		return false
+	// Generic functions can only be called with concrete type arguments
+	if err := ca.instantiateGenerics(ctx, filePath, functions); err != nil {
+		log.Printf("Could not instantiate the generic functions of %s: %v", filePath, err)
 	}
 
-	// Skip simple getters/setters (heuristic: less than 3 statements)
-	if fn.Body != nil && len(fn.Body.List) < 3 {
-		return false
-	}
+	prioritizeFunctions(functions)
 
-	return true
-}
\ No newline at end of file
+	return functions, nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/types"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// ExampleName returns the godoc example name for the function, e.g.
+// "ExampleCalculator_Divide" for a method and "ExampleAdd" for a function.
+func (fi FunctionInfo) ExampleName() string {
+	if fi.Receiver == "" {
+		return "Example" + fi.Name
+	}
+	return "Example" + fi.Receiver + "_" + fi.Name
+}
+
+// exampleTarget is the template data for one Example function.
+type exampleTarget struct {
+	ExampleName  string
+	RecvName     string
+	Receiver     string // expression building the receiver, empty for functions
+	Call         string
+	Args         []string
+	Results      []string // printed results, excluding a trailing error
+	ReturnsError bool
+	Output       []string // expected output lines, filled in after a capture run
+}
+
+var exampleTemplate = template.Must(template.New("example").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`
+func {{.ExampleName}}() {
+	{{- if .Receiver}}
+	{{.RecvName}} := {{.Receiver}}
+	{{- end}}
+	{{join .Results ", "}}{{if .ReturnsError}}, err{{end}} := {{.Call}}({{join .Args ", "}})
+	{{- if .ReturnsError}}
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	{{- end}}
+	fmt.Println({{join .Results ", "}})
+	// Output:
+	{{- range .Output}}
+	// {{.}}
+	{{- end}}
+}
+`))
+
+// exampleValues are readable arguments for generated examples, per kind.
+var exampleValues = map[types.BasicInfo][]string{
+	types.IsInteger: {"12", "4", "3"},
+	types.IsFloat:   {"7.5", "2.5"},
+	types.IsString:  {`"hello"`, `"world"`},
+	types.IsBoolean: {"true", "false"},
+}
+
+// ExampleGenerator emits runnable godoc examples for exported functions
+// with printable results. The expected output is not guessed: the examples
+// are run once and their actual output becomes the // Output: block.
+type ExampleGenerator struct {
+	Qualifier string       // package name prefix for external tests, empty otherwise
+	Build     BuildContext // tags and platform the package is loaded with
+}
+
+// Generate returns the example targets for the exported functions among
+// functions that have no example in the test file yet.
+func (eg ExampleGenerator) Generate(ctx context.Context, dir string, functions []FunctionInfo, declared map[string]bool) ([]exampleTarget, error) {
+	var todo []FunctionInfo
+	for _, fn := range functions {
+		if fn.Exported() && !declared[fn.ExampleName()] {
+			todo = append(todo, fn)
+		}
+	}
+	if len(todo) == 0 {
+		return nil, nil
+	}
+
+	pkg, err := loadPackageDir(ctx, dir, eg.Build)
+	if err != nil {
+		return nil, err
+	}
+	objects := make(map[string]*types.Func)
+	for fn, qualified := range packageFunctions(pkg) {
+		objects[qualified] = fn
+	}
+
+	var targets []exampleTarget
+	for _, fn := range todo {
+		obj, ok := objects[fn.QualifiedName()]
+		if !ok {
+			continue
+		}
+		sig := obj.Type().(*types.Signature)
+		if sig.TypeParams().Len() > 0 || (sig.Recv() != nil && sig.RecvTypeParams().Len() > 0) {
+			continue
+		}
+		target, ok := eg.exampleTarget(fn, sig, receiverValue(pkg, fn, eg.Qualifier))
+		if !ok {
+			log.Printf("Skipping example for %s: arguments or results cannot be written out", fn.QualifiedName())
+			continue
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+func (eg ExampleGenerator) exampleTarget(fn FunctionInfo, sig *types.Signature, receiver string) (exampleTarget, bool) {
+	target := exampleTarget{ExampleName: fn.ExampleName(), Call: fn.Name}
+	if eg.Qualifier != "" {
+		target.Call = eg.Qualifier + "." + fn.Name
+	}
+	if fn.Receiver != "" {
+		target.RecvName = strings.ToLower(fn.Receiver[:1])
+		if target.RecvName == eg.Qualifier {
+			target.RecvName = "recv"
+		}
+		target.Receiver = receiver
+		target.Call = target.RecvName + "." + fn.Name
+	}
+
+	for i := 0; i < sig.Params().Len(); i++ {
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			break
+		}
+		value, ok := exampleValue(sig.Params().At(i).Type(), i)
+		if !ok {
+			return exampleTarget{}, false
+		}
+		target.Args = append(target.Args, value)
+	}
+
+	for i := 0; i < sig.Results().Len(); i++ {
+		t := sig.Results().At(i).Type()
+		if i == sig.Results().Len()-1 && isErrorType(t) {
+			target.ReturnsError = true
+			continue
+		}
+		if !printable(t) {
+			return exampleTarget{}, false
+		}
+		name := "result"
+		if i > 0 {
+			name = fmt.Sprint("result", i)
+		}
+		target.Results = append(target.Results, name)
+	}
+
+	// Examples that print nothing document nothing
+	return target, len(target.Results) > 0
+}
+
+// exampleValue returns a readable literal of basic type t for the i-th
+// argument of an example call.
+func exampleValue(t types.Type, i int) (string, bool) {
+	if s, ok := t.(*types.Slice); ok && isByte(s.Elem()) {
+		return `[]byte("hello")`, true
+	}
+	b, ok := t.(*types.Basic)
+	if !ok {
+		return "", false
+	}
+	for kind, values := range exampleValues {
+		if b.Info()&kind == 0 {
+			continue
+		}
+		value := values[i%len(values)]
+		if b.Kind() == types.Int || b.Kind() == types.Float64 || b.Kind() == types.String || b.Kind() == types.Bool {
+			return value, true
+		}
+		return b.Name() + "(" + value + ")", true
+	}
+	return "", false
+}
+
+// printable reports whether fmt prints values of type t the same way on
+// every run: no pointers, functions or channels whose addresses would leak
+// into the output.
+func printable(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return u.Kind() != types.UnsafePointer && u.Kind() != types.Uintptr
+	case *types.Slice:
+		return printable(u.Elem())
+	case *types.Array:
+		return printable(u.Elem())
+	case *types.Map:
+		// fmt sorts map keys
+		return printable(u.Key()) && printable(u.Elem())
+	}
+	return false
+}
+
+// addExamples completes content with examples for the exported functions,
+// recording their actual output, and then keeps only the Example functions
+// whose output matches on repeated runs.
+func (tg *TestGenerator) addExamples(ctx context.Context, gt generationTarget, content string) (string, []string, []DroppedTest, error) {
+	declared, err := declaredFunctions(content)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	runner := gt.runner(5 * time.Minute)
+	var dropped []DroppedTest
+
+	targets, err := ExampleGenerator{Qualifier: gt.qualifier, Build: gt.build}.Generate(ctx, gt.dir, gt.functions, declared)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if len(targets) > 0 {
+		// Run the examples with an empty // Output: block; the failure
+		// report contains what they actually print
+		withoutOutput, err := renderExamples(content, targets, gt.postProcessor)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		var names []string
+		for _, target := range targets {
+			names = append(names, target.ExampleName)
+		}
+		output, _ := runner.Run(ctx, gt.dir, withoutOutput, "-run="+namesPattern(names), "-count=1")
+
+		var captured []exampleTarget
+		for _, target := range targets {
+			got, ok := exampleOutput(output, target.ExampleName)
+			if !ok || got == "" {
+				dropped = append(dropped, DroppedTest{Name: target.ExampleName, Reason: "could not record its output"})
+				continue
+			}
+			target.Output = strings.Split(got, "\n")
+			captured = append(captured, target)
+		}
+		if content, err = renderExamples(content, captured, gt.postProcessor); err != nil {
+			return "", nil, nil, err
+		}
+		if declared, err = declaredFunctions(content); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	var examples []string
+	for name := range declared {
+		if strings.HasPrefix(name, "Example") {
+			examples = append(examples, name)
+		}
+	}
+	sort.Strings(examples)
+	if len(examples) == 0 {
+		return content, nil, dropped, nil
+	}
+
+	// Repeated runs catch output that depends on time, randomness or
+	// map iteration order
+	output, err := runner.Run(ctx, gt.dir, content, "-run="+namesPattern(examples), "-count=3")
+	if err == nil {
+		return content, examples, dropped, nil
+	}
+
+	failed := make(map[string]bool)
+	var passed []string
+	for _, name := range examples {
+		if strings.Contains(output, "--- FAIL: "+name+" ") || strings.Contains(output, "--- FAIL: "+name+"\n") {
+			failed[name] = true
+			dropped = append(dropped, DroppedTest{Name: name, Reason: "output is wrong or not deterministic"})
+			continue
+		}
+		passed = append(passed, name)
+	}
+	if len(failed) == 0 {
+		// Nothing ran, so the file does not compile with the examples
+		log.Printf("Generated tests with examples do not compile, dropping the examples: %v\n%s", err, output)
+		for _, name := range examples {
+			failed[name] = true
+			dropped = append(dropped, DroppedTest{Name: name, Reason: "does not compile"})
+		}
+		passed = nil
+	}
+	if content, err = gt.postProcessor.removeDeclarations(content, failed); err != nil {
+		return "", nil, nil, err
+	}
+
+	return content, passed, dropped, nil
+}
+
+// renderExamples appends the rendered targets to content.
+func renderExamples(content string, targets []exampleTarget, postProcessor PostProcessor) (string, error) {
+	if len(targets) == 0 {
+		return content, nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString(content)
+	for _, target := range targets {
+		if err := exampleTemplate.Execute(&buf, target); err != nil {
+			return "", fmt.Errorf("failed to render example %s: %v", target.ExampleName, err)
+		}
+	}
+	return postProcessor.Process(buf.String())
+}
+
+// exampleOutput extracts what example name printed from the go test report
+// of it failing against an empty // Output: block.
+func exampleOutput(output, name string) (string, bool) {
+	start := strings.Index(output, "--- FAIL: "+name+" (")
+	if start < 0 {
+		return "", false
+	}
+	rest := output[start:]
+	gotStart := strings.Index(rest, "\ngot:\n")
+	wantStart := strings.Index(rest, "\nwant:\n")
+	if gotStart < 0 || wantStart < gotStart {
+		return "", false
+	}
+	got := rest[gotStart+len("\ngot:\n") : wantStart]
+
+	// Output comments cannot represent trailing spaces or blank lines reliably
+	lines := strings.Split(got, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+		if lines[i] == "" {
+			return "", false
+		}
+	}
+	return strings.Join(lines, "\n"), true
+}
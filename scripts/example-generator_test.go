@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExampleOutput(t *testing.T) {
+	output := `--- FAIL: ExampleCalculator_Divide (0.00s)
+got:
+3
+want:
+
+--- FAIL: ExampleGreet (0.00s)
+got:
+hello
+  world
+want:
+
+--- FAIL: ExampleBlank (0.00s)
+got:
+a
+
+b
+want:
+
+FAIL
+`
+
+	tests := []struct {
+		name   string
+		want   string
+		wantOK bool
+	}{
+		{"ExampleCalculator_Divide", "3", true},
+		{"ExampleGreet", "hello\n  world", true},
+		{"ExampleBlank", "", false},
+		{"ExampleMissing", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := exampleOutput(output, tt.name)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("exampleOutput(%s) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestExampleGenerator_Generate(t *testing.T) {
+	dir, functions := writeCalcModule(t, `package calc
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Calculator struct{ history []string }
+
+func NewCalculator() *Calculator { return &Calculator{} }
+
+func (c *Calculator) Divide(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	c.history = append(c.history, fmt.Sprintf("%d / %d", a, b))
+	return a / b, nil
+}
+
+func Greet(name string) string { return "Hello, " + name + "!" }
+
+func double(x int) int { return 2 * x }
+`)
+
+	pp := PostProcessor{PackageName: "calc_test", ImportName: "calc", ImportPath: "example.com/calc"}
+	targets, err := ExampleGenerator{Qualifier: "calc"}.Generate(context.Background(), dir, functions, nil)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.ExampleName)
+	}
+	sort.Strings(names)
+	// NewCalculator is skipped as its result cannot be printed
+	if want := []string{"ExampleCalculator_Divide", "ExampleGreet"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Generate() examples = %v, want %v", names, want)
+	}
+
+	// The recorded output must be what the examples print
+	tg := &TestGenerator{}
+	gt := generationTarget{dir: dir, testFile: "calc_gen_test.go", functions: functions, qualifier: "calc", postProcessor: pp}
+	base, err := pp.Process("package calc_test\n\nimport \"testing\"\n\nfunc TestNothing(t *testing.T) {}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, examples, dropped, err := tg.addExamples(context.Background(), gt, base)
+	if err != nil {
+		t.Fatalf("addExamples returned error: %v", err)
+	}
+	for _, want := range []string{"// Output:\n\t// Hello, hello!", "// Output:\n\t// 3\n"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("examples are missing %q:\n%s", want, content)
+		}
+	}
+	if len(examples) != 2 || len(dropped) != 0 {
+		t.Errorf("addExamples() kept %v, dropped %v", examples, dropped)
+	}
+	runner := gt.runner(5 * time.Minute)
+	if output, err := runner.Run(context.Background(), dir, content, "-run=^Example", "-count=1", "-v"); err != nil || !strings.Contains(output, "--- PASS: ExampleGreet") {
+		t.Fatalf("generated examples fail: %v\n%s\n%s", err, output, content)
+	}
+}
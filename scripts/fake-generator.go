@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fake is a hand-rolled test double generated for an interface that a
+// target function depends on.
+type Fake struct {
+	Name      string   // type name of the fake, e.g. fakeStore
+	Interface string   // the interface as written in the tests, e.g. Store or io.Reader
+	Methods   []string // methods of the interface, in declaration order
+}
+
+// FakeGenerator writes fakes for the interfaces used by the parameters of
+// the target functions and by the fields of their receivers and struct
+// parameters. Each fake records its calls and returns what the test
+// configures through one func field per method, or zero values.
+type FakeGenerator struct {
+	Qualifier string       // package name prefix for external tests, empty otherwise
+	Build     BuildContext // tags and platform the package is loaded with
+
+	// Reserved holds names the test package already declares, which the
+	// fakes must not take.
+	Reserved map[string]bool
+}
+
+// HelperFile is an additional _test.go file published alongside the
+// generated tests, named relative to the package directory.
+type HelperFile struct {
+	Name    string
+	Content string
+}
+
+// Generate returns the fakes for the interfaces the functions depend on and
+// the declarations implementing them, with the imports they need. Both are
+// empty when there is nothing to fake.
+func (fg FakeGenerator) Generate(ctx context.Context, dir string, functions []FunctionInfo) ([]Fake, string, error) {
+	pkg, err := loadPackageDir(ctx, dir, fg.Build)
+	if err != nil {
+		return nil, "", err
+	}
+	objects := make(map[string]*types.Func)
+	for fn, qualified := range packageFunctions(pkg) {
+		objects[qualified] = fn
+	}
+
+	found := make(map[*types.TypeName]bool)
+	var order []*types.TypeName
+	add := func(t types.Type) {
+		for _, tn := range fakeableInterfaces(t, pkg.Types, fg.Qualifier != "") {
+			if !found[tn] {
+				found[tn] = true
+				order = append(order, tn)
+			}
+		}
+	}
+	for _, fn := range functions {
+		obj, ok := objects[fn.QualifiedName()]
+		if !ok {
+			continue
+		}
+		sig := obj.Type().(*types.Signature)
+		if recv := sig.Recv(); recv != nil {
+			addFieldTypes(recv.Type(), add)
+		}
+		for i := 0; i < sig.Params().Len(); i++ {
+			t := sig.Params().At(i).Type()
+			add(t)
+			addFieldTypes(t, add)
+		}
+	}
+	if len(order) == 0 {
+		return nil, "", nil
+	}
+
+	imports := make(map[string]bool)
+	qualifier := func(p *types.Package) string {
+		if p == pkg.Types {
+			return fg.Qualifier
+		}
+		imports[p.Path()] = true
+		return p.Name()
+	}
+
+	// Name fakes after their interface, qualifying with the package name
+	// only when two interfaces share a name
+	names := make(map[string]int)
+	for _, tn := range order {
+		names[tn.Name()]++
+	}
+
+	var fakes []Fake
+	var body strings.Builder
+	for _, tn := range order {
+		name := "fake" + capitalize(tn.Name())
+		if names[tn.Name()] > 1 {
+			name = "fake" + capitalize(tn.Pkg().Name()) + capitalize(tn.Name())
+		}
+		name = fg.freeName(pkg.Types, name, tn.Type().Underlying().(*types.Interface))
+		fake := Fake{Name: name, Interface: types.TypeString(tn.Type(), qualifier)}
+		fg.writeFake(&body, &fake, tn.Type().Underlying().(*types.Interface), qualifier)
+		fakes = append(fakes, fake)
+	}
+
+	var src strings.Builder
+	if len(imports) > 0 {
+		var paths []string
+		for path := range imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		src.WriteString("import (\n")
+		for _, path := range paths {
+			src.WriteString("\t" + strconv.Quote(path) + "\n")
+		}
+		src.WriteString(")\n")
+	}
+	src.WriteString(body.String())
+
+	return fakes, src.String(), nil
+}
+
+// freeName returns name, or name followed by a number, such that neither
+// the fake nor its call record types clash with the names the test package
+// declares.
+func (fg FakeGenerator) freeName(pkg *types.Package, name string, iface *types.Interface) string {
+	taken := func(n string) bool {
+		return fg.Reserved[n] || (fg.Qualifier == "" && pkg.Scope().Lookup(n) != nil)
+	}
+	for i := 1; ; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%d", name, i)
+		}
+		free := !taken(candidate)
+		for j := 0; free && j < iface.NumMethods(); j++ {
+			free = !taken(candidate + capitalize(iface.Method(j).Name()) + "Call")
+		}
+		if free {
+			return candidate
+		}
+	}
+}
+
+// writeFake renders the fake struct, one call record type per method and
+// the methods themselves.
+func (fg FakeGenerator) writeFake(out *strings.Builder, fake *Fake, iface *types.Interface, qualifier types.Qualifier) {
+	fmt.Fprintf(out, "\n// %s is a fake %s for tests.\n", fake.Name, fake.Interface)
+	out.WriteString("// Set <Method>Func to control what a method returns; calls are recorded\n")
+	out.WriteString("// in <Method>Calls.\n")
+	fmt.Fprintf(out, "type %s struct {\n\tmu sync.Mutex\n", fake.Name)
+	for i := 0; i < iface.NumMethods(); i++ {
+		m := iface.Method(i)
+		sig := m.Type().(*types.Signature)
+		fake.Methods = append(fake.Methods, m.Name())
+		fmt.Fprintf(out, "\n\t%sFunc  func%s\n", m.Name(), strings.TrimPrefix(types.TypeString(sig, qualifier), "func"))
+		fmt.Fprintf(out, "\t%sCalls []%s%sCall\n", m.Name(), fake.Name, capitalize(m.Name()))
+	}
+	out.WriteString("}\n")
+
+	for i := 0; i < iface.NumMethods(); i++ {
+		m := iface.Method(i)
+		sig := m.Type().(*types.Signature)
+		callType := fake.Name + capitalize(m.Name()) + "Call"
+
+		params := fakeParamNames(sig)
+		fmt.Fprintf(out, "\n// %s records the arguments of a call to %s.%s.\n", callType, fake.Name, m.Name())
+		fmt.Fprintf(out, "type %s struct {\n", callType)
+		for i, name := range params {
+			fmt.Fprintf(out, "\t%s %s\n", capitalize(name), types.TypeString(sig.Params().At(i).Type(), qualifier))
+		}
+		out.WriteString("}\n")
+
+		var decl, record, args []string
+		for i, name := range params {
+			typ := types.TypeString(sig.Params().At(i).Type(), qualifier)
+			arg := name
+			if sig.Variadic() && i == len(params)-1 {
+				typ = "..." + strings.TrimPrefix(typ, "[]")
+				arg += "..."
+			}
+			decl = append(decl, name+" "+typ)
+			record = append(record, capitalize(name)+": "+name)
+			args = append(args, arg)
+		}
+		var results []string
+		for i := 0; i < sig.Results().Len(); i++ {
+			results = append(results, types.TypeString(sig.Results().At(i).Type(), qualifier))
+		}
+		resultList := strings.Join(results, ", ")
+		if len(results) > 1 {
+			resultList = "(" + resultList + ")"
+		}
+
+		fmt.Fprintf(out, "\nfunc (f *%s) %s(%s) %s {\n", fake.Name, m.Name(), strings.Join(decl, ", "), resultList)
+		out.WriteString("\tf.mu.Lock()\n")
+		fmt.Fprintf(out, "\tf.%sCalls = append(f.%sCalls, %s{%s})\n", m.Name(), m.Name(), callType, strings.Join(record, ", "))
+		fmt.Fprintf(out, "\tfn := f.%sFunc\n", m.Name())
+		out.WriteString("\tf.mu.Unlock()\n")
+		out.WriteString("\tif fn != nil {\n\t\t")
+		if len(results) > 0 {
+			out.WriteString("return ")
+		}
+		fmt.Fprintf(out, "fn(%s)\n", strings.Join(args, ", "))
+		if len(results) == 0 {
+			out.WriteString("\t\treturn\n")
+		}
+		out.WriteString("\t}\n")
+		if len(results) > 0 {
+			var zeros []string
+			for i := 0; i < sig.Results().Len(); i++ {
+				zeros = append(zeros, zeroValue(sig.Results().At(i).Type(), qualifier))
+			}
+			fmt.Fprintf(out, "\treturn %s\n", strings.Join(zeros, ", "))
+		}
+		out.WriteString("}\n")
+	}
+}
+
+// fakeParamNames returns usable parameter names for a fake method: the
+// interface's own names where they are valid and unique, argN otherwise.
+func fakeParamNames(sig *types.Signature) []string {
+	used := map[string]bool{"f": true, "fn": true}
+	var names []string
+	for i := 0; i < sig.Params().Len(); i++ {
+		name := sig.Params().At(i).Name()
+		if name == "" || name == "_" || used[name] {
+			name = fmt.Sprintf("arg%d", i)
+		}
+		used[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// fakeableInterfaces returns the named interfaces in t that a fake can
+// implement from the test package. error, context.Context and empty
+// interfaces have better stand-ins than a fake.
+func fakeableInterfaces(t types.Type, pkg *types.Package, external bool) []*types.TypeName {
+	switch t := t.(type) {
+	case *types.Pointer:
+		return fakeableInterfaces(t.Elem(), pkg, external)
+	case *types.Slice:
+		return fakeableInterfaces(t.Elem(), pkg, external)
+	case *types.Map:
+		return fakeableInterfaces(t.Elem(), pkg, external)
+	case *types.Named:
+		iface, ok := t.Underlying().(*types.Interface)
+		if !ok || iface.NumMethods() == 0 || !iface.IsMethodSet() || t.TypeParams().Len() > 0 {
+			return nil
+		}
+		tn := t.Obj()
+		if tn.Pkg() == nil || (tn.Pkg().Path() == "context" && tn.Name() == "Context") {
+			return nil
+		}
+		if tn.Pkg() == pkg && external && !tn.Exported() {
+			return nil
+		}
+		for i := 0; i < iface.NumMethods(); i++ {
+			m := iface.Method(i)
+			if !m.Exported() && (m.Pkg() != pkg || external) {
+				return nil
+			}
+			if !nameable(m.Type(), pkg, external, make(map[types.Type]bool)) {
+				return nil
+			}
+		}
+		if fakeFieldsClash(iface) {
+			return nil
+		}
+		return []*types.TypeName{tn}
+	}
+	return nil
+}
+
+// nameable reports whether every type in t can be written in the test
+// package: unexported types and fields are only reachable from inside
+// their own package.
+func nameable(t types.Type, pkg *types.Package, external bool, seen map[types.Type]bool) bool {
+	if seen[t] {
+		return true
+	}
+	seen[t] = true
+	visible := func(obj types.Object) bool {
+		return obj.Exported() || obj.Pkg() == nil || (obj.Pkg() == pkg && !external)
+	}
+
+	switch t := t.(type) {
+	case *types.Named:
+		if !visible(t.Obj()) {
+			return false
+		}
+		if args := t.TypeArgs(); args != nil {
+			for i := 0; i < args.Len(); i++ {
+				if !nameable(args.At(i), pkg, external, seen) {
+					return false
+				}
+			}
+		}
+		return true
+	case *types.Alias:
+		return visible(t.Obj()) && nameable(types.Unalias(t), pkg, external, seen)
+	case *types.Pointer:
+		return nameable(t.Elem(), pkg, external, seen)
+	case *types.Slice:
+		return nameable(t.Elem(), pkg, external, seen)
+	case *types.Array:
+		return nameable(t.Elem(), pkg, external, seen)
+	case *types.Chan:
+		return nameable(t.Elem(), pkg, external, seen)
+	case *types.Map:
+		return nameable(t.Key(), pkg, external, seen) && nameable(t.Elem(), pkg, external, seen)
+	case *types.Signature:
+		for _, tuple := range []*types.Tuple{t.Params(), t.Results()} {
+			for i := 0; i < tuple.Len(); i++ {
+				if !nameable(tuple.At(i).Type(), pkg, external, seen) {
+					return false
+				}
+			}
+		}
+		return true
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if !visible(t.Field(i)) || !nameable(t.Field(i).Type(), pkg, external, seen) {
+				return false
+			}
+		}
+		return true
+	case *types.Interface:
+		for i := 0; i < t.NumMethods(); i++ {
+			if !visible(t.Method(i)) || !nameable(t.Method(i).Type(), pkg, external, seen) {
+				return false
+			}
+		}
+		return true
+	case *types.TypeParam:
+		return false
+	}
+	return true
+}
+
+// fakeFieldsClash reports whether the fields and types generated for the
+// methods of iface would clash: a method named like the <Method>Func or
+// <Method>Calls field of another, or like the mu field, or two methods
+// whose call record types get the same name.
+func fakeFieldsClash(iface *types.Interface) bool {
+	names := map[string]bool{"mu": true}
+	callTypes := make(map[string]bool)
+	for i := 0; i < iface.NumMethods(); i++ {
+		name := iface.Method(i).Name()
+		for _, field := range []string{name + "Func", name + "Calls"} {
+			if names[field] {
+				return true
+			}
+			names[field] = true
+		}
+		if callTypes[capitalize(name)] {
+			return true
+		}
+		callTypes[capitalize(name)] = true
+	}
+	for i := 0; i < iface.NumMethods(); i++ {
+		if names[iface.Method(i).Name()] {
+			return true
+		}
+	}
+	return false
+}
+
+// addFieldTypes calls add for the type of every field of the struct t
+// points to or is.
+func addFieldTypes(t types.Type, add func(types.Type)) {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		add(st.Field(i).Type())
+	}
+}
+
+// generateFakes renders the fakes for the interface dependencies of the
+// target functions as a helper file named after the source file.
+func (tg *TestGenerator) generateFakes(ctx context.Context, gt generationTarget, sourceFile string) ([]Fake, HelperFile, error) {
+	fakes, src, err := FakeGenerator{Qualifier: gt.qualifier, Build: gt.build, Reserved: gt.postProcessor.Reserved}.Generate(ctx, gt.dir, gt.functions)
+	if err != nil || len(fakes) == 0 {
+		return nil, HelperFile{}, err
+	}
+
+	content, err := gt.postProcessor.Process("package " + gt.postProcessor.PackageName + "\n\n" + src)
+	if err != nil {
+		return nil, HelperFile{}, fmt.Errorf("generated fakes are invalid: %v", err)
+	}
+
+	return fakes, HelperFile{
+		Name:    strings.TrimSuffix(sourceFile, ".go") + "_fakes_test.go",
+		Content: content,
+	}, nil
+}
+
+// fakeDeclarations returns the names of the types declared for fakes.
+func fakeDeclarations(fakes []Fake) map[string]bool {
+	names := make(map[string]bool)
+	for _, fake := range fakes {
+		names[fake.Name] = true
+		for _, method := range fake.Methods {
+			names[fake.Name+capitalize(method)+"Call"] = true
+		}
+	}
+	return names
+}
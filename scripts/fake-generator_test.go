@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFakeGenerator_Generate(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod": "module example.com/store\n\ngo 1.22\n",
+		"store.go": `package store
+
+import "context"
+
+type key struct{ id int }
+
+type Store interface {
+	Get(ctx context.Context, k string) (string, error)
+	Put(k string, values ...string) error
+}
+
+type Index interface {
+	Lookup(k key) error
+}
+
+type Clashing interface {
+	Get() error
+	GetFunc() error
+}
+
+type Service struct {
+	Store    Store
+	Index    Index
+	Clashing Clashing
+}
+
+func (s *Service) Run(ctx context.Context) error { return nil }
+`,
+		"store_test.go": `package store
+
+type fakeStore struct{}
+`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	functions := []FunctionInfo{{Name: "Run", Receiver: "Service"}}
+
+	for _, tt := range []struct {
+		name    string
+		pp      PostProcessor
+		want    []string
+		useFake string
+	}{
+		{
+			// key is not reachable from store_test
+			name:    "external",
+			pp:      PostProcessor{PackageName: "store_test", ImportName: "store", ImportPath: "example.com/store"},
+			want:    []string{"fakeStore"},
+			useFake: "fakeStore",
+		},
+		{
+			// fakeStore is taken by store_test.go
+			name:    "internal",
+			pp:      PostProcessor{PackageName: "store", Reserved: map[string]bool{"fakeStore": true}},
+			want:    []string{"fakeStore2", "fakeIndex"},
+			useFake: "fakeStore2",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fg := FakeGenerator{Qualifier: tt.pp.ImportName, Reserved: tt.pp.Reserved}
+			fakes, src, err := fg.Generate(context.Background(), dir, functions)
+			if err != nil {
+				t.Fatalf("Generate returned error: %v", err)
+			}
+			var names []string
+			for _, fake := range fakes {
+				names = append(names, fake.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Fatalf("Generate() fakes = %v, want %v", names, tt.want)
+			}
+
+			helper, err := tt.pp.Process("package " + tt.pp.PackageName + "\n\n" + src)
+			if err != nil {
+				t.Fatalf("generated fakes are invalid: %v\n%s", err, src)
+			}
+			content := "package " + tt.pp.PackageName + `
+
+import "testing"
+
+func TestFakes(t *testing.T) {
+	f := &` + tt.useFake + `{}
+	f.PutFunc = func(k string, values ...string) error { return nil }
+	if err := f.Put("k", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.PutCalls[0].Values; len(got) != 2 || got[1] != "b" {
+		t.Errorf("PutCalls[0].Values = %v", got)
+	}
+}
+`
+			runner := TestRunner{
+				TestFile: "store_gen_test.go",
+				Helpers:  []HelperFile{{Name: "store_gen_fakes_test.go", Content: helper}},
+			}
+			if output, err := runner.Run(context.Background(), dir, content, "-run=^TestFakes$", "-count=1"); err != nil {
+				t.Fatalf("generated fakes do not work: %v\n%s\n%s", err, output, helper)
+			}
+		})
+	}
+}
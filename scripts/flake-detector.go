@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FlakyDrop removes flaky tests before publishing.
+	FlakyDrop = "drop"
+	// FlakyQuarantine keeps flaky tests but makes them skip themselves,
+	// so reviewers can see and fix them.
+	FlakyQuarantine = "quarantine"
+)
+
+// flakeRunMaxOutput leaves room for the verbose go test -json output of
+// repeated runs.
+const flakeRunMaxOutput = 16 << 20
+
+// FlakyTest is a generated test whose result was not the same on every
+// run of the flakiness gate.
+type FlakyTest struct {
+	Name        string
+	Reason      string
+	Quarantined bool // kept with a t.Skip rather than dropped
+}
+
+// testEvent is the subset of a go test -json event the gate reads.
+type testEvent struct {
+	Action string
+	Test   string
+	Output string
+}
+
+// testOutcomes counts how often each top-level test passed and failed over
+// repeated runs, and which of them the race detector complained about.
+type testOutcomes struct {
+	passed      map[string]int
+	failed      map[string]int
+	raced       map[string]bool
+	failedSeeds map[string][]string // -shuffle seeds of the runs a test failed in, if reported
+}
+
+// parseTestEvents reads go test -json output. Subtest results are folded
+// into their top-level test, which fails whenever a subtest does.
+func parseTestEvents(output string) testOutcomes {
+	outcomes := testOutcomes{
+		passed:      make(map[string]int),
+		failed:      make(map[string]int),
+		raced:       make(map[string]bool),
+		failedSeeds: make(map[string][]string),
+	}
+	seed := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// Build errors and truncation notices are not JSON
+			continue
+		}
+		if s, ok := strings.CutPrefix(strings.TrimSpace(event.Output), "-test.shuffle "); ok {
+			seed = s
+		}
+		if event.Test == "" {
+			continue
+		}
+		name, _, isSubtest := strings.Cut(event.Test, "/")
+		switch {
+		case event.Action == "output" && (strings.Contains(event.Output, "WARNING: DATA RACE") || strings.Contains(event.Output, "race detected during execution of test")):
+			outcomes.raced[name] = true
+		case event.Action == "pass" && !isSubtest:
+			outcomes.passed[name]++
+		case event.Action == "fail" && !isSubtest:
+			outcomes.failed[name]++
+			if seed != "" && !slices.Contains(outcomes.failedSeeds[name], seed) {
+				outcomes.failedSeeds[name] = append(outcomes.failedSeeds[name], seed)
+			}
+		}
+	}
+	return outcomes
+}
+
+// merge adds the outcomes of another invocation to o.
+func (o testOutcomes) merge(other testOutcomes) {
+	for name, n := range other.passed {
+		o.passed[name] += n
+	}
+	for name, n := range other.failed {
+		o.failed[name] += n
+	}
+	for name := range other.raced {
+		o.raced[name] = true
+	}
+	for name, seeds := range other.failedSeeds {
+		for _, seed := range seeds {
+			if !slices.Contains(o.failedSeeds[name], seed) {
+				o.failedSeeds[name] = append(o.failedSeeds[name], seed)
+			}
+		}
+	}
+}
+
+// flakyReason explains why name is flaky given its outcomes over runs runs,
+// or returns "" when it behaved the same every time.
+func (o testOutcomes) flakyReason(name string, runs int) string {
+	if o.raced[name] {
+		return "the race detector reported a data race"
+	}
+	passed, failed := o.passed[name], o.failed[name]
+	if passed == 0 || failed == 0 {
+		return ""
+	}
+	reason := fmt.Sprintf("passed %d of %d runs", passed, runs)
+	if completed := passed + failed; completed < runs {
+		reason = fmt.Sprintf("passed %d and failed %d of %d runs before the test binary stopped", passed, failed, runs)
+	}
+	if seeds := o.failedSeeds[name]; len(seeds) > 0 {
+		reason += ", failing with -shuffle=" + strings.Join(seeds, ", -shuffle=")
+	}
+	return reason
+}
+
+// checkFlakiness runs the Test functions in content FlakeRuns times, each
+// time in a different shuffled order and under the race detector where the
+// toolchain supports it, and drops or quarantines those whose results differ
+// across runs. Tests that fail consistently are not its concern.
+func (tg *TestGenerator) checkFlakiness(ctx context.Context, gt generationTarget, content string) (string, []FlakyTest, error) {
+	declared, err := declaredFunctions(content)
+	if err != nil {
+		return "", nil, err
+	}
+	tests := testFunctions(declared)
+	if len(tests) == 0 {
+		return content, nil, nil
+	}
+
+	// -count repeats tests in the order of a single shuffle, so each run is
+	// its own invocation with its own seed
+	runs := tg.options.FlakeRuns
+	runner := gt.runner(10 * time.Minute)
+	runner.MaxOutput = flakeRunMaxOutput
+	race := tg.options.FlakeRace
+	base := time.Now().UnixNano()
+	outcomes := parseTestEvents("")
+	for i := 0; i < runs; i++ {
+		args := []string{"-json", "-run=" + namesPattern(tests), "-count=1", "-shuffle=" + strconv.FormatInt(base+int64(i), 10)}
+		if race {
+			args = append(args, "-race")
+		}
+		output, err := runner.Run(ctx, gt.dir, content, args...)
+		if err != nil && race && (strings.Contains(output, "-race requires cgo") || strings.Contains(output, "-race is not supported")) {
+			log.Printf("Race detector unavailable, checking flakiness without it")
+			race = false
+			output, err = runner.Run(ctx, gt.dir, content, args[:len(args)-1]...)
+		}
+
+		run := parseTestEvents(output)
+		if err != nil && len(run.passed)+len(run.failed) == 0 {
+			// Nothing ran to completion, e.g. a compile error or a hang;
+			// there is nothing to compare if it happens on the first run
+			log.Printf("Could not check generated tests for flakiness: %v\n%s", err, output)
+			if i == 0 {
+				return content, nil, nil
+			}
+			continue
+		}
+		outcomes.merge(run)
+	}
+	reasons := make(map[string]string)
+	for _, name := range tests {
+		if reason := outcomes.flakyReason(name, runs); reason != "" {
+			reasons[name] = reason
+		}
+	}
+	if len(reasons) == 0 {
+		return content, nil, nil
+	}
+
+	var flaky []FlakyTest
+	var unchanged []string
+	if tg.options.FlakyMode == FlakyQuarantine {
+		skips := make(map[string]string)
+		for name, reason := range reasons {
+			skips[name] = "quarantined as flaky: " + reason
+		}
+		if content, unchanged, err = gt.postProcessor.skipTests(content, skips); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Tests that cannot be quarantined are dropped like in FlakyDrop mode
+	drop := make(map[string]bool)
+	for _, name := range tests {
+		reason, ok := reasons[name]
+		if !ok {
+			continue
+		}
+		quarantined := tg.options.FlakyMode == FlakyQuarantine && !slices.Contains(unchanged, name)
+		if !quarantined {
+			drop[name] = true
+		}
+		log.Printf("Generated test %s is flaky: %s", name, reason)
+		flaky = append(flaky, FlakyTest{Name: name, Reason: reason, Quarantined: quarantined})
+	}
+	if len(drop) > 0 {
+		if content, err = gt.postProcessor.removeDeclarations(content, drop); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return content, flaky, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestParseTestEvents(t *testing.T) {
+	output := `{"Action":"output","Output":"-test.shuffle 42\n"}
+{"Action":"run","Test":"TestOrder"}
+{"Action":"pass","Test":"TestOrder"}
+{"Action":"pass","Test":"TestRacy"}
+{"Action":"run","Test":"TestTable/case"}
+{"Action":"fail","Test":"TestTable/case"}
+{"Action":"fail","Test":"TestTable"}
+{"Action":"fail","Test":"TestOrder"}
+{"Action":"output","Test":"TestRacy","Output":"WARNING: DATA RACE\n"}
+{"Action":"pass","Test":"TestTable"}
+{"Action":"pass","Test":"TestStable"}
+{"Action":"pass","Test":"TestStable"}
+{"Action":"fail","Test":"TestBroken"}
+{"Action":"fail","Test":"TestBroken"}
+# some/package
+not json
+`
+	outcomes := parseTestEvents(output)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"TestOrder", "passed 1 of 2 runs, failing with -shuffle=42"},
+		{"TestTable", "passed 1 of 2 runs, failing with -shuffle=42"},
+		{"TestRacy", "the race detector reported a data race"},
+		{"TestStable", ""},
+		{"TestBroken", ""},
+	}
+	for _, tt := range tests {
+		if got := outcomes.flakyReason(tt.name, 2); got != tt.want {
+			t.Errorf("flakyReason(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if got := outcomes.flakyReason("TestOrder", 3); got != "passed 1 and failed 1 of 3 runs before the test binary stopped, failing with -shuffle=42" {
+		t.Errorf("flakyReason with missing runs = %q", got)
+	}
+}
+
+func TestTestOutcomesMerge(t *testing.T) {
+	// One invocation per shuffle seed, each with -count=1
+	outcomes := parseTestEvents("")
+	for _, output := range []string{
+		`{"Action":"output","Output":"-test.shuffle 1\n"}
+{"Action":"pass","Test":"TestA"}
+{"Action":"pass","Test":"TestB"}
+`,
+		`{"Action":"output","Output":"-test.shuffle 2\n"}
+{"Action":"fail","Test":"TestB"}
+{"Action":"pass","Test":"TestA"}
+`,
+		`{"Action":"output","Output":"-test.shuffle 3\n"}
+{"Action":"fail","Test":"TestB"}
+{"Action":"output","Test":"TestA","Output":"WARNING: DATA RACE\n"}
+{"Action":"pass","Test":"TestA"}
+`,
+	} {
+		outcomes.merge(parseTestEvents(output))
+	}
+
+	if got, want := outcomes.flakyReason("TestB", 3), "passed 1 of 3 runs, failing with -shuffle=2, -shuffle=3"; got != want {
+		t.Errorf("flakyReason(TestB) = %q, want %q", got, want)
+	}
+	if got, want := outcomes.flakyReason("TestA", 3), "the race detector reported a data race"; got != want {
+		t.Errorf("flakyReason(TestA) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// FunctionInfo describes a function or method selected for test generation.
+type FunctionInfo struct {
+	Name          string
+	Receiver      string // receiver type name without pointer or type arguments, empty for plain functions
+	PointerRecv   bool   // the method has a pointer receiver
+	Signature     string // full declaration line, e.g. "func (c *Calculator) Divide(a, b int) (int, error)"
+	Params        []Param
+	Results       []Param
+	Doc           string
+	ReturnsError  bool     // the last result is an error, which tests check separately
+	Complexity    int      // cyclomatic complexity of the body
+	References    []string // package-level identifiers and selectors used in the body
+	ExistingTests []string // tests already exercising the function, see TestIndex
+	Content       string
+	StartLine     int
+	EndLine       int
+
+	// TypeParams are the type parameters of a generic function or of the
+	// receiver type of a method, Instantiations type arguments known to
+	// satisfy their constraints, and TypeArgs the ones the function is
+	// called with once instantiated, see Instantiate.
+	TypeParams     []TypeParam
+	Instantiations [][]string
+	TypeArgs       []string
+}
+
+// Param is a single parameter or result of a function signature.
+type Param struct {
+	Name string
+	Type string
+}
+
+// Exported reports whether tests outside the package can call the
+// function: its name is exported and so is its receiver type, if any.
+func (fi FunctionInfo) Exported() bool {
+	return token.IsExported(fi.Name) && (fi.Receiver == "" || token.IsExported(fi.Receiver))
+}
+
+// QualifiedName returns the function name prefixed by its receiver type,
+// e.g. "Calculator.Add", so methods never collide with package functions.
+func (fi FunctionInfo) QualifiedName() string {
+	if fi.Receiver == "" {
+		return fi.Name
+	}
+	return fi.Receiver + "." + fi.Name
+}
+
+// TestName returns the conventional test function name, e.g.
+// "TestCalculator_Add" for a method and "TestAdd" for a function.
+func (fi FunctionInfo) TestName() string {
+	if fi.Receiver == "" {
+		return "Test" + capitalize(fi.Name)
+	}
+	return "Test" + capitalize(fi.Receiver) + "_" + fi.Name
+}
+
+func (ca *CoverageAnalyzer) newFunctionInfo(fn *ast.FuncDecl, content []byte) FunctionInfo {
+	startPos := ca.fileSet.Position(fn.Pos())
+	endPos := ca.fileSet.Position(fn.End())
+
+	// Extract function content
+	lines := strings.Split(string(content), "\n")
+	var funcContent strings.Builder
+
+	for i := startPos.Line - 1; i < endPos.Line && i < len(lines); i++ {
+		funcContent.WriteString(lines[i])
+		funcContent.WriteString("\n")
+	}
+
+	info := FunctionInfo{
+		Name:        fn.Name.Name,
+		Receiver:    receiverTypeName(fn),
+		PointerRecv: fn.Recv != nil && len(fn.Recv.List) > 0 && isPointerType(fn.Recv.List[0].Type),
+		Signature:   functionSignature(fn),
+		Params:      fieldListParams(fn.Type.Params, "arg"),
+		Results:     fieldListParams(fn.Type.Results, "res"),
+		Doc:         strings.TrimSpace(fn.Doc.Text()),
+		Complexity:  cyclomaticComplexity(fn),
+		References:  referencedIdentifiers(fn),
+		TypeParams:  typeParamsOf(fn),
+		Content:     funcContent.String(),
+		StartLine:   startPos.Line,
+		EndLine:     endPos.Line,
+	}
+
+	if n := len(info.Results); n > 0 && info.Results[n-1].Type == "error" {
+		info.ReturnsError = true
+	}
+
+	return info
+}
+
+// receiverTypeName returns the base type name of a method receiver.
+func receiverTypeName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+
+	expr := fn.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return types.ExprString(expr)
+		}
+	}
+}
+
+func isPointerType(expr ast.Expr) bool {
+	if paren, ok := expr.(*ast.ParenExpr); ok {
+		return isPointerType(paren.X)
+	}
+	_, ok := expr.(*ast.StarExpr)
+	return ok
+}
+
+// functionSignature renders the declaration of fn without its body.
+func functionSignature(fn *ast.FuncDecl) string {
+	var sig strings.Builder
+	sig.WriteString("func ")
+
+	if fn.Recv != nil && len(fn.Recv.List) > 0 {
+		sig.WriteString("(")
+		sig.WriteString(fieldListString(fn.Recv))
+		sig.WriteString(") ")
+	}
+
+	sig.WriteString(fn.Name.Name)
+	if fn.Type.TypeParams != nil {
+		sig.WriteString("[")
+		sig.WriteString(fieldListString(fn.Type.TypeParams))
+		sig.WriteString("]")
+	}
+
+	sig.WriteString("(")
+	sig.WriteString(fieldListString(fn.Type.Params))
+	sig.WriteString(")")
+
+	if results := fn.Type.Results; results != nil && len(results.List) > 0 {
+		sig.WriteString(" ")
+		if len(results.List) == 1 && len(results.List[0].Names) == 0 {
+			sig.WriteString(types.ExprString(results.List[0].Type))
+		} else {
+			sig.WriteString("(")
+			sig.WriteString(fieldListString(results))
+			sig.WriteString(")")
+		}
+	}
+
+	return sig.String()
+}
+
+func fieldListString(list *ast.FieldList) string {
+	if list == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, field := range list.List {
+		typ := types.ExprString(field.Type)
+		if len(field.Names) == 0 {
+			parts = append(parts, typ)
+			continue
+		}
+		var names []string
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+		parts = append(parts, strings.Join(names, ", ")+" "+typ)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// fieldListParams flattens a field list into one Param per value, naming
+// unnamed or blank entries with the given prefix and their position.
+func fieldListParams(list *ast.FieldList, prefix string) []Param {
+	if list == nil {
+		return nil
+	}
+
+	var params []Param
+	for _, field := range list.List {
+		typ := types.ExprString(field.Type)
+		if len(field.Names) == 0 {
+			params = append(params, Param{Name: fmt.Sprintf("%s%d", prefix, len(params)), Type: typ})
+			continue
+		}
+		for _, name := range field.Names {
+			paramName := name.Name
+			if paramName == "_" {
+				paramName = fmt.Sprintf("%s%d", prefix, len(params))
+			}
+			params = append(params, Param{Name: paramName, Type: typ})
+		}
+	}
+	return params
+}
+
+// cyclomaticComplexity counts the independent paths through fn: one plus
+// each branch point and short-circuit operator. Function literals are
+// separate functions, so their branches are not counted.
+func cyclomaticComplexity(fn *ast.FuncDecl) int {
+	complexity := 1
+	if fn.Body == nil {
+		return complexity
+	}
+
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+			complexity++
+		case *ast.CaseClause:
+			if node.List != nil {
+				complexity++
+			}
+		case *ast.CommClause:
+			if node.Comm != nil {
+				complexity++
+			}
+		case *ast.BinaryExpr:
+			if node.Op == token.LAND || node.Op == token.LOR {
+				complexity++
+			}
+		}
+		return true
+	})
+
+	return complexity
+}
+
+// referencedIdentifiers lists the package-level functions, imported package
+// members and receiver fields or methods used in the body of fn.
+func referencedIdentifiers(fn *ast.FuncDecl) []string {
+	if fn.Body == nil {
+		return nil
+	}
+
+	recvName := ""
+	if fn.Recv != nil && len(fn.Recv.List) > 0 && len(fn.Recv.List[0].Names) > 0 {
+		recvName = fn.Recv.List[0].Names[0].Name
+	}
+	recvType := receiverTypeName(fn)
+
+	seen := make(map[string]bool)
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.SelectorExpr:
+			x, ok := node.X.(*ast.Ident)
+			if !ok {
+				// Only the operand can reference anything; the selector
+				// itself is a field or method name.
+				ast.Inspect(node.X, visit)
+				return false
+			}
+			if recvName != "" && x.Name == recvName {
+				seen[recvType+"."+node.Sel.Name] = true
+			} else if x.Obj == nil {
+				// Unresolved by the parser: an imported package or a
+				// package-level variable declared in another file.
+				seen[x.Name+"."+node.Sel.Name] = true
+			}
+			return false
+		case *ast.KeyValueExpr:
+			// Struct literal keys are field names, not references.
+			if _, ok := node.Key.(*ast.Ident); ok {
+				ast.Inspect(node.Value, visit)
+				return false
+			}
+		case *ast.Ident:
+			if node.Obj == nil {
+				if types.Universe.Lookup(node.Name) == nil && node.Name != "_" {
+					seen[node.Name] = true
+				}
+			} else if node.Obj.Kind == ast.Fun || node.Obj.Kind == ast.Typ || node.Obj.Kind == ast.Con {
+				seen[node.Name] = true
+			}
+		}
+		return true
+	}
+	ast.Inspect(fn.Body, visit)
+
+	refs := make([]string, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// prioritizeFunctions orders functions so the ones most likely to hide bugs
+// come first: higher complexity, then those returning errors, then source order.
+func prioritizeFunctions(functions []FunctionInfo) {
+	sort.SliceStable(functions, func(i, j int) bool {
+		a, b := functions[i], functions[j]
+		if a.Complexity != b.Complexity {
+			return a.Complexity > b.Complexity
+		}
+		if a.ReturnsError != b.ReturnsError {
+			return a.ReturnsError
+		}
+		return a.StartLine < b.StartLine
+	})
+}
+
+func capitalize(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+// parseFunc parses src as the body of a file in package p and returns its
+// last function declaration.
+func parseFunc(t *testing.T, src string) *ast.FuncDecl {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "p.go", "package p\n\n"+src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fn *ast.FuncDecl
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*ast.FuncDecl); ok {
+			fn = d
+		}
+	}
+	if fn == nil {
+		t.Fatal("no function declared")
+	}
+	return fn
+}
+
+func TestCyclomaticComplexity(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		want int
+	}{
+		{"straight line", "func f() int { return 1 }", 1},
+		{"no body", "func f() int", 1},
+		{"if and loops", `func f(xs []int) (n int) {
+	for _, x := range xs {
+		if x > 0 {
+			n++
+		}
+	}
+	for i := 0; i < n; i++ {
+	}
+	return n
+}`, 4},
+		{"switch cases, default not counted", `func f(x int) int {
+	switch x {
+	case 1, 2:
+		return 1
+	case 3:
+		return 2
+	default:
+		return 0
+	}
+}`, 3},
+		{"select cases", `func f(a, b chan int) {
+	select {
+	case <-a:
+	case b <- 1:
+	default:
+	}
+}`, 3},
+		{"short-circuit operators", "func f(a, b, c bool) bool { return a && b || c }", 3},
+		{"function literals are not counted", `func f(xs []int) func() bool {
+	if len(xs) == 0 {
+		return nil
+	}
+	return func() bool {
+		for _, x := range xs {
+			if x > 0 && x < 10 {
+				return true
+			}
+		}
+		return false
+	}
+}`, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cyclomaticComplexity(parseFunc(t, tt.src)); got != tt.want {
+				t.Errorf("cyclomaticComplexity() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferencedIdentifiers(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"no body", "func f()", nil},
+		{"locals and builtins", "func f(xs []int) int { n := len(xs); return n }", []string{}},
+		{"imported members and other files", `func f() { fmt.Println(defaultName); helper() }`,
+			[]string{"defaultName", "fmt.Println", "helper"}},
+		{"receiver fields and methods", `type C struct{ n int }
+
+func (c *C) Inc(o *C) { c.n++; c.check(o.n) }`, []string{"C.check", "C.n"}},
+		{"package-level declarations", `type T struct{ Name string }
+
+const limit = 3
+
+func g() {}
+
+func f() { _ = T{Name: "x"}; _ = limit; g() }`, []string{"T", "g", "limit"}},
+		{"fields of other values", "func f(p struct{ x struct{ y int } }) int { return p.x.y + other.x.y }", []string{"other.x"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := referencedIdentifiers(parseFunc(t, tt.src)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("referencedIdentifiers() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFunctionInfo_TestName(t *testing.T) {
+	for _, tt := range []struct {
+		fn   FunctionInfo
+		want string
+	}{
+		{FunctionInfo{Name: "Add"}, "TestAdd"},
+		{FunctionInfo{Name: "parse"}, "TestParse"},
+		{FunctionInfo{Name: "Add", Receiver: "Calculator"}, "TestCalculator_Add"},
+		{FunctionInfo{Name: "reset", Receiver: "state"}, "TestState_reset"},
+	} {
+		if got := tt.fn.TestName(); got != tt.want {
+			t.Errorf("%s.TestName() = %q, want %q", tt.fn.QualifiedName(), got, tt.want)
+		}
+	}
+}
+
+func TestPrioritizeFunctions(t *testing.T) {
+	functions := []FunctionInfo{
+		{Name: "Simple", StartLine: 1, Complexity: 1},
+		{Name: "Fallible", StartLine: 2, Complexity: 1, ReturnsError: true},
+		{Name: "Branchy", StartLine: 3, Complexity: 4},
+		{Name: "Later", StartLine: 4, Complexity: 1},
+		{Name: "BranchyFallible", StartLine: 5, Complexity: 4, ReturnsError: true},
+	}
+	prioritizeFunctions(functions)
+
+	var got []string
+	for _, fn := range functions {
+		got = append(got, fn.Name)
+	}
+	want := []string{"BranchyFallible", "Branchy", "Fallible", "Simple", "Later"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("prioritizeFunctions() order = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// fuzzableTypes are the parameter types testing.F accepts as fuzz arguments,
+// as spelled in source.
+var fuzzableTypes = map[string]bool{
+	"string": true, "[]byte": true, "bool": true, "byte": true, "rune": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"float32": true, "float64": true,
+}
+
+// Fuzzable reports whether every parameter of the function can be a fuzz
+// argument, so a Fuzz target can call it directly with fuzzer inputs.
+func (fi FunctionInfo) Fuzzable() bool {
+	if len(fi.Params) == 0 {
+		return false
+	}
+	for _, p := range fi.Params {
+		if !fuzzableTypes[p.Type] {
+			return false
+		}
+	}
+	return true
+}
+
+// FuzzName returns the Fuzz target name matching TestName, e.g.
+// "FuzzCalculator_Add".
+func (fi FunctionInfo) FuzzName() string {
+	return "Fuzz" + strings.TrimPrefix(fi.TestName(), "Test")
+}
+
+// fuzzTarget is the template data for one Fuzz function.
+type fuzzTarget struct {
+	FuzzName      string
+	FuncName      string
+	Call          string // callee, e.g. recv.Add or Add
+	Receiver      string // expression building a fresh receiver, empty for functions
+	Seeds         [][]string
+	Params        []tableField
+	Guards        []string // conditions under which the input is skipped
+	Results       []string // left-hand side of the first call
+	Repeats       []string // left-hand side of the second call
+	Swapped       []string // left-hand side of the call with swapped operands, empty if not commutative
+	Deterministic bool     // results are compared across two calls
+	ReturnsError  bool
+}
+
+var fuzzTargetTemplate = template.Must(template.New("fuzz").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`
+func {{.FuzzName}}(f *testing.F) {
+{{- range .Seeds}}
+	f.Add({{join . ", "}})
+{{- end}}
+	f.Fuzz(func(t *testing.T{{range .Params}}, {{.Name}} {{.Type}}{{end}}) {
+		{{- range .Guards}}
+		if {{.}} {
+			t.Skip("outside the domain of {{$.FuncName}}()")
+		}
+		{{- end}}
+		{{- if .Receiver}}
+		recv := {{.Receiver}}
+		{{- end}}
+		// Must not panic for any input
+		{{if .Results}}{{join .Results ", "}} := {{end}}{{.Call}}({{range $i, $p := .Params}}{{if $i}}, {{end}}{{$p.Name}}{{end}})
+		{{- if .Deterministic}}
+
+		// Must return the same result for the same input
+		{{- if .Receiver}}
+		recv = {{.Receiver}}
+		{{- end}}
+		{{join .Repeats ", "}} := {{.Call}}({{range $i, $p := .Params}}{{if $i}}, {{end}}{{$p.Name}}{{end}})
+		{{- range $i, $r := .Results}}
+		{{- if or (ne $r "err") (not $.ReturnsError)}}
+		if !reflect.DeepEqual({{$r}}, {{index $.Repeats $i}}) {
+			t.Errorf("{{$.FuncName}}() is not deterministic: got %v, then %v", {{$r}}, {{index $.Repeats $i}})
+		}
+		{{- else}}
+		if (err == nil) != ({{index $.Repeats $i}} == nil) {
+			t.Errorf("{{$.FuncName}}() is not deterministic: error %v, then %v", err, {{index $.Repeats $i}})
+		}
+		{{- end}}
+		{{- end}}
+		{{- else}}
+		{{- range .Results}}
+		_ = {{.}}
+		{{- end}}
+		{{- end}}
+		{{- if .Swapped}}
+
+		// Must not depend on the order of its operands
+		{{- if .Receiver}}
+		recv = {{.Receiver}}
+		{{- end}}
+		{{join .Swapped ", "}} := {{.Call}}({{(index .Params 1).Name}}, {{(index .Params 0).Name}})
+		if !reflect.DeepEqual(got, swapped) {
+			t.Errorf("{{.FuncName}}() is not commutative: got %v, then %v with swapped operands", got, swapped)
+		}
+		{{- end}}
+	})
+}
+`))
+
+// FuzzGenerator emits Go native fuzz targets for fuzzable functions: a seed
+// corpus plus the invariants that hold for any function, namely that it does
+// not panic and, for comparable results, returns the same result twice.
+// Inputs the function rejects up front, or that would divide by zero, are
+// skipped, and functions whose result is a commutative operation on their
+// two operands are also checked with the operands swapped.
+type FuzzGenerator struct {
+	Qualifier string       // package name prefix for external tests, empty otherwise
+	Build     BuildContext // tags and platform the package is loaded with
+}
+
+// Generate renders Fuzz functions for the fuzzable functions whose target is
+// not already declared in the test file. The result is a list of function
+// declarations meant to be appended to that file; imports are left to the
+// PostProcessor.
+func (fg FuzzGenerator) Generate(ctx context.Context, dir string, functions []FunctionInfo, declared map[string]bool) (string, error) {
+	var todo []FunctionInfo
+	for _, fn := range functions {
+		if fn.Fuzzable() && !declared[fn.FuzzName()] {
+			todo = append(todo, fn)
+		}
+	}
+	if len(todo) == 0 {
+		return "", nil
+	}
+
+	pkg, err := loadPackageDir(ctx, dir, fg.Build)
+	if err != nil {
+		return "", err
+	}
+	objects := make(map[string]*types.Func)
+	for fn, qualified := range packageFunctions(pkg) {
+		objects[qualified] = fn
+	}
+	decls := make(map[*types.Func]*ast.FuncDecl)
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil {
+				if obj, ok := pkg.TypesInfo.Defs[fn.Name].(*types.Func); ok {
+					decls[obj] = fn
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, fn := range todo {
+		obj, ok := objects[fn.QualifiedName()]
+		if !ok {
+			continue
+		}
+		sig := obj.Type().(*types.Signature)
+		if sig.TypeParams().Len() > 0 || (sig.Recv() != nil && sig.RecvTypeParams().Len() > 0) || sig.Variadic() {
+			log.Printf("Skipping fuzz target for %s: generic or variadic", fn.QualifiedName())
+			continue
+		}
+		if fg.Qualifier != "" && fn.Receiver != "" && !token.IsExported(fn.Receiver) {
+			continue
+		}
+
+		target := fg.fuzzTarget(fn, sig, receiverValue(pkg, fn, fg.Qualifier))
+		if decl := decls[obj]; decl != nil {
+			fg.addProperties(&target, decl, sig, pkg.TypesInfo, pkg.Types)
+		}
+		if err := fuzzTargetTemplate.Execute(&buf, target); err != nil {
+			return "", fmt.Errorf("failed to render fuzz target for %s: %v", fn.QualifiedName(), err)
+		}
+	}
+
+	return buf.String(), nil
+}
+
+func (fg FuzzGenerator) fuzzTarget(fn FunctionInfo, sig *types.Signature, receiver string) fuzzTarget {
+	target := fuzzTarget{
+		FuzzName: fn.FuzzName(),
+		FuncName: fn.QualifiedName(),
+		Call:     fn.Name,
+	}
+	if fg.Qualifier != "" {
+		target.Call = fg.Qualifier + "." + fn.Name
+	}
+	if fn.Receiver != "" {
+		target.Call = "recv." + fn.Name
+		target.Receiver = receiver
+	}
+
+	// Fuzz argument names must not collide with the names the body uses
+	reserved := map[string]bool{"f": true, "t": true, "recv": true, "err": true, "swapped": true, "reflect": true, "testing": true}
+	for i := 0; i < sig.Params().Len(); i++ {
+		name := sig.Params().At(i).Name()
+		if name == "" || name == "_" || reserved[name] || strings.HasPrefix(name, "got") || strings.HasPrefix(name, "again") {
+			name = fmt.Sprintf("arg%d", i)
+		}
+		target.Params = append(target.Params, tableField{Name: name, Type: sig.Params().At(i).Type().String()})
+	}
+
+	// Three seeds, rotated per parameter so they are not all the same value
+	for row := 0; row < 3; row++ {
+		var seed []string
+		for i := 0; i < sig.Params().Len(); i++ {
+			seed = append(seed, seedValue(sig.Params().At(i).Type(), row+i))
+		}
+		target.Seeds = append(target.Seeds, seed)
+	}
+
+	target.Deterministic = sig.Results().Len() > 0
+	for i := 0; i < sig.Results().Len(); i++ {
+		t := sig.Results().At(i).Type()
+		if i == sig.Results().Len()-1 && isErrorType(t) {
+			target.ReturnsError = true
+			target.Results = append(target.Results, "err")
+			target.Repeats = append(target.Repeats, "againErr")
+			continue
+		}
+		suffix := ""
+		if i > 0 {
+			suffix = fmt.Sprint(i)
+		}
+		target.Results = append(target.Results, "got"+suffix)
+		target.Repeats = append(target.Repeats, "again"+suffix)
+		if !comparableResult(t) {
+			target.Deterministic = false
+		}
+	}
+
+	return target
+}
+
+// addProperties completes target with what the body of fn tells about its
+// domain and its operands: the conditions of leading statements that panic
+// or return an error, a zero check for each integer parameter used as a
+// divisor, and whether the result commutes.
+func (fg FuzzGenerator) addProperties(target *fuzzTarget, fn *ast.FuncDecl, sig *types.Signature, info *types.Info, pkg *types.Package) {
+	params := make(map[types.Object]string)
+	for i := 0; i < sig.Params().Len(); i++ {
+		params[sig.Params().At(i)] = target.Params[i].Name
+	}
+	returnsError := sig.Results().Len() > 0 && isErrorType(sig.Results().At(sig.Results().Len()-1).Type())
+
+	guarded := make(map[string]bool)
+	addGuard := func(cond string) {
+		if !guarded[cond] {
+			guarded[cond] = true
+			target.Guards = append(target.Guards, cond)
+		}
+	}
+	for _, stmt := range fn.Body.List {
+		ifStmt, ok := stmt.(*ast.IfStmt)
+		if !ok || ifStmt.Init != nil || ifStmt.Else != nil || !rejectsInput(ifStmt.Body, returnsError, info) {
+			break
+		}
+		cond, ok := fg.guardExpr(ifStmt.Cond, params, info, pkg)
+		if !ok {
+			break
+		}
+		addGuard(cond)
+	}
+
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		var divisor ast.Expr
+		switch node := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.BinaryExpr:
+			if node.Op == token.QUO || node.Op == token.REM {
+				divisor = node.Y
+			}
+		case *ast.AssignStmt:
+			if node.Tok == token.QUO_ASSIGN || node.Tok == token.REM_ASSIGN {
+				divisor = node.Rhs[0]
+			}
+		}
+		if ident, ok := divisor.(*ast.Ident); ok {
+			if name, ok := params[info.Uses[ident]]; ok {
+				if b, ok := info.Uses[ident].Type().Underlying().(*types.Basic); ok && b.Info()&types.IsInteger != 0 {
+					addGuard(name + " == 0")
+				}
+			}
+		}
+		return true
+	})
+
+	// Swapped operands may fall outside the domain the guards check
+	if len(target.Guards) == 0 && len(target.Results) > 0 && target.Results[0] == "got" && commutes(fn, sig, info) {
+		target.Swapped = []string{"swapped"}
+		for range target.Results[1:] {
+			target.Swapped = append(target.Swapped, "_")
+		}
+	}
+}
+
+// rejectsInput reports whether body ends by panicking or, for functions
+// returning an error, by returning a non-nil one.
+func rejectsInput(body *ast.BlockStmt, returnsError bool, info *types.Info) bool {
+	if len(body.List) == 0 {
+		return false
+	}
+	switch stmt := body.List[len(body.List)-1].(type) {
+	case *ast.ExprStmt:
+		call, ok := stmt.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		ident, ok := call.Fun.(*ast.Ident)
+		return ok && info.Uses[ident] == types.Universe.Lookup("panic")
+	case *ast.ReturnStmt:
+		if !returnsError || len(stmt.Results) == 0 {
+			return false
+		}
+		ident, ok := stmt.Results[len(stmt.Results)-1].(*ast.Ident)
+		return !ok || info.Uses[ident] != types.Universe.Lookup("nil")
+	}
+	return false
+}
+
+// guardExpr renders cond in terms of the fuzz arguments. Only conditions
+// made of parameters, literals, constants and len are rendered.
+func (fg FuzzGenerator) guardExpr(cond ast.Expr, params map[types.Object]string, info *types.Info, pkg *types.Package) (string, bool) {
+	switch e := cond.(type) {
+	case *ast.BasicLit:
+		return e.Value, true
+	case *ast.Ident:
+		obj := info.Uses[e]
+		if name, ok := params[obj]; ok {
+			return name, true
+		}
+		if _, ok := obj.(*types.Const); ok {
+			switch {
+			case obj.Parent() == types.Universe, obj.Pkg() == pkg && fg.Qualifier == "":
+				return e.Name, true
+			case obj.Pkg() == pkg && obj.Exported() && obj.Parent() == pkg.Scope():
+				return fg.Qualifier + "." + e.Name, true
+			}
+		}
+	case *ast.ParenExpr:
+		if x, ok := fg.guardExpr(e.X, params, info, pkg); ok {
+			return "(" + x + ")", true
+		}
+	case *ast.UnaryExpr:
+		if e.Op == token.NOT || e.Op == token.SUB {
+			if x, ok := fg.guardExpr(e.X, params, info, pkg); ok {
+				return e.Op.String() + x, true
+			}
+		}
+	case *ast.BinaryExpr:
+		x, okX := fg.guardExpr(e.X, params, info, pkg)
+		y, okY := fg.guardExpr(e.Y, params, info, pkg)
+		if okX && okY {
+			return x + " " + e.Op.String() + " " + y, true
+		}
+	case *ast.CallExpr:
+		ident, ok := e.Fun.(*ast.Ident)
+		if ok && info.Uses[ident] == types.Universe.Lookup("len") && len(e.Args) == 1 {
+			if x, ok := fg.guardExpr(e.Args[0], params, info, pkg); ok {
+				return "len(" + x + ")", true
+			}
+		}
+	}
+	return "", false
+}
+
+// commutativeOps are the operators whose operands can be swapped, for
+// operands of a type comparableResult accepts; + is excluded for strings.
+var commutativeOps = map[token.Token]bool{
+	token.ADD: true, token.MUL: true, token.AND: true, token.OR: true, token.XOR: true,
+	token.EQL: true, token.NEQ: true, token.LAND: true, token.LOR: true,
+}
+
+// commutes reports whether fn takes two operands of the same type and
+// returns a commutative operation on them, directly or through locals
+// assigned once, e.g. "result := a + b; log(result); return result". The
+// operands must not be reassigned and the final return must be the only one.
+func commutes(fn *ast.FuncDecl, sig *types.Signature, info *types.Info) bool {
+	if sig.Params().Len() != 2 || !types.Identical(sig.Params().At(0).Type(), sig.Params().At(1).Type()) {
+		return false
+	}
+	operand := sig.Params().At(0).Type()
+	if !comparableResult(operand) || !comparableResult(sig.Results().At(0).Type()) {
+		return false
+	}
+	if len(fn.Body.List) == 0 {
+		return false
+	}
+	ret, ok := fn.Body.List[len(fn.Body.List)-1].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) == 0 {
+		return false
+	}
+	a, b := sig.Params().At(0), sig.Params().At(1)
+	values, ok := assignedValues(fn.Body, ret, info)
+	if !ok || len(values[a]) > 0 || len(values[b]) > 0 {
+		return false
+	}
+
+	// Follow the returned value back to the operation computing it
+	expr := ret.Results[0]
+	for seen := make(map[types.Object]bool); ; {
+		ident, ok := ast.Unparen(expr).(*ast.Ident)
+		if !ok {
+			break
+		}
+		obj := info.Uses[ident]
+		if len(values[obj]) != 1 || values[obj][0] == nil || seen[obj] {
+			break
+		}
+		seen[obj] = true
+		expr = values[obj][0]
+	}
+
+	binary, ok := ast.Unparen(expr).(*ast.BinaryExpr)
+	if !ok || !commutativeOps[binary.Op] {
+		return false
+	}
+	if basic, ok := operand.Underlying().(*types.Basic); !ok || (binary.Op == token.ADD && basic.Info()&types.IsString != 0) {
+		return false
+	}
+	x, okX := ast.Unparen(binary.X).(*ast.Ident)
+	y, okY := ast.Unparen(binary.Y).(*ast.Ident)
+	if !okX || !okY {
+		return false
+	}
+	return (info.Uses[x] == a && info.Uses[y] == b) || (info.Uses[x] == b && info.Uses[y] == a)
+}
+
+// assignedValues returns the values body assigns to each variable, with
+// nil for an assignment that hides the value, such as x++, x += y, a range
+// clause, &x or an assignment in a function literal. It fails if body
+// returns anywhere but at final, outside function literals.
+func assignedValues(body *ast.BlockStmt, final *ast.ReturnStmt, info *types.Info) (map[types.Object][]ast.Expr, bool) {
+	values := make(map[types.Object][]ast.Expr)
+	assign := func(lhs, value ast.Expr) {
+		ident, ok := ast.Unparen(lhs).(*ast.Ident)
+		if !ok {
+			return
+		}
+		obj := info.Defs[ident]
+		if obj == nil {
+			obj = info.Uses[ident]
+		}
+		if obj != nil {
+			values[obj] = append(values[obj], value)
+		}
+	}
+
+	ok := true
+	var visit func(n ast.Node, inLit bool)
+	visit = func(n ast.Node, inLit bool) {
+		ast.Inspect(n, func(n ast.Node) bool {
+			switch node := n.(type) {
+			case *ast.FuncLit:
+				visit(node.Body, true)
+				return false
+			case *ast.ReturnStmt:
+				if !inLit && node != final {
+					ok = false
+				}
+			case *ast.AssignStmt:
+				known := (node.Tok == token.DEFINE || node.Tok == token.ASSIGN) && len(node.Lhs) == len(node.Rhs) && !inLit
+				for i, lhs := range node.Lhs {
+					if known {
+						assign(lhs, node.Rhs[i])
+					} else {
+						assign(lhs, nil)
+					}
+				}
+			case *ast.ValueSpec:
+				known := len(node.Values) == len(node.Names) && !inLit
+				for i, name := range node.Names {
+					if known {
+						assign(name, node.Values[i])
+					} else {
+						assign(name, nil)
+					}
+				}
+			case *ast.IncDecStmt:
+				assign(node.X, nil)
+			case *ast.UnaryExpr:
+				if node.Op == token.AND {
+					assign(node.X, nil)
+				}
+			case *ast.RangeStmt:
+				if node.Key != nil {
+					assign(node.Key, nil)
+				}
+				if node.Value != nil {
+					assign(node.Value, nil)
+				}
+			}
+			return true
+		})
+	}
+	visit(body, false)
+	return values, ok
+}
+
+// comparableResult reports whether two results of type t from identical
+// calls can be expected to be DeepEqual. Floats are excluded because NaN is
+// never equal to itself.
+func comparableResult(t types.Type) bool {
+	if b, ok := t.Underlying().(*types.Basic); ok {
+		return b.Info()&(types.IsFloat|types.IsComplex) == 0 && b.Kind() != types.UnsafePointer
+	}
+	if s, ok := t.Underlying().(*types.Slice); ok {
+		if b, ok := s.Elem().Underlying().(*types.Basic); ok {
+			return b.Info()&(types.IsInteger|types.IsString|types.IsBoolean) != 0
+		}
+	}
+	return false
+}
+
+// seedValue returns the i-th seed corpus value of fuzzable type t, typed so
+// that it matches the fuzz argument exactly.
+func seedValue(t types.Type, i int) string {
+	if s, ok := t.(*types.Slice); ok && isByte(s.Elem()) {
+		values := []string{`[]byte("")`, `[]byte("data")`, `[]byte{0xff, 0x00}`}
+		return values[i%len(values)]
+	}
+
+	b, ok := t.(*types.Basic)
+	if !ok {
+		return zeroValue(t, nil)
+	}
+	var values []string
+	switch {
+	case b.Info()&types.IsBoolean != 0:
+		values = []string{"false", "true"}
+	case b.Info()&types.IsString != 0:
+		values = []string{`""`, `"hello"`, `"ünïcode"`}
+	case b.Info()&types.IsFloat != 0:
+		values = []string{"0", "1.5", "-2.25"}
+	case b.Info()&types.IsUnsigned != 0:
+		values = []string{"0", "1", "100"}
+	default:
+		values = []string{"0", "1", "-1", "100"}
+	}
+	value := values[i%len(values)]
+
+	// Untyped constants default to int, float64, string and bool
+	switch b.Kind() {
+	case types.Int, types.String, types.Bool:
+		return value
+	case types.Float64:
+		if !strings.Contains(value, ".") {
+			value += ".0"
+		}
+		return value
+	}
+	return b.Name() + "(" + value + ")"
+}
+
+func isByte(t types.Type) bool {
+	b, ok := t.(*types.Basic)
+	return ok && b.Kind() == types.Uint8
+}
+
+// addFuzzTargets completes content with generated Fuzz targets for the
+// fuzzable functions it does not fuzz yet, then runs each Fuzz target
+// briefly and removes the ones that fail or do not compile.
+func (tg *TestGenerator) addFuzzTargets(ctx context.Context, gt generationTarget, content string) (string, []string, []DroppedTest, error) {
+	declared, err := declaredFunctions(content)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	extra, err := FuzzGenerator{Qualifier: gt.qualifier, Build: gt.build}.Generate(ctx, gt.dir, gt.functions, declared)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if extra != "" {
+		if content, err = gt.postProcessor.Process(content + extra); err != nil {
+			return "", nil, nil, err
+		}
+		if declared, err = declaredFunctions(content); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	var targets []string
+	for name := range declared {
+		if strings.HasPrefix(name, "Fuzz") {
+			targets = append(targets, name)
+		}
+	}
+	sort.Strings(targets)
+	if len(targets) == 0 {
+		return content, nil, nil, nil
+	}
+
+	runner := gt.runner(tg.options.FuzzTime + 2*time.Minute)
+	var dropped []DroppedTest
+	remove := func(names []string, reason string) error {
+		drop := make(map[string]bool)
+		for _, name := range names {
+			drop[name] = true
+			dropped = append(dropped, DroppedTest{Name: name, Reason: reason})
+		}
+		content, err = gt.postProcessor.removeDeclarations(content, drop)
+		return err
+	}
+
+	// A model-written target that does not compile takes the whole file down
+	if output, err := runner.Run(ctx, gt.dir, content, "-run=^$"); err != nil {
+		log.Printf("Generated tests with fuzz targets do not compile, dropping the targets: %v\n%s", err, output)
+		if err := remove(targets, "does not compile"); err != nil {
+			return "", nil, nil, err
+		}
+		return content, nil, dropped, nil
+	}
+
+	var passed, failed []string
+	for _, name := range targets {
+		output, err := runner.Run(ctx, gt.dir, content, "-run=^$", "-fuzz=^"+name+"$", "-fuzztime="+tg.options.FuzzTime.String())
+		if err != nil {
+			log.Printf("Fuzz target %s failed, dropping it: %v\n%s", name, err, output)
+			failed = append(failed, name)
+			continue
+		}
+		passed = append(passed, name)
+	}
+	if len(failed) > 0 {
+		if err := remove(failed, fmt.Sprintf("failed a %v fuzz run", tg.options.FuzzTime)); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	return content, passed, dropped, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFunctionInfoFuzzable(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []Param
+		want   bool
+	}{
+		{"ints", []Param{{"a", "int"}, {"b", "int"}}, true},
+		{"string and bytes", []Param{{"s", "string"}, {"data", "[]byte"}}, true},
+		{"no parameters", nil, false},
+		{"struct parameter", []Param{{"p", "Point"}}, false},
+		{"variadic", []Param{{"values", "...int"}}, false},
+		{"string slice", []Param{{"names", "[]string"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := FunctionInfo{Name: "F", Params: tt.params}
+			if got := fn.Fuzzable(); got != tt.want {
+				t.Errorf("Fuzzable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeedValue(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  types.Type
+		i    int
+		want string
+	}{
+		{"int", types.Typ[types.Int], 2, "-1"},
+		{"int64", types.Typ[types.Int64], 1, "int64(1)"},
+		{"unsigned", types.Typ[types.Uint8], 2, "uint8(100)"},
+		{"float64", types.Typ[types.Float64], 0, "0.0"},
+		{"float32", types.Typ[types.Float32], 1, "float32(1.5)"},
+		{"string", types.Typ[types.String], 1, `"hello"`},
+		{"bool", types.Typ[types.Bool], 3, "true"},
+		{"bytes", types.NewSlice(types.Typ[types.Uint8]), 1, `[]byte("data")`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := seedValue(tt.typ, tt.i); got != tt.want {
+				t.Errorf("seedValue(%s, %d) = %s, want %s", tt.typ, tt.i, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuzzGenerator_Generate(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod": "module example.com/calc\n\ngo 1.22\n",
+		"calc.go": `package calc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const MaxDepth = 8
+
+func Add(a, b int) int { return b + a }
+
+func Sub(a, b int) int { return a - b }
+
+func Join(a, b string) string { return a + b }
+
+func Divide(a, b int) int { return a / b }
+
+func Checked(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a / b, nil
+}
+
+func Nest(s string, depth int) string {
+	if depth < 0 || depth > MaxDepth {
+		panic("bad depth")
+	}
+	if len(s) == 0 {
+		return s
+	}
+	return strings.Repeat("(", depth) + s
+}
+
+type Calculator struct{ history []string }
+
+func (c *Calculator) Add(a, b int) int {
+	result := a + b
+	c.history = append(c.history, fmt.Sprintf("%d + %d = %d", a, b, result))
+	return result
+}
+
+func (c *Calculator) Multiply(a, b int) int {
+	result := a * b
+	c.history = append(c.history, fmt.Sprintf("%d * %d = %d", a, b, result))
+	return result
+}
+
+func Double(a, b int) int {
+	a = a * 2
+	return a + b
+}
+
+func Max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b + a
+}
+`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var functions []FunctionInfo
+	for _, name := range []string{"Add", "Sub", "Join", "Divide", "Checked", "Nest", "Double", "Max"} {
+		functions = append(functions, FunctionInfo{Name: name, Params: []Param{{"a", "int"}}})
+	}
+	for _, name := range []string{"Add", "Multiply"} {
+		functions = append(functions, FunctionInfo{Name: name, Receiver: "Calculator", Params: []Param{{"a", "int"}}})
+	}
+	fg := FuzzGenerator{Qualifier: "calc"}
+	src, err := fg.Generate(context.Background(), dir, functions, nil)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	targets := make(map[string]string)
+	for _, part := range strings.Split(src, "\nfunc ")[1:] {
+		targets[part[:strings.Index(part, "(")]] = part
+	}
+	for _, tt := range []struct {
+		target  string
+		guards  []string
+		swapped bool
+	}{
+		{target: "FuzzAdd", swapped: true},
+		{target: "FuzzSub"},
+		{target: "FuzzJoin"},
+		{target: "FuzzDivide", guards: []string{"b == 0"}},
+		{target: "FuzzChecked", guards: []string{"b == 0"}},
+		// An early return is not a precondition
+		{target: "FuzzNest", guards: []string{"depth < 0 || depth > calc.MaxDepth"}},
+		// The result is computed before the history is written
+		{target: "FuzzCalculator_Add", swapped: true},
+		{target: "FuzzCalculator_Multiply", swapped: true},
+		// a is reassigned, and b + a is not the only result
+		{target: "FuzzDouble"},
+		{target: "FuzzMax"},
+	} {
+		body, ok := targets[tt.target]
+		if !ok {
+			t.Errorf("%s was not generated:\n%s", tt.target, src)
+			continue
+		}
+		for _, guard := range tt.guards {
+			if !strings.Contains(body, "if "+guard+" {\n\t\t\tt.Skip(") {
+				t.Errorf("%s does not skip inputs where %s:\n%s", tt.target, guard, body)
+			}
+		}
+		if strings.Count(body, "t.Skip(") != len(tt.guards) {
+			t.Errorf("%s has %d guards, want %d:\n%s", tt.target, strings.Count(body, "t.Skip("), len(tt.guards), body)
+		}
+		if got := strings.Contains(body, "swapped := "); got != tt.swapped {
+			t.Errorf("%s checks commutativity = %v, want %v:\n%s", tt.target, got, tt.swapped, body)
+		}
+	}
+
+	// The seed corpus runs as ordinary tests
+	pp := PostProcessor{PackageName: "calc_test", ImportName: "calc", ImportPath: "example.com/calc"}
+	content, err := pp.Process("package calc_test\n" + src)
+	if err != nil {
+		t.Fatalf("generated fuzz targets are invalid: %v\n%s", err, src)
+	}
+	runner := TestRunner{TestFile: "calc_gen_test.go"}
+	if output, err := runner.Run(context.Background(), dir, content, "-run=^Fuzz", "-count=1"); err != nil {
+		t.Fatalf("generated fuzz targets fail: %v\n%s\n%s", err, output, content)
+	}
+}
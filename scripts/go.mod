@@ -1,11 +1,13 @@
 module test-generator
 
-go 1.21
+go 1.22.0
 
 require (
 	github.com/google/generative-ai-go v0.5.0
 	github.com/google/go-github/v56 v56.0.0
+	golang.org/x/mod v0.21.0
 	golang.org/x/oauth2 v0.15.0
+	golang.org/x/tools v0.26.0
 	google.golang.org/api v0.152.0
 )
 
@@ -21,11 +23,11 @@ require (
 	github.com/googleapis/enterprise-certificate-proxy v0.3.2 // indirect
 	github.com/googleapis/gax-go/v2 v2.12.0 // indirect
 	go.opencensus.io v0.24.0 // indirect
-	golang.org/x/crypto v0.16.0 // indirect
-	golang.org/x/net v0.19.0 // indirect
-	golang.org/x/sync v0.5.0 // indirect
-	golang.org/x/sys v0.15.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/crypto v0.28.0 // indirect
+	golang.org/x/net v0.30.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+	golang.org/x/text v0.19.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 	google.golang.org/appengine v1.6.8 // indirect
 	google.golang.org/genproto v0.0.0-20231211222908-989df2bf70f3 // indirect
@@ -5,8 +5,10 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"path/filepath"
+	"os"
+	"regexp"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -16,7 +18,12 @@ type Config struct {
 	RepoName      string
 	GithubToken   string
 	GeminiAPIKey  string
+	RepoRoot      string
 	CoverageThreshold float64
+	Selection         SelectionPolicy
+	ExistingTests     string
+	AttributeCoverage bool
+	Generator         GeneratorOptions
 }
 
 func main() {
@@ -28,12 +35,17 @@ func main() {
 	}
 
 	ctx := context.Background()
+	defer RemoveSandboxGoCache()
 	
 	// Initialize services
-	coverageAnalyzer := NewCoverageAnalyzer()
-	testGenerator := NewTestGenerator(config.GeminiAPIKey)
+	coverageAnalyzer := NewCoverageAnalyzer(config.Selection, config.RepoRoot)
+	testGenerator := NewTestGenerator(config.GeminiAPIKey, config.Generator)
 	prCreator := NewPRCreator(config.GithubToken, config.RepoOwner, config.RepoName)
 
+	// Tests are collected per module and published in one PR each
+	var modules []*ModuleTests
+	moduleByDir := make(map[string]*ModuleTests)
+
 	// Process each changed file
 	changedFiles := strings.Split(config.ChangedFiles, "\n")
 	for _, file := range changedFiles {
@@ -44,6 +56,14 @@ func main() {
 
 		log.Printf("Processing file: %s", file)
 		
+		// Each file is handled within the nearest module containing it
+		moduleDir, modulePath, err := ResolveModule(config.RepoRoot, file)
+		if err != nil {
+			log.Printf("Error finding the module of %s: %v", file, err)
+			prCreator.CommentOnPR(ctx, config.PRNumber, fmt.Sprintf("❌ Failed to find the Go module of `%s`: %v", file, err))
+			continue
+		}
+
 		// Check if file needs tests
 		needsTests, coverage, err := coverageAnalyzer.AnalyzeFile(ctx, file, config.CoverageThreshold)
 		if err != nil {
@@ -67,32 +87,60 @@ func main() {
 			continue
 		}
 
+		// Leave out functions that existing tests already exercise
+		index, err := coverageAnalyzer.IndexExistingTests(ctx, file, config.AttributeCoverage)
+		if err != nil {
+			log.Printf("Could not index existing tests for %s: %v", file, err)
+		}
+		functions = ApplyExistingTests(functions, index, config.ExistingTests)
+
 		if len(functions) == 0 {
 			log.Printf("No functions found in %s that need testing", file)
 			continue
 		}
 
+		// Collect declarations the functions depend on from across the package
+		typeContext, err := coverageAnalyzer.ExtractTypeContext(ctx, file, functions)
+		if err != nil {
+			// Not fatal: the model still gets the file itself as context
+			log.Printf("Could not extract type context for %s: %v", file, err)
+		}
+
 		// Generate tests using LLM
-		testContent, err := testGenerator.GenerateTests(ctx, file, functions)
+		generated, err := testGenerator.GenerateTests(ctx, file, functions, typeContext)
 		if err != nil {
 			log.Printf("Error generating tests for %s: %v", file, err)
 			prCreator.CommentOnPR(ctx, config.PRNumber, fmt.Sprintf("❌ Failed to generate tests for `%s`: %v", file, err))
 			continue
 		}
 
-		// Create PR with generated tests
-		testFileName := strings.TrimSuffix(file, ".go") + "_test.go"
-		branchName := createBranchName(file)
+		module, ok := moduleByDir[moduleDir]
+		if !ok {
+			module = &ModuleTests{Dir: moduleDir, Path: modulePath}
+			moduleByDir[moduleDir] = module
+			modules = append(modules, module)
+		}
+		module.Sources = append(module.Sources, SourceTests{File: file, Coverage: coverage, Generated: generated})
+	}
+
+	// Create one PR with the generated tests of each module
+	for _, module := range modules {
+		branchName := createBranchName(module.Dir)
 		
-		err = prCreator.CreateTestPR(ctx, file, testFileName, testContent, branchName, coverage)
+		var files []string
+		for _, source := range module.Sources {
+			files = append(files, fmt.Sprintf("`%s` (coverage was %.2f%%)", source.File, source.Coverage))
+		}
+
+		err := prCreator.CreateTestPR(ctx, module, branchName)
 		if err != nil {
-			log.Printf("Error creating PR for %s: %v", file, err)
-			prCreator.CommentOnPR(ctx, config.PRNumber, fmt.Sprintf("❌ Failed to create PR for tests of `%s`: %v", file, err))
+			log.Printf("Error creating PR for module %s: %v", module.Path, err)
+			prCreator.CommentOnPR(ctx, config.PRNumber, fmt.Sprintf("❌ Failed to create PR for tests of module `%s`: %v", module.Path, err))
 			continue
 		}
 
-		log.Printf("Successfully created test PR for %s", file)
-		prCreator.CommentOnPR(ctx, config.PRNumber, fmt.Sprintf("✅ Generated unit tests for `%s` (coverage was %.2f%%). New PR created with branch `%s`", file, coverage, branchName))
+		log.Printf("Successfully created test PR for module %s", module.Path)
+		prCreator.CommentOnPR(ctx, config.PRNumber, fmt.Sprintf("✅ Generated unit tests for %s in module `%s`. New PR created with branch `%s`", strings.Join(files, ", "), module.Path, branchName))
 	}
 
 	log.Println("Test generation process completed")
@@ -105,22 +153,155 @@ func parseFlags() *Config {
 	flag.StringVar(&config.ChangedFiles, "changed-files", "", "Newline-separated list of changed files")
 	flag.StringVar(&config.RepoOwner, "repo-owner", "", "Repository owner")
 	flag.StringVar(&config.RepoName, "repo-name", "", "Repository name")
-	flag.StringVar(&config.GithubToken, "github-token", "", "GitHub token")
-	flag.StringVar(&config.GeminiAPIKey, "gemini-api-key", "", "Gemini API key")
+	githubTokenFile := flag.String("github-token-file", "", "File holding the GitHub token, deleted once read (default the GITHUB_TOKEN environment variable)")
+	geminiAPIKeyFile := flag.String("gemini-api-key-file", "", "File holding the Gemini API key, deleted once read (default the GEMINI_API_KEY environment variable)")
 	flag.Float64Var(&config.CoverageThreshold, "coverage-threshold", 40.0, "Coverage threshold percentage")
+	flag.StringVar(&config.RepoRoot, "repo-root", "", "Repository root the changed files are relative to (default the enclosing git repository)")
+
+	config.Selection = DefaultSelectionPolicy()
+	flag.StringVar(&config.Selection.Targets, "targets", config.Selection.Targets, "Functions to target: exported, complex (exported plus unexported ones of at least -complex-unexported complexity) or all. Unexported targets get their own <file>_internal_test.go only with -external-tests")
+	flag.IntVar(&config.Selection.ComplexUnexported, "complex-unexported", config.Selection.ComplexUnexported, "Least cyclomatic complexity of unexported functions targeted with -targets=complex")
+	flag.IntVar(&config.Selection.MinStatements, "min-statements", config.Selection.MinStatements, "Skip functions with fewer top-level statements")
+	flag.IntVar(&config.Selection.MinComplexity, "min-complexity", config.Selection.MinComplexity, "Skip functions below this cyclomatic complexity")
+	flag.StringVar(&config.ExistingTests, "existing-tests", ExistingTestsSkip, "How to treat functions that already have tests: skip, deprioritize or ignore")
+	flag.BoolVar(&config.AttributeCoverage, "attribute-coverage", false, "Run each existing test alone to attribute functions by coverage (slower)")
+	flag.BoolVar(&config.Generator.ExternalTests, "external-tests", false, "Generate black-box tests in package <name>_test importing the package by its module path; tests of unexported functions go into a white-box <file>_internal_test.go")
+	flag.StringVar(&config.Generator.OutputMode, "output-mode", OutputModeCode, "Model output format: code (free-form test file) or json (test cases rendered into table-driven tests)")
+	flag.BoolVar(&config.Generator.Fuzz, "fuzz", false, "Add Go native fuzz targets for functions with fuzzable parameters")
+	flag.DurationVar(&config.Generator.FuzzTime, "fuzz-time", 5*time.Second, "How long to run each fuzz target before publishing it")
+	flag.BoolVar(&config.Generator.Benchmarks, "benchmarks", false, "Add Benchmark functions for the functions matching -benchmark-functions")
+	flag.BoolVar(&config.Generator.BenchmarkBaseline, "benchmark-baseline", false, "Record a baseline ns/op and allocs/op of the generated benchmarks in the PR description")
+	flag.BoolVar(&config.Generator.Fakes, "fakes", true, "Generate fakes for interface dependencies into a <file>_gen_fakes_test.go helper")
+	flag.BoolVar(&config.Generator.Examples, "examples", false, "Add godoc Example functions with verified // Output: blocks for exported functions")
+	flag.IntVar(&config.Generator.FlakeRuns, "flake-runs", 5, "Run generated tests this many times in shuffled order to catch flaky ones (0 disables the check)")
+	flag.BoolVar(&config.Generator.FlakeRace, "flake-race", true, "Run the flakiness check under the race detector")
+	flag.StringVar(&config.Generator.FlakyMode, "flaky", FlakyDrop, "What to do with flaky tests: drop or quarantine (keep them with t.Skip)")
+	flag.IntVar(&config.Generator.MinQuality, "min-quality", 50, "Drop generated tests whose assertion quality score (0-100) is below this")
+	flag.BoolVar(&config.Generator.QualityRepair, "quality-repair", true, "Ask the model once to rewrite tests below -min-quality before dropping them")
+	flag.BoolVar(&config.Generator.Mutation, "mutation", false, "Run the generated tests against mutants of the target functions and report mutation scores")
+	flag.IntVar(&config.Generator.MaxMutants, "max-mutants", 25, "Most mutants tried per function (0 for all)")
+	flag.IntVar(&config.Generator.Candidates, "candidates", 1, "Generate this many test files at rising temperatures and keep the one covering most, merged with tests of the others that cover more")
+	flag.StringVar(&config.Generator.SourceComments, "source-comments", SourceCommentsQuarantine, "How source comments are shown to the model: keep, quarantine (withhold instruction-like ones) or strip")
+	config.Generator.Safety = DefaultSafetyPolicy()
+	flag.DurationVar(&config.Generator.Safety.MaxSleep, "max-sleep", config.Generator.Safety.MaxSleep, "Longest time.Sleep allowed in generated tests (0 for no limit)")
+	denyImports := flag.String("deny-imports", "", "Comma-separated import paths generated tests may not use, in addition to the defaults (a /... suffix covers a tree)")
+	allowImports := flag.String("allow-imports", "", "Comma-separated import paths to exempt from the denied imports, e.g. net")
+	denyCalls := flag.String("deny-calls", "", "Comma-separated functions generated tests may not call, as importpath.Name or importpath.*")
+	allowCalls := flag.String("allow-calls", "", "Comma-separated functions to exempt from the denied calls, e.g. os.Getenv")
+	promptDir := flag.String("prompt-dir", "", "Directory of *.tmpl prompt templates overriding the embedded ones by file name or adding variants")
+	promptVariants := flag.String("prompt-variants", DefaultPromptVariant, "Comma-separated prompt variants to use; -candidates cycle through them")
+	style := flag.String("style", StyleStdlib, "Comma-separated test style traits: stdlib or testify (used only if go.mod already requires it), table-driven, parallel")
+	helperNames := flag.String("helper-names", "", "Regexp generated helper functions must match, e.g. ^(new|must|assert)[A-Z]")
+	styleGuide := flag.String("style-guide", "", "File with the repository's test style guidance (assertion library, table-driven tests, naming) for the prompt")
+	benchmarkPatterns := flag.String("benchmark-functions", "", "Comma-separated regexps of hot-path functions to benchmark (default all targeted functions)")
+	includePatterns := flag.String("include-functions", "", "Comma-separated regexps; only matching functions (e.g. Calculator.Add) are targeted")
+	excludePatterns := flag.String("exclude-functions", "", "Comma-separated regexps of functions to skip")
 	
 	flag.Parse()
 
+	if config.Generator.OutputMode != OutputModeCode && config.Generator.OutputMode != OutputModeJSON {
+		log.Fatalf("Invalid -output-mode %q: must be code or json", config.Generator.OutputMode)
+	}
+
+	if config.Generator.FlakyMode != FlakyDrop && config.Generator.FlakyMode != FlakyQuarantine {
+		log.Fatalf("Invalid -flaky %q: must be drop or quarantine", config.Generator.FlakyMode)
+	}
+
+	switch config.Generator.SourceComments {
+	case SourceCommentsKeep, SourceCommentsQuarantine, SourceCommentsStrip:
+	default:
+		log.Fatalf("Invalid -source-comments %q: must be keep, quarantine or strip", config.Generator.SourceComments)
+	}
+
+	switch config.Selection.Targets {
+	case TargetsExported, TargetsComplex, TargetsAll:
+	default:
+		log.Fatalf("Invalid -targets %q: must be exported, complex or all", config.Selection.Targets)
+	}
+
+	switch config.ExistingTests {
+	case ExistingTestsSkip, ExistingTestsDeprioritize, ExistingTestsIgnore:
+	default:
+		log.Fatalf("Invalid -existing-tests %q: must be skip, deprioritize or ignore", config.ExistingTests)
+	}
+
+	// Secrets are read before any generated test runs, and never taken from
+	// the command line, which other processes can read
+	var err error
+	if config.GithubToken, err = readSecret(*githubTokenFile, "GITHUB_TOKEN"); err != nil {
+		log.Fatalf("Invalid -github-token-file: %v", err)
+	}
+	if config.GeminiAPIKey, err = readSecret(*geminiAPIKeyFile, "GEMINI_API_KEY"); err != nil {
+		log.Fatalf("Invalid -gemini-api-key-file: %v", err)
+	}
+
+	if config.Selection.IncludePatterns, err = ParsePatterns(*includePatterns); err != nil {
+		log.Fatalf("Invalid -include-functions: %v", err)
+	}
+	if config.Selection.ExcludePatterns, err = ParsePatterns(*excludePatterns); err != nil {
+		log.Fatalf("Invalid -exclude-functions: %v", err)
+	}
+	if config.Generator.BenchmarkPatterns, err = ParsePatterns(*benchmarkPatterns); err != nil {
+		log.Fatalf("Invalid -benchmark-functions: %v", err)
+	}
+
+	if config.Generator.Prompts, err = LoadPromptTemplates(*promptDir); err != nil {
+		log.Fatalf("Invalid -prompt-dir: %v", err)
+	}
+	config.Generator.PromptVariants = ParseList(*promptVariants)
+	for _, variant := range config.Generator.PromptVariants {
+		_, version, err := config.Generator.Prompts.Variant(variant)
+		if err != nil {
+			log.Fatalf("Invalid -prompt-variants: %v", err)
+		}
+		log.Printf("Using prompt %s", version)
+	}
+	if config.Generator.Style, err = ParseStyleProfile(*style); err != nil {
+		log.Fatalf("Invalid -style: %v", err)
+	}
+	if *helperNames != "" {
+		if config.Generator.Style.HelperNames, err = regexp.Compile(*helperNames); err != nil {
+			log.Fatalf("Invalid -helper-names: %v", err)
+		}
+	}
+	if *styleGuide != "" {
+		guide, err := os.ReadFile(*styleGuide)
+		if err != nil {
+			log.Fatalf("Invalid -style-guide: %v", err)
+		}
+		config.Generator.StyleGuide = string(guide)
+	}
+
+	config.Generator.Safety.DeniedImports = append(config.Generator.Safety.DeniedImports, ParseList(*denyImports)...)
+	config.Generator.Safety.AllowedImports = append(config.Generator.Safety.AllowedImports, ParseList(*allowImports)...)
+	config.Generator.Safety.DeniedCalls = append(config.Generator.Safety.DeniedCalls, ParseList(*denyCalls)...)
+	config.Generator.Safety.AllowedCalls = append(config.Generator.Safety.AllowedCalls, ParseList(*allowCalls)...)
+
+	if config.RepoRoot == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("Failed to get current directory: %v", err)
+		}
+		if config.RepoRoot = findRepoRoot(wd); config.RepoRoot == "" {
+			config.RepoRoot = wd
+		}
+	}
+	config.Generator.RepoRoot = config.RepoRoot
+
 	// Validate required flags
-	if config.RepoOwner == "" || config.RepoName == "" || config.GithubToken == "" || config.GeminiAPIKey == "" {
-		log.Fatal("Missing required flags")
+	if config.RepoOwner == "" || config.RepoName == "" || config.GithubToken == "" {
+		log.Fatal("Missing required flags or GitHub token")
+	}
+	if config.GeminiAPIKey == "" {
+		log.Println("No Gemini API key configured, generating skeleton tests only")
 	}
 
 	return config
 }
 
-func createBranchName(filePath string) string {
-	dir := filepath.Dir(filePath)
+// createBranchName names the branch of the tests of the module in dir,
+// relative to the repository root.
+func createBranchName(dir string) string {
 	if dir == "." {
 		return "auto-tests-root"
 	}
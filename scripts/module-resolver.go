@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+)
+
+// findModuleRoot walks up from dir to the nearest directory containing a
+// go.mod file.
+func findModuleRoot(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %v", err)
+	}
+
+	for current := absDir; ; {
+		if _, err := os.Stat(filepath.Join(current, "go.mod")); err == nil {
+			return current, nil
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return "", fmt.Errorf("no go.mod found above %s", dir)
+		}
+		current = parent
+	}
+}
+
+// packageImportPath resolves the import path of the package in dir from the
+// module path declared in the enclosing go.mod.
+func packageImportPath(dir string) (string, error) {
+	root, err := findModuleRoot(dir)
+	if err != nil {
+		return "", err
+	}
+
+	modulePath, err := readModulePath(root)
+	if err != nil {
+		return "", err
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	rel, err := filepath.Rel(root, absDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get package path relative to module root: %v", err)
+	}
+	if rel == "." {
+		return modulePath, nil
+	}
+
+	return path.Join(modulePath, filepath.ToSlash(rel)), nil
+}
+
+// readModulePath returns the module path declared in the go.mod in root.
+func readModulePath(root string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read go.mod: %v", err)
+	}
+	modulePath := modfile.ModulePath(data)
+	if modulePath == "" {
+		return "", fmt.Errorf("no module directive in %s", filepath.Join(root, "go.mod"))
+	}
+	return modulePath, nil
+}
+
+// moduleRequires reports whether the go.mod of the module containing dir
+// requires modulePath.
+func moduleRequires(dir, modulePath string) (bool, error) {
+	root, err := findModuleRoot(dir)
+	if err != nil {
+		return false, err
+	}
+
+	goMod := filepath.Join(root, "go.mod")
+	data, err := os.ReadFile(goMod)
+	if err != nil {
+		return false, fmt.Errorf("failed to read go.mod: %v", err)
+	}
+	file, err := modfile.ParseLax(goMod, data, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse go.mod: %v", err)
+	}
+	for _, req := range file.Require {
+		if req.Mod.Path == modulePath {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// findRepoRoot walks up from dir to the nearest directory containing .git,
+// or returns "" if there is none.
+func findRepoRoot(dir string) string {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for current := absDir; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return current
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return ""
+		}
+		current = parent
+	}
+}
+
+// resolveRepoPath returns the path of a file named relative to the
+// repository root. Without a root, paths are taken as they are.
+func resolveRepoPath(repoRoot, filePath string) string {
+	if repoRoot == "" || filepath.IsAbs(filePath) {
+		return filePath
+	}
+	return filepath.Join(repoRoot, filePath)
+}
+
+// ResolveModule returns the module containing a file named relative to the
+// repository root: its root directory relative to the repository root
+// ("." for the root itself) and its module path.
+func ResolveModule(repoRoot, filePath string) (dir, modulePath string, err error) {
+	absPath, err := filepath.Abs(resolveRepoPath(repoRoot, filePath))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	root, err := findModuleRoot(filepath.Dir(absPath))
+	if err != nil {
+		return "", "", err
+	}
+	if modulePath, err = readModulePath(root); err != nil {
+		return "", "", err
+	}
+
+	base := repoRoot
+	if base == "" {
+		base = "."
+	}
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	dir, err = filepath.Rel(absBase, root)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get module path relative to repository root: %v", err)
+	}
+	return filepath.ToSlash(dir), modulePath, nil
+}
+
+// Workspace is a go.work file with the roots of the modules it uses.
+type Workspace struct {
+	Path    string // of the go.work file
+	Dir     string
+	Modules []string // absolute module roots from the use directives
+	file    *modfile.WorkFile
+}
+
+// findWorkspace returns the workspace the go command uses in dir: the
+// go.work file GOWORK names, or else the nearest one above dir. It returns
+// nil if there is none or GOWORK is off.
+func findWorkspace(dir string) (*Workspace, error) {
+	path := os.Getenv("GOWORK")
+	if path == "off" {
+		return nil, nil
+	}
+	if path == "" {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %v", err)
+		}
+		for current := absDir; ; {
+			if _, err := os.Stat(filepath.Join(current, "go.work")); err == nil {
+				path = filepath.Join(current, "go.work")
+				break
+			}
+			parent := filepath.Dir(current)
+			if parent == current {
+				return nil, nil
+			}
+			current = parent
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read go.work: %v", err)
+	}
+	file, err := modfile.ParseWork(path, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse go.work: %v", err)
+	}
+
+	ws := &Workspace{Path: path, Dir: filepath.Dir(path), file: file}
+	for _, use := range file.Use {
+		root := use.Path
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(ws.Dir, root)
+		}
+		ws.Modules = append(ws.Modules, filepath.Clean(root))
+	}
+	return ws, nil
+}
+
+// Uses reports whether the module rooted at root is part of the workspace.
+func (ws *Workspace) Uses(root string) bool {
+	for _, module := range ws.Modules {
+		if module == root {
+			return true
+		}
+	}
+	return false
+}
+
+// commandEnv returns the environment of go commands run in dir to build
+// with build, or nil to inherit the bot's. A workspace that does not use
+// the module of dir is turned off, as the go command refuses to run in it.
+func commandEnv(dir string, build BuildContext) []string {
+	env := build.Env()
+	if root, err := findModuleRoot(dir); err == nil {
+		if ws, err := findWorkspace(root); err == nil && ws != nil && !ws.Uses(root) {
+			env = append(env, "GOWORK=off")
+		}
+	}
+	if len(env) == 0 {
+		return nil
+	}
+	return append(os.Environ(), env...)
+}
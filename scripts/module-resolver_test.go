@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveModule(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":              "module example.com/repo\n\ngo 1.22\n",
+		"calc/calc.go":        "package calc\n",
+		"tools/lint/go.mod":   "module example.com/repo/tools/lint\n\ngo 1.22\n",
+		"tools/lint/check.go": "package lint\n",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		file, dir, modulePath string
+	}{
+		{"calc/calc.go", ".", "example.com/repo"},
+		{"tools/lint/check.go", "tools/lint", "example.com/repo/tools/lint"},
+	}
+	for _, tt := range tests {
+		dir, modulePath, err := ResolveModule(root, tt.file)
+		if err != nil {
+			t.Fatalf("ResolveModule(%s) returned error: %v", tt.file, err)
+		}
+		if dir != tt.dir || modulePath != tt.modulePath {
+			t.Errorf("ResolveModule(%s) = %s, %s, want %s, %s", tt.file, dir, modulePath, tt.dir, tt.modulePath)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mutantTimeout bounds a run against one mutant; mutants that loop forever
+// count as killed.
+const mutantTimeout = 30 * time.Second
+
+// Mutant is a single small change to a target function, such as + turned
+// into -, that a strong test suite should notice.
+type Mutant struct {
+	Function    string // qualified name of the mutated function
+	Line        int
+	Description string // e.g. "+ → -"
+
+	start, end  int // byte range of the source replaced
+	replacement string
+}
+
+// Apply returns src with the mutation applied.
+func (m Mutant) Apply(src string) string {
+	return src[:m.start] + m.replacement + src[m.end:]
+}
+
+// MutationScore is how many of a function's mutants the generated tests
+// killed, i.e. failed on.
+type MutationScore struct {
+	Function  string
+	Killed    int
+	Total     int      // mutants that compiled
+	Survivors []string // "line N: description" of the mutants no test noticed
+}
+
+// Percent returns the share of killed mutants, or -1 when there were none.
+func (ms MutationScore) Percent() float64 {
+	if ms.Total == 0 {
+		return -1
+	}
+	return 100 * float64(ms.Killed) / float64(ms.Total)
+}
+
+// operatorMutations maps a binary operator to the one replacing it.
+var operatorMutations = map[token.Token]token.Token{
+	token.ADD:  token.SUB,
+	token.SUB:  token.ADD,
+	token.MUL:  token.QUO,
+	token.QUO:  token.MUL,
+	token.REM:  token.MUL,
+	token.EQL:  token.NEQ,
+	token.NEQ:  token.EQL,
+	token.LSS:  token.GEQ,
+	token.GEQ:  token.LSS,
+	token.GTR:  token.LEQ,
+	token.LEQ:  token.GTR,
+	token.LAND: token.LOR,
+	token.LOR:  token.LAND,
+}
+
+// assignMutations maps an assignment or increment operator to the one
+// replacing it.
+var assignMutations = map[token.Token]token.Token{
+	token.ADD_ASSIGN: token.SUB_ASSIGN,
+	token.SUB_ASSIGN: token.ADD_ASSIGN,
+	token.MUL_ASSIGN: token.QUO_ASSIGN,
+	token.QUO_ASSIGN: token.MUL_ASSIGN,
+	token.INC:        token.DEC,
+	token.DEC:        token.INC,
+}
+
+// MutationEngine finds mutants in the target functions of a package. It
+// uses type information to only produce mutants that compile, e.g. no -
+// for string concatenation.
+type MutationEngine struct {
+	MaxMutants int // per function, sampled evenly; zero means no limit
+}
+
+// Mutants returns the mutants of the given functions declared in the file
+// at path, grouped by function in source order.
+func (me MutationEngine) Mutants(ctx context.Context, path string, functions []FunctionInfo) (string, []Mutant, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	src, err := os.ReadFile(absPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read source file: %v", err)
+	}
+
+	build, err := ReadBuildContext(absPath)
+	if err != nil {
+		return "", nil, err
+	}
+	pkg, err := loadPackageDir(ctx, filepath.Dir(absPath), build)
+	if err != nil {
+		return "", nil, err
+	}
+	fset := pkg.Fset
+
+	targets := make(map[string]bool)
+	for _, fn := range functions {
+		targets[fn.QualifiedName()] = true
+	}
+
+	var mutants []Mutant
+	for _, file := range pkg.Syntax {
+		if fset.Position(file.Pos()).Filename != absPath {
+			continue
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			obj, ok := pkg.TypesInfo.Defs[fn.Name].(*types.Func)
+			if !ok || !targets[qualifiedFuncName(obj)] {
+				continue
+			}
+			found := functionMutants(fset, pkg.TypesInfo, fn, string(src), qualifiedFuncName(obj))
+			mutants = append(mutants, sample(found, me.MaxMutants)...)
+		}
+	}
+	return string(src), mutants, nil
+}
+
+// functionMutants collects the mutants of fn's body.
+func functionMutants(fset *token.FileSet, info *types.Info, fn *ast.FuncDecl, src, name string) []Mutant {
+	var mutants []Mutant
+	add := func(start, end token.Pos, replacement, description string) {
+		mutants = append(mutants, Mutant{
+			Function:    name,
+			Line:        fset.Position(start).Line,
+			Description: description,
+			start:       fset.Position(start).Offset,
+			end:         fset.Position(end).Offset,
+			replacement: replacement,
+		})
+	}
+	text := func(n ast.Node) string {
+		return src[fset.Position(n.Pos()).Offset:fset.Position(n.End()).Offset]
+	}
+
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BinaryExpr:
+			to, ok := operatorMutations[n.Op]
+			if !ok || !mutableOperands(info, n) {
+				return true
+			}
+			opEnd := n.OpPos + token.Pos(len(n.Op.String()))
+			add(n.OpPos, opEnd, to.String(), fmt.Sprintf("%s → %s", n.Op, to))
+		case *ast.AssignStmt:
+			if to, ok := assignMutations[n.Tok]; ok && numeric(info.TypeOf(n.Lhs[0])) {
+				add(n.TokPos, n.TokPos+token.Pos(len(n.Tok.String())), to.String(), fmt.Sprintf("%s → %s", n.Tok, to))
+			}
+		case *ast.IncDecStmt:
+			to := assignMutations[n.Tok]
+			add(n.TokPos, n.TokPos+token.Pos(len(n.Tok.String())), to.String(), fmt.Sprintf("%s → %s", n.Tok, to))
+		case *ast.BasicLit:
+			if n.Kind != token.INT {
+				return true
+			}
+			value, err := strconv.ParseInt(n.Value, 0, 64)
+			if err != nil {
+				return true
+			}
+			mutated := value + 1
+			if value == 1 {
+				mutated = 0
+			}
+			add(n.Pos(), n.End(), strconv.FormatInt(mutated, 10), fmt.Sprintf("%s → %d", n.Value, mutated))
+		case *ast.IfStmt:
+			add(n.Cond.Pos(), n.Cond.End(), "!("+text(n.Cond)+")", fmt.Sprintf("negate condition %s", summarizeExpr(text(n.Cond))))
+		case *ast.ForStmt:
+			if n.Cond != nil {
+				add(n.Cond.Pos(), n.Cond.End(), "!("+text(n.Cond)+")", fmt.Sprintf("negate loop condition %s", summarizeExpr(text(n.Cond))))
+			}
+		case *ast.CallExpr:
+			if ident, ok := n.Fun.(*ast.Ident); ok && ident.Name == "append" && len(n.Args) > 1 && info.Uses[ident] == types.Universe.Lookup("append") {
+				add(n.Pos(), n.End(), text(n.Args[0]), fmt.Sprintf("drop append to %s", summarizeExpr(text(n.Args[0]))))
+			}
+		}
+		return true
+	})
+
+	sort.SliceStable(mutants, func(i, j int) bool { return mutants[i].start < mutants[j].start })
+	return mutants
+}
+
+// mutableOperands reports whether the operator of expr can be replaced
+// without breaking compilation: arithmetic mutants need numbers, since + on
+// strings has no counterpart, and ordering needs ordered operands.
+func mutableOperands(info *types.Info, expr *ast.BinaryExpr) bool {
+	t := info.TypeOf(expr.X)
+	if t == nil {
+		return false
+	}
+	basic, ok := t.Underlying().(*types.Basic)
+	switch expr.Op {
+	case token.ADD, token.SUB, token.MUL, token.QUO, token.REM:
+		return ok && basic.Info()&types.IsNumeric != 0
+	case token.LSS, token.GEQ, token.GTR, token.LEQ:
+		return ok && basic.Info()&types.IsOrdered != 0
+	}
+	return true
+}
+
+func numeric(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	basic, ok := t.Underlying().(*types.Basic)
+	return ok && basic.Info()&types.IsNumeric != 0
+}
+
+func summarizeExpr(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) > 40 {
+		text = text[:37] + "..."
+	}
+	return "`" + text + "`"
+}
+
+// sample returns at most limit of mutants, spread evenly over the function.
+func sample(mutants []Mutant, limit int) []Mutant {
+	if limit <= 0 || len(mutants) <= limit {
+		return mutants
+	}
+	sampled := make([]Mutant, 0, limit)
+	for i := 0; i < limit; i++ {
+		sampled = append(sampled, mutants[i*len(mutants)/limit])
+	}
+	return sampled
+}
+
+// mutationTest runs the generated tests that pass on the original source
+// against every mutant of the target functions in a sandboxed copy, and
+// scores each function by the share of mutants the tests killed.
+func (tg *TestGenerator) mutationTest(ctx context.Context, gt generationTarget, sourcePath, content string) ([]MutationScore, error) {
+	declared, err := declaredFunctions(content)
+	if err != nil {
+		return nil, err
+	}
+	tests := testFunctions(declared)
+	if len(tests) == 0 {
+		return nil, nil
+	}
+
+	// Mutants only say something about tests that pass without them
+	runner := gt.runner(5 * time.Minute)
+	output, _ := runner.Run(ctx, gt.dir, content, "-json", "-count=1", "-run="+namesPattern(tests))
+	outcomes := parseTestEvents(output)
+	var passing []string
+	for _, name := range tests {
+		if outcomes.passed[name] > 0 && outcomes.failed[name] == 0 {
+			passing = append(passing, name)
+		}
+	}
+	if len(passing) == 0 {
+		return nil, fmt.Errorf("none of the generated tests pass on the original code")
+	}
+
+	src, mutants, err := MutationEngine{MaxMutants: tg.options.MaxMutants}.Mutants(ctx, sourcePath, gt.functions)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]*MutationScore)
+	var order []string
+	runner = gt.runner(mutantTimeout)
+	sourceName := filepath.Base(sourcePath)
+	for _, mutant := range mutants {
+		runner.Overrides = []HelperFile{{Name: sourceName, Content: mutant.Apply(src)}}
+		output, err := runner.Run(ctx, gt.dir, content, "-count=1", "-failfast", "-vet=off", "-run="+namesPattern(passing))
+		if err != nil && strings.Contains(output, "[build failed]") {
+			// Not a valid program, so not a test of the tests
+			continue
+		}
+
+		score, ok := scores[mutant.Function]
+		if !ok {
+			score = &MutationScore{Function: mutant.Function}
+			scores[mutant.Function] = score
+			order = append(order, mutant.Function)
+		}
+		score.Total++
+		if err != nil {
+			score.Killed++
+		} else {
+			score.Survivors = append(score.Survivors, fmt.Sprintf("line %d: %s", mutant.Line, mutant.Description))
+		}
+	}
+
+	var results []MutationScore
+	for _, name := range order {
+		score := scores[name]
+		log.Printf("Mutation score of %s: %d of %d mutants killed", name, score.Killed, score.Total)
+		results = append(results, *score)
+	}
+	return results, nil
+}
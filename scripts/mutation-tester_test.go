@@ -0,0 +1,68 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFunctionMutants(t *testing.T) {
+	src := `package calc
+
+func Sum(values []int, label string) (int, []string) {
+	total := 0
+	var log []string
+	for i := 0; i < len(values); i++ {
+		total += values[i]
+	}
+	if total > 1 && label != "" {
+		log = append(log, label+"!")
+	}
+	return total, log
+}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "calc.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue), Uses: make(map[*ast.Ident]types.Object)}
+	if _, err := (&types.Config{}).Check("calc", fset, []*ast.File{file}, info); err != nil {
+		t.Fatal(err)
+	}
+
+	mutants := functionMutants(fset, info, file.Decls[0].(*ast.FuncDecl), src, "Sum")
+	var got []string
+	for _, m := range mutants {
+		got = append(got, m.Description)
+	}
+	want := []string{
+		"0 → 1",
+		"0 → 1",
+		"negate loop condition `i < len(values)`",
+		"< → >=",
+		"++ → --",
+		"+= → -=",
+		"negate condition `total > 1 && label != \"\"`",
+		"> → <=",
+		"1 → 0",
+		"&& → ||",
+		"!= → ==",
+		"drop append to `log`",
+	}
+	// The string concatenation label+"!" has no mutant
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mutants =\n%q\nwant\n%q", got, want)
+	}
+
+	if mutated := mutants[len(mutants)-1].Apply(src); !strings.Contains(mutated, "log = log\n") {
+		t.Errorf("Apply() did not drop the append:\n%s", mutated)
+	}
+	if len(sample(mutants, 4)) != 4 {
+		t.Errorf("sample() did not limit the mutants")
+	}
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// stdlibImports maps package names that generated tests commonly use to
+// their import paths, for adding imports the model forgot.
+var stdlibImports = map[string]string{
+	"bufio":    "bufio",
+	"bytes":    "bytes",
+	"cmp":      "cmp",
+	"context":  "context",
+	"errors":   "errors",
+	"filepath": "path/filepath",
+	"fmt":      "fmt",
+	"http":     "net/http",
+	"httptest": "net/http/httptest",
+	"io":       "io",
+	"json":     "encoding/json",
+	"maps":     "maps",
+	"math":     "math",
+	"os":       "os",
+	"rand":     "math/rand",
+	"reflect":  "reflect",
+	"regexp":   "regexp",
+	"slices":   "slices",
+	"sort":     "sort",
+	"strconv":  "strconv",
+	"strings":  "strings",
+	"sync":     "sync",
+	"atomic":   "sync/atomic",
+	"testing":  "testing",
+	"time":     "time",
+	"unicode":  "unicode",
+	"utf8":     "unicode/utf8",
+}
+
+// PostProcessor turns raw model output into a formatted Go test file.
+type PostProcessor struct {
+	PackageName string // package clause the test file must have
+	ImportName  string // name of the package under test, for external tests
+	ImportPath  string // import path of the package under test, empty for internal tests
+
+	// ExtraImports maps more package names to the import paths added when
+	// the tests use them, e.g. assert for testify.
+	ExtraImports map[string]string
+
+	// BuildConstraint is the //go:build line the test file must have,
+	// replacing any the model wrote; empty for none.
+	BuildConstraint string
+
+	// Reserved holds the package-level names the existing test files of
+	// the package declare; generated declarations with these names are
+	// renamed.
+	Reserved map[string]bool
+}
+
+// Process extracts the Go source from raw model output, fixes its package
+// clause and imports and formats it. It returns an error if the result is
+// not valid Go.
+func (pp PostProcessor) Process(raw string) (string, error) {
+	src := extractGoCode(raw)
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "generated_test.go", src, parser.ParseComments)
+	if err != nil && !hasPackageClause(src) {
+		// Models sometimes omit the package clause entirely
+		src = "package " + pp.PackageName + "\n\n" + src
+		file, err = parser.ParseFile(fset, "generated_test.go", src, parser.ParseComments)
+	}
+	if err != nil {
+		return "", fmt.Errorf("generated code is not valid Go: %v", err)
+	}
+
+	file.Name.Name = pp.PackageName
+	pp.fixImports(fset, file)
+	pp.renameReserved(file)
+	removeBuildConstraints(file)
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return "", fmt.Errorf("failed to format generated code: %v", err)
+	}
+
+	// Round-trip through the parser so anything the AST edits broke is caught
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("generated code is not valid Go: %v", err)
+	}
+
+	if pp.BuildConstraint != "" {
+		return pp.BuildConstraint + "\n\n" + string(formatted), nil
+	}
+	return string(formatted), nil
+}
+
+// renameReserved renames the package-level declarations of file whose
+// names are reserved, together with their uses in the file, e.g. TestAdd
+// becomes TestAdd_generated.
+func (pp PostProcessor) renameReserved(file *ast.File) {
+	if len(pp.Reserved) == 0 {
+		return
+	}
+
+	declared := make(map[string]bool)
+	for _, name := range packageLevelNames(file) {
+		declared[name] = true
+	}
+	renames := make(map[string]string)
+	for name := range declared {
+		if !pp.Reserved[name] {
+			continue
+		}
+		newName := name + "_generated"
+		for n := 2; pp.Reserved[newName] || declared[newName]; n++ {
+			newName = fmt.Sprintf("%s_generated%d", name, n)
+		}
+		renames[name] = newName
+	}
+	if len(renames) == 0 {
+		return
+	}
+
+	// Selected names and field names are not package-level identifiers
+	var rename func(n ast.Node) bool
+	rename = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			ast.Inspect(n.X, rename)
+			return false
+		case *ast.Field:
+			ast.Inspect(n.Type, rename)
+			return false
+		case *ast.CompositeLit:
+			// Keys that are bare names are taken for struct fields
+			if n.Type != nil {
+				ast.Inspect(n.Type, rename)
+			}
+			for _, elt := range n.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if _, ok := kv.Key.(*ast.Ident); !ok {
+						ast.Inspect(kv.Key, rename)
+					}
+					ast.Inspect(kv.Value, rename)
+				} else {
+					ast.Inspect(elt, rename)
+				}
+			}
+			return false
+		case *ast.FuncDecl:
+			if n.Recv != nil {
+				ast.Inspect(n.Recv, rename)
+				ast.Inspect(n.Type, rename)
+				if n.Body != nil {
+					ast.Inspect(n.Body, rename)
+				}
+				return false
+			}
+		case *ast.Ident:
+			if newName, ok := renames[n.Name]; ok {
+				n.Name = newName
+			}
+		}
+		return true
+	}
+	for _, decl := range file.Decls {
+		ast.Inspect(decl, rename)
+	}
+}
+
+// packageLevelNames returns the names file declares at package level,
+// leaving out methods and blank identifiers.
+func packageLevelNames(file *ast.File) []string {
+	var names []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				names = append(names, d.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						if name.Name != "_" {
+							names = append(names, name.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// removeBuildConstraints drops the //go:build and // +build lines above the
+// package clause.
+func removeBuildConstraints(file *ast.File) {
+	var comments []*ast.CommentGroup
+	for _, group := range file.Comments {
+		if group.Pos() < file.Package {
+			var kept []*ast.Comment
+			for _, comment := range group.List {
+				if !constraint.IsGoBuild(comment.Text) && !constraint.IsPlusBuild(comment.Text) {
+					kept = append(kept, comment)
+				}
+			}
+			if len(kept) == 0 {
+				if file.Doc == group {
+					file.Doc = nil
+				}
+				continue
+			}
+			group.List = kept
+		}
+		comments = append(comments, group)
+	}
+	file.Comments = comments
+}
+
+// fixImports removes imports that are never referenced and adds the ones
+// for package qualifiers that are used but not imported. Imports whose
+// package name is not known for certain are kept.
+func (pp PostProcessor) fixImports(fset *token.FileSet, file *ast.File) {
+	used := usedQualifiers(file)
+
+	imported := make(map[string]bool)
+	// Deleting imports edits file.Imports, so iterate over a copy
+	for _, spec := range append([]*ast.ImportSpec(nil), file.Imports...) {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name, certain := importName(spec, importPath)
+		for known, extraPath := range pp.ExtraImports {
+			if extraPath == importPath && spec.Name == nil {
+				name, certain = known, true
+			}
+		}
+		if importPath == pp.ImportPath && spec.Name == nil {
+			name, certain = pp.ImportName, true
+		}
+		if name == "_" || name == "." {
+			imported[name] = true
+			continue
+		}
+		// An import whose name is a guess may be used under its real one
+		if !used[name] && certain {
+			if spec.Name != nil {
+				astutil.DeleteNamedImport(fset, file, spec.Name.Name, importPath)
+			} else {
+				astutil.DeleteImport(fset, file, importPath)
+			}
+			continue
+		}
+		imported[name] = true
+	}
+
+	var missing []string
+	for name := range used {
+		if !imported[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+
+	for _, name := range missing {
+		switch {
+		case pp.ImportPath != "" && name == pp.ImportName:
+			if path.Base(pp.ImportPath) == name {
+				astutil.AddImport(fset, file, pp.ImportPath)
+			} else {
+				astutil.AddNamedImport(fset, file, name, pp.ImportPath)
+			}
+		case stdlibImports[name] != "":
+			astutil.AddImport(fset, file, stdlibImports[name])
+		case pp.ExtraImports[name] != "":
+			astutil.AddImport(fset, file, pp.ExtraImports[name])
+		}
+	}
+}
+
+// usedQualifiers returns the identifiers used as the operand of a selector
+// that are not declared anywhere in the file, i.e. package names.
+func usedQualifiers(file *ast.File) map[string]bool {
+	unresolved := make(map[*ast.Ident]bool)
+	for _, ident := range file.Unresolved {
+		unresolved[ident] = true
+	}
+
+	used := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); ok && unresolved[x] {
+			used[x.Name] = true
+		}
+		return true
+	})
+	return used
+}
+
+// importName returns the name an import is referred to by in the file, and
+// whether it is certain. The package name of an unnamed import outside the
+// standard library is only guessed from its path: gopkg.in/yaml.v3 declares
+// package yaml, but github.com/mattn/go-sqlite3 declares sqlite3.
+func importName(spec *ast.ImportSpec, importPath string) (string, bool) {
+	if spec.Name != nil {
+		return spec.Name.Name, true
+	}
+	name := path.Base(importPath)
+	if !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".") {
+		return name, true
+	}
+	// Major version suffixes are not part of the package name
+	if len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = path.Base(path.Dir(importPath))
+	}
+	return strings.ReplaceAll(name, "-", "_"), false
+}
+
+// extractGoCode returns the Go source in raw model output. When the output
+// contains fenced code blocks, the first one that parses as a Go file is
+// used, then the first one starting with a package clause, falling back to
+// the longest block; otherwise the whole text is returned. Inside a block,
+// only a bare fence at the start of a line closes it, and not while a raw
+// string literal or block comment is open, so backticks in test data are
+// left alone. Backticks the lexer cannot follow, such as an odd number of
+// them in a one-line raw string, can keep a block open to the end, so the
+// blocks between bare fences are preferred when one of them parses or
+// when they leave no block open.
+func extractGoCode(raw string) string {
+	blocks, open := fencedBlocks(raw, true)
+	if len(blocks) == 0 {
+		return raw
+	}
+
+	if block, ok := firstParsing(blocks); ok {
+		return block
+	}
+	bare, bareOpen := fencedBlocks(raw, false)
+	if block, ok := firstParsing(bare); ok {
+		return block
+	}
+	if open && !bareOpen {
+		blocks = bare
+	}
+	longest := blocks[0]
+	for _, block := range blocks {
+		if hasPackageClause(block) {
+			return block
+		}
+		if len(block) > len(longest) {
+			longest = block
+		}
+	}
+	return longest
+}
+
+// fencedBlocks returns the contents of the fenced code blocks in raw, and
+// whether the last one is not closed. With lex set, a fence inside a raw
+// string literal or block comment does not close a block.
+func fencedBlocks(raw string, lex bool) ([]string, bool) {
+	var blocks []string
+	var current []string
+	inBlock := false
+	state := lexCode
+
+	for _, line := range strings.Split(raw, "\n") {
+		if !inBlock {
+			if strings.HasPrefix(strings.TrimSpace(line), "```") {
+				inBlock = true
+				state = lexCode
+			}
+			continue
+		}
+		if state == lexCode && strings.TrimRight(line, " \t\r") == "```" {
+			blocks = append(blocks, strings.Join(current, "\n"))
+			current = nil
+			inBlock = false
+			continue
+		}
+		current = append(current, line)
+		if lex {
+			state = state.scanLine(line)
+		}
+	}
+	if inBlock && len(current) > 0 {
+		// Unterminated fence, typically a truncated response
+		blocks = append(blocks, strings.Join(current, "\n"))
+	}
+	return blocks, inBlock
+}
+
+// firstParsing returns the first of blocks that parses as a Go file.
+func firstParsing(blocks []string) (string, bool) {
+	for _, block := range blocks {
+		if _, err := parser.ParseFile(token.NewFileSet(), "", block, parser.SkipObjectResolution); err == nil {
+			return block, true
+		}
+	}
+	return "", false
+}
+
+// lexState is where a line of Go source leaves the lexer: in code, or
+// inside a raw string literal or block comment spanning lines.
+type lexState int
+
+const (
+	lexCode lexState = iota
+	lexRawString
+	lexBlockComment
+)
+
+// scanLine returns the state after line, starting from s.
+func (s lexState) scanLine(line string) lexState {
+	for i := 0; i < len(line); i++ {
+		switch s {
+		case lexRawString:
+			if line[i] == '`' {
+				s = lexCode
+			}
+		case lexBlockComment:
+			if strings.HasPrefix(line[i:], "*/") {
+				s = lexCode
+				i++
+			}
+		default:
+			switch c := line[i]; {
+			case c == '`':
+				s = lexRawString
+			case strings.HasPrefix(line[i:], "//"):
+				return s
+			case strings.HasPrefix(line[i:], "/*"):
+				s = lexBlockComment
+				i++
+			case c == '"' || c == '\'':
+				for i++; i < len(line) && line[i] != c; i++ {
+					if line[i] == '\\' {
+						i++
+					}
+				}
+			}
+		}
+	}
+	return s
+}
+
+func hasPackageClause(src string) bool {
+	_, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly)
+	return err == nil
+}
+
+// declaredFunctions returns the names of the top-level functions in src.
+func declaredFunctions(src string) (map[string]bool, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated code: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+			names[fn.Name.Name] = true
+		}
+	}
+	return names, nil
+}
+
+// removeDeclarations deletes the named top-level functions and types from
+// src, along with the methods of those types and imports that are no longer
+// used, and returns the formatted result.
+func (pp PostProcessor) removeDeclarations(src string, names map[string]bool) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "generated_test.go", src, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse generated code: %v", err)
+	}
+
+	var kept []ast.Decl
+	var removed []ast.Node
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				name = receiverTypeName(d)
+			}
+			if names[name] {
+				removed = append(removed, d)
+				continue
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.TYPE {
+				var specs []ast.Spec
+				for _, spec := range d.Specs {
+					if names[spec.(*ast.TypeSpec).Name.Name] {
+						removed = append(removed, spec)
+						continue
+					}
+					specs = append(specs, spec)
+				}
+				if len(specs) == 0 {
+					removed = append(removed, d)
+					continue
+				}
+				d.Specs = specs
+			}
+		}
+		kept = append(kept, decl)
+	}
+	file.Decls = kept
+
+	// Drop the doc comments of removed declarations along with them
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, &printer.CommentedNode{Node: file, Comments: keptComments(file, removed)}); err != nil {
+		return "", fmt.Errorf("failed to format generated code: %v", err)
+	}
+
+	// Reparse so import resolution sees the remaining code only
+	return pp.Process(buf.String())
+}
+
+// keptComments returns the comment groups of file that do not lie within
+// one of the removed declarations or their doc comments.
+func keptComments(file *ast.File, removed []ast.Node) []*ast.CommentGroup {
+	var kept []*ast.CommentGroup
+	for _, group := range file.Comments {
+		inside := false
+		for _, node := range removed {
+			start := node.Pos()
+			if fn, ok := node.(*ast.FuncDecl); ok && fn.Doc != nil {
+				start = fn.Doc.Pos()
+			} else if spec, ok := node.(*ast.TypeSpec); ok && spec.Doc != nil {
+				start = spec.Doc.Pos()
+			} else if gen, ok := node.(*ast.GenDecl); ok && gen.Doc != nil {
+				start = gen.Doc.Pos()
+			}
+			if group.Pos() >= start && group.End() <= node.End() {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			kept = append(kept, group)
+		}
+	}
+	return kept
+}
+
+// skipTests makes each named test function skip itself with the given
+// reason as its first statement, and returns the formatted result. Tests
+// whose *testing.T parameter is unnamed cannot be skipped this way and are
+// returned in unchanged.
+func (pp PostProcessor) skipTests(src string, reasons map[string]string) (string, []string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "generated_test.go", src, parser.ParseComments)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse generated code: %v", err)
+	}
+
+	// Insert from the end so earlier offsets stay valid
+	type insertion struct {
+		offset int
+		text   string
+	}
+	var insertions []insertion
+	var unchanged []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Body == nil {
+			continue
+		}
+		reason, ok := reasons[fn.Name.Name]
+		if !ok {
+			continue
+		}
+		params := fn.Type.Params.List
+		if len(params) != 1 || len(params[0].Names) != 1 || params[0].Names[0].Name == "_" {
+			unchanged = append(unchanged, fn.Name.Name)
+			continue
+		}
+		t := params[0].Names[0].Name
+		insertions = append(insertions, insertion{
+			offset: fset.Position(fn.Body.Lbrace).Offset + 1,
+			text:   fmt.Sprintf("\n%s.Skip(%s)", t, strconv.Quote(reason)),
+		})
+	}
+	sort.Slice(insertions, func(i, j int) bool { return insertions[i].offset > insertions[j].offset })
+	for _, ins := range insertions {
+		src = src[:ins.offset] + ins.text + src[ins.offset:]
+	}
+
+	content, err := pp.Process(src)
+	return content, unchanged, err
+}
+
+// extractDeclarations returns the source of the named top-level functions
+// in src, with their doc comments, in the order they appear.
+func extractDeclarations(src string, names map[string]bool) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "generated_test.go", src, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse generated code: %v", err)
+	}
+
+	var decls []*ast.FuncDecl
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && names[fn.Name.Name] {
+			decls = append(decls, fn)
+		}
+	}
+	var out strings.Builder
+	for _, fn := range decls {
+		start := fn.Pos()
+		if fn.Doc != nil {
+			start = fn.Doc.Pos()
+		}
+		out.WriteString(src[fset.Position(start).Offset:fset.Position(fn.End()).Offset])
+		out.WriteString("\n\n")
+	}
+	return out.String(), nil
+}
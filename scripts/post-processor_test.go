@@ -0,0 +1,279 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractGoCode(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "no fences",
+			raw:  "package x\n",
+			want: "package x\n",
+		},
+		{
+			name: "single fenced block with prose",
+			raw:  "Here are the tests:\n```go\npackage x\n```\nHope this helps.",
+			want: "package x",
+		},
+		{
+			name: "prefers block with package clause",
+			raw:  "```\ngo test ./...\n```\n\n```go\npackage x\n\nfunc f() {}\n```",
+			want: "package x\n\nfunc f() {}",
+		},
+		{
+			name: "backticks inside raw string are kept",
+			raw:  "```go\npackage x\n\nvar s = `a ``` b`\n```",
+			want: "package x\n\nvar s = `a ``` b`",
+		},
+		{
+			name: "backticks inside string are kept",
+			raw:  "```go\npackage x\n\nvar s = \"a ``` b\"\n```",
+			want: "package x\n\nvar s = \"a ``` b\"",
+		},
+		{
+			name: "fence inside multi-line raw string is kept",
+			raw:  "```go\npackage x\n\nvar s = `\n```\n  ```\n`\n```\nDone.",
+			want: "package x\n\nvar s = `\n```\n  ```\n`",
+		},
+		{
+			name: "backtick in string or comment does not open a raw string",
+			raw:  "```go\npackage x\n\nvar r, s = '`', \"`\" // `\n```\n",
+			want: "package x\n\nvar r, s = '`', \"`\" // `",
+		},
+		{
+			name: "prefers block that parses",
+			raw:  "```go\npackage x\n\nfunc f(\n```\n\n```go\npackage x\n\nfunc f() {}\n```",
+			want: "package x\n\nfunc f() {}",
+		},
+		{
+			name: "unterminated fence",
+			raw:  "```go\npackage x\n\nfunc f() {}",
+			want: "package x\n\nfunc f() {}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractGoCode(tt.raw); got != tt.want {
+				t.Errorf("extractGoCode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostProcessor_Process(t *testing.T) {
+	raw := "```go\n" + `package wrong
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDivide(t *testing.T) {
+	calc := calculator.NewCalculator()
+	if _, err := calc.Divide(1, 0); !errors.Is(err, err) {
+		t.Error(fmt.Sprintf("unexpected %v", err))
+	}
+}
+` + "```"
+
+	pp := PostProcessor{
+		PackageName: "calculator_test",
+		ImportName:  "calculator",
+		ImportPath:  "example.com/repo/pkg",
+	}
+	got, err := pp.Process(raw)
+	if err != nil {
+		t.Fatalf("Process returned error: %v", err)
+	}
+
+	for _, want := range []string{
+		"package calculator_test",
+		`"errors"`,
+		`"fmt"`,
+		`"testing"`,
+		`calculator "example.com/repo/pkg"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %s:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `"os"`) {
+		t.Errorf("unused import of os was not removed:\n%s", got)
+	}
+}
+
+func TestPostProcessor_ProcessKeepsUncertainImports(t *testing.T) {
+	src := `package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoad(t *testing.T) {
+	var v map[string]any
+	if err := yaml.Unmarshal([]byte("a: 1"), &v); err != nil {
+		t.Fatal(err)
+	}
+	_ = sqlite3.ErrNo(0)
+}
+`
+	got, err := PostProcessor{PackageName: "config"}.Process(src)
+	if err != nil {
+		t.Fatalf("Process returned error: %v", err)
+	}
+	for _, want := range []string{`"gopkg.in/yaml.v3"`, `"github.com/mattn/go-sqlite3"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("used import %s was removed:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `"os"`) {
+		t.Errorf("unused import of os was not removed:\n%s", got)
+	}
+}
+
+func TestPostProcessor_ProcessAddsPackageClause(t *testing.T) {
+	pp := PostProcessor{PackageName: "calculator"}
+	got, err := pp.Process("func TestX(t *testing.T) {}\n")
+	if err != nil {
+		t.Fatalf("Process returned error: %v", err)
+	}
+	if !strings.HasPrefix(got, "package calculator\n") || !strings.Contains(got, `import "testing"`) {
+		t.Errorf("unexpected output:\n%s", got)
+	}
+}
+
+func TestPostProcessor_ProcessRejectsInvalidGo(t *testing.T) {
+	pp := PostProcessor{PackageName: "calculator"}
+	if _, err := pp.Process("```go\npackage calculator\n\nfunc TestX(t *testing.T) {\n```"); err == nil {
+		t.Error("expected an error for invalid Go")
+	}
+}
+
+func TestPostProcessor_ProcessRenamesReserved(t *testing.T) {
+	pp := PostProcessor{PackageName: "calculator", Reserved: map[string]bool{"TestAdd": true, "newCalc": true, "newCalc_generated": true}}
+	got, err := pp.Process(`package calculator
+
+import "testing"
+
+type fake struct{ newCalc int }
+
+func newCalc() *Calculator { return &Calculator{} }
+
+func TestAdd(t *testing.T) {
+	c := newCalc()
+	_ = fake{newCalc: 1}
+	_ = c.newCalc
+}
+`)
+	if err != nil {
+		t.Fatalf("Process returned error: %v", err)
+	}
+	for _, want := range []string{"func TestAdd_generated(t *testing.T)", "func newCalc_generated2()", "c := newCalc_generated2()", "struct{ newCalc int }", "fake{newCalc: 1}", "c.newCalc\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestPostProcessor_RemoveDeclarations(t *testing.T) {
+	src := `package store
+
+import (
+	"sync"
+	"testing"
+)
+
+// fakeStore is a fake Store for tests.
+type fakeStore struct {
+	mu sync.Mutex
+}
+
+// Get returns nothing.
+func (f *fakeStore) Get() {}
+
+// FuzzBroken does not pass.
+func FuzzBroken(f *testing.F) {}
+
+// TestGet stays.
+func TestGet(t *testing.T) {}
+`
+	pp := PostProcessor{PackageName: "store"}
+	got, err := pp.removeDeclarations(src, map[string]bool{"fakeStore": true, "FuzzBroken": true})
+	if err != nil {
+		t.Fatalf("removeDeclarations returned error: %v", err)
+	}
+
+	for _, gone := range []string{"fakeStore", "FuzzBroken", "Get returns", `"sync"`} {
+		if strings.Contains(got, gone) {
+			t.Errorf("output still contains %s:\n%s", gone, got)
+		}
+	}
+	if !strings.Contains(got, "// TestGet stays.\nfunc TestGet(t *testing.T) {}") {
+		t.Errorf("TestGet or its doc comment was removed:\n%s", got)
+	}
+}
+
+func TestPostProcessor_SkipTests(t *testing.T) {
+	src := `package calc
+
+import "testing"
+
+func TestFlaky(t *testing.T) {
+	t.Log("flaky")
+}
+
+func TestUnnamed(*testing.T) {}
+
+func TestStable(t *testing.T) {}
+`
+	pp := PostProcessor{PackageName: "calc"}
+	got, unchanged, err := pp.skipTests(src, map[string]string{"TestFlaky": "quarantined as flaky", "TestUnnamed": "quarantined as flaky"})
+	if err != nil {
+		t.Fatalf("skipTests returned error: %v", err)
+	}
+
+	if !strings.Contains(got, "func TestFlaky(t *testing.T) {\n\tt.Skip(\"quarantined as flaky\")\n\tt.Log(\"flaky\")\n}") {
+		t.Errorf("TestFlaky does not skip itself first:\n%s", got)
+	}
+	if strings.Count(got, "Skip") != 1 {
+		t.Errorf("only TestFlaky should skip:\n%s", got)
+	}
+	if len(unchanged) != 1 || unchanged[0] != "TestUnnamed" {
+		t.Errorf("unchanged = %v, want [TestUnnamed]", unchanged)
+	}
+}
+
+func TestExtractDeclarations(t *testing.T) {
+	src := `package calc
+
+import "testing"
+
+// TestAdd checks sums.
+func TestAdd(t *testing.T) {
+	if Add(1, 2) != 3 {
+		t.Error("wrong sum")
+	}
+}
+
+func TestSub(t *testing.T) {}
+`
+	got, err := extractDeclarations(src, map[string]bool{"TestAdd": true, "TestMissing": true})
+	if err != nil {
+		t.Fatalf("extractDeclarations returned error: %v", err)
+	}
+	want := "// TestAdd checks sums.\nfunc TestAdd(t *testing.T) {\n\tif Add(1, 2) != 3 {\n\t\tt.Error(\"wrong sum\")\n\t}\n}\n\n"
+	if got != want {
+		t.Errorf("extractDeclarations() = %q, want %q", got, want)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	// "encoding/base64"
 	"fmt"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -32,7 +34,22 @@ func NewPRCreator(token, repoOwner, repoName string) *PRCreator {
 	}
 }
 
-func (pc *PRCreator) CreateTestPR(ctx context.Context, originalFile, testFileName, testContent, branchName string, coverage float64) error {
+// SourceTests are the test files generated for one changed source file.
+type SourceTests struct {
+	File      string // relative to the repository root
+	Coverage  float64
+	Generated []*GeneratedTests
+}
+
+// ModuleTests are the tests generated for the changed files of one module,
+// published together in one PR.
+type ModuleTests struct {
+	Dir     string // module root relative to the repository root, "." for the root
+	Path    string // module path
+	Sources []SourceTests
+}
+
+func (pc *PRCreator) CreateTestPR(ctx context.Context, module *ModuleTests, branchName string) error {
 	// Get the main branch ref
 	mainRef, _, err := pc.client.Git.GetRef(ctx, pc.repoOwner, pc.repoName, "refs/heads/main")
 	if err != nil {
@@ -65,15 +82,30 @@ func (pc *PRCreator) CreateTestPR(ctx context.Context, originalFile, testFileNam
 		return fmt.Errorf("failed to create branch: %v", err)
 	}
 
-	// Create or update the test file
-	err = pc.createOrUpdateFile(ctx, testFileName, testContent, branchName)
-	if err != nil {
-		return fmt.Errorf("failed to create test file: %v", err)
+	for _, source := range module.Sources {
+		for _, file := range source.Generated {
+			// Create the test file
+			err = pc.createFile(ctx, file.TestFile, file.Content, branchName)
+			if err != nil {
+				return fmt.Errorf("failed to create test file %s: %v", file.TestFile, err)
+			}
+
+			// Helper files such as fakes live next to the test file
+			for _, helper := range file.Helpers {
+				helperPath := path.Join(path.Dir(file.TestFile), helper.Name)
+				if err := pc.createFile(ctx, helperPath, helper.Content, branchName); err != nil {
+					return fmt.Errorf("failed to create helper file %s: %v", helperPath, err)
+				}
+			}
+		}
 	}
 
 	// Create pull request
-	title := fmt.Sprintf("🧪 Auto-generated tests for %s", originalFile)
-	body := pc.buildPRDescription(originalFile, coverage)
+	title := fmt.Sprintf("🧪 Auto-generated tests for module %s", module.Path)
+	if len(module.Sources) == 1 {
+		title = fmt.Sprintf("🧪 Auto-generated tests for %s", module.Sources[0].File)
+	}
+	body := pc.buildPRDescription(module)
 	
 	pr := &github.NewPullRequest{
 		Title: github.String(title),
@@ -90,59 +122,78 @@ func (pc *PRCreator) CreateTestPR(ctx context.Context, originalFile, testFileNam
 	return nil
 }
 
-func (pc *PRCreator) createOrUpdateFile(ctx context.Context, filePath, content, branchName string) error {
+// createFile adds a new file to the branch. Files that already exist are
+// never updated, so tests written by hand cannot be replaced.
+func (pc *PRCreator) createFile(ctx context.Context, filePath, content, branchName string) error {
 	// Check if file already exists
-	existingFile, _, resp, err := pc.client.Repositories.GetContents(ctx, pc.repoOwner, pc.repoName, filePath, &github.RepositoryContentGetOptions{
+	_, _, resp, err := pc.client.Repositories.GetContents(ctx, pc.repoOwner, pc.repoName, filePath, &github.RepositoryContentGetOptions{
 		Ref: branchName,
 	})
-
-	if err != nil && resp.StatusCode == 404 {
-		// File doesn't exist, create it
-		fileOptions := &github.RepositoryContentFileOptions{
-			Message: github.String(fmt.Sprintf("Add auto-generated tests for %s", filePath)),
-			Content: []byte(content), // FIXED: Use raw content bytes
-			Branch:  github.String(branchName),
-		}
-
-		_, _, err = pc.client.Repositories.CreateFile(ctx, pc.repoOwner, pc.repoName, filePath, fileOptions)
-		if err != nil {
-			return fmt.Errorf("failed to create file: %v", err)
-		}
-	} else if err != nil {
+	if err == nil {
+		return fmt.Errorf("%s already exists", filePath)
+	}
+	if resp == nil || resp.StatusCode != 404 {
 		return fmt.Errorf("failed to check file existence: %v", err)
-	} else {
-		// File exists, update it
-		fileOptions := &github.RepositoryContentFileOptions{
-			Message: github.String(fmt.Sprintf("Update auto-generated tests for %s", filePath)),
-			Content: []byte(content), // FIXED: Use raw content bytes
-			Branch:  github.String(branchName),
-			SHA:     existingFile.SHA,
-		}
+	}
 
-		_, _, err = pc.client.Repositories.UpdateFile(ctx, pc.repoOwner, pc.repoName, filePath, fileOptions)
-		if err != nil {
-			return fmt.Errorf("failed to update file: %v", err)
-		}
+	fileOptions := &github.RepositoryContentFileOptions{
+		Message: github.String(fmt.Sprintf("Add auto-generated tests for %s", filePath)),
+		Content: []byte(content), // FIXED: Use raw content bytes
+		Branch:  github.String(branchName),
+	}
+
+	_, _, err = pc.client.Repositories.CreateFile(ctx, pc.repoOwner, pc.repoName, filePath, fileOptions)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
 	}
 
 	return nil
 }
 
-func (pc *PRCreator) buildPRDescription(originalFile string, coverage float64) string {
+func (pc *PRCreator) buildPRDescription(module *ModuleTests) string {
 	var body strings.Builder
 	
 	body.WriteString("## 🤖 Auto-Generated Unit Tests\n\n")
-	body.WriteString(fmt.Sprintf("This PR contains automatically generated unit tests for `%s`.\n\n", originalFile))
+	if len(module.Sources) == 1 {
+		body.WriteString(fmt.Sprintf("This PR contains automatically generated unit tests for `%s`.\n\n", module.Sources[0].File))
+	} else {
+		body.WriteString(fmt.Sprintf("This PR contains automatically generated unit tests for %d changed files of module `%s`.\n\n", len(module.Sources), module.Path))
+	}
+	if module.Dir != "." {
+		body.WriteString(fmt.Sprintf("The module `%s` lives in `%s/`, where its coverage was measured and its tests are run.\n\n", module.Path, module.Dir))
+	}
 	body.WriteString("### 📊 Coverage Information\n")
-	body.WriteString(fmt.Sprintf("- **Original Coverage**: %.2f%%\n", coverage))
+	if len(module.Sources) == 1 {
+		body.WriteString(fmt.Sprintf("- **Original Coverage**: %.2f%%\n", module.Sources[0].Coverage))
+	} else {
+		body.WriteString("- **Original Coverage**:\n")
+		for _, source := range module.Sources {
+			body.WriteString(fmt.Sprintf("  - `%s`: %.2f%%\n", source.File, source.Coverage))
+		}
+	}
 	body.WriteString("- **Coverage Threshold**: 40.00%\n")
 	body.WriteString("- **Status**: ⚠️ Below threshold, tests generated\n\n")
-	
-	body.WriteString("### 🧪 Generated Tests Include\n")
-	body.WriteString("- Basic functionality tests\n")
-	body.WriteString("- Edge case handling\n")
-	body.WriteString("- Error condition testing\n")
-	body.WriteString("- Input validation tests\n\n")
+
+	var generated []*GeneratedTests
+	for _, source := range module.Sources {
+		generated = append(generated, source.Generated...)
+	}
+
+	// Test files are reported file by file when there are several
+	var versions []string
+	for _, file := range generated {
+		if len(generated) > 1 {
+			kind := "Black-box tests"
+			if strings.HasSuffix(file.TestFile, "_internal_test.go") {
+				kind = "White-box tests of unexported functions"
+			}
+			body.WriteString(fmt.Sprintf("## 📄 `%s`\n%s.\n\n", file.TestFile, kind))
+		}
+		pc.writeTestFileDescription(&body, file)
+		if file.PromptVersion != "" && !slices.Contains(versions, file.PromptVersion) {
+			versions = append(versions, file.PromptVersion)
+		}
+	}
 	
 	body.WriteString("### ✅ Review Checklist\n")
 	body.WriteString("- [ ] Tests cover the main functionality\n")
@@ -158,11 +209,214 @@ func (pc *PRCreator) buildPRDescription(originalFile string, coverage float64) s
 	body.WriteString("4. Merge when tests are satisfactory\n\n")
 	
 	body.WriteString("---\n")
-	body.WriteString("*This PR was automatically created by the Auto Test Generator workflow.*")
+	if len(versions) > 0 {
+		body.WriteString(fmt.Sprintf("*This PR was automatically created by the Auto Test Generator workflow with prompt `%s`.*", strings.Join(versions, "`, `")))
+	} else {
+		body.WriteString("*This PR was automatically created by the Auto Test Generator workflow.*")
+	}
 	
 	return body.String()
 }
 
+// writeTestFileDescription describes how one generated test file came
+// about.
+func (pc *PRCreator) writeTestFileDescription(body *strings.Builder, generated *GeneratedTests) {
+	if generated.Skeleton {
+		body.WriteString("### 🦴 Skeleton Tests\n")
+		body.WriteString(fmt.Sprintf("The model could not be used (%s), so this PR contains table-driven test skeletons instead.\n", generated.FallbackReason))
+		body.WriteString("Each test has one skipped case with zero-value inputs: replace the values marked `TODO` with real inputs and expected results, then remove the `t.Skip` call.\n\n")
+	} else {
+		body.WriteString("### 🧪 Generated Tests Include\n")
+		body.WriteString("- Basic functionality tests\n")
+		body.WriteString("- Edge case handling\n")
+		body.WriteString("- Error condition testing\n")
+		body.WriteString("- Input validation tests\n\n")
+	}
+
+	if line := generated.Build.Line(); line != "" {
+		body.WriteString("### 🏷️ Build Constraints\n")
+		body.WriteString(fmt.Sprintf("Like the source file, the tests only build under `%s`", line))
+		if generated.Build.String() != "" {
+			command := append(append(generated.Build.Env(), "go", "test"), append(generated.Build.Flags(), ".")...)
+			body.WriteString(fmt.Sprintf("; they were validated and are run with `%s`", strings.Join(command, " ")))
+		}
+		body.WriteString(".\n")
+		if generated.Build.CrossCompiled() {
+			body.WriteString("\n⚠️ The CI runner cannot run binaries for this platform, so the tests were only compiled and vetted: none of them has been run.\n")
+		}
+		body.WriteString("\n")
+	}
+
+	if len(generated.Fakes) > 0 {
+		body.WriteString("### 🎭 Fakes\n")
+		body.WriteString("Hand-rolled fakes for interface dependencies, in ")
+		for i, helper := range generated.Helpers {
+			if i > 0 {
+				body.WriteString(", ")
+			}
+			body.WriteString(fmt.Sprintf("`%s`", helper.Name))
+		}
+		body.WriteString(":\n")
+		for _, fake := range generated.Fakes {
+			body.WriteString(fmt.Sprintf("- `%s` implements `%s`\n", fake.Name, fake.Interface))
+		}
+		body.WriteString("\n")
+	}
+
+	if len(generated.FuzzTargets) > 0 {
+		body.WriteString("### 🎲 Fuzz Targets\n")
+		body.WriteString("These targets passed a short `go test -fuzz` run; run them longer with `go test -run=^$ -fuzz=^<name>$`:\n")
+		for _, name := range generated.FuzzTargets {
+			body.WriteString(fmt.Sprintf("- `%s`\n", name))
+		}
+		body.WriteString("\n")
+	}
+
+	if len(generated.Examples) > 0 {
+		body.WriteString("### 📘 Examples\n")
+		body.WriteString("These examples show up in the package documentation; their output was checked over repeated runs:\n")
+		for _, name := range generated.Examples {
+			body.WriteString(fmt.Sprintf("- `%s`\n", name))
+		}
+		body.WriteString("\n")
+	}
+
+	if len(generated.Benchmarks) > 0 {
+		body.WriteString("### ⏱️ Benchmark Baseline\n")
+		body.WriteString("| Benchmark | ns/op | B/op | allocs/op |\n")
+		body.WriteString("|---|---:|---:|---:|\n")
+		for _, result := range generated.Benchmarks {
+			body.WriteString(fmt.Sprintf("| `%s` | %.1f | %d | %d |\n", result.Name, result.NsPerOp, result.BytesPerOp, result.AllocsPerOp))
+		}
+		body.WriteString("\nMeasured on the CI runner when the tests were generated; compare with `go test -run=^$ -bench=. -benchmem`.\n\n")
+	}
+
+	if len(generated.Quality) > 0 {
+		body.WriteString("### 🔍 Assertion Quality\n")
+		body.WriteString("| Test | Score | Assertions | Issues |\n")
+		body.WriteString("|---|---:|---:|---|\n")
+		for _, quality := range generated.Quality {
+			issues := strings.Join(quality.Issues, "; ")
+			if issues == "" {
+				issues = "-"
+			}
+			body.WriteString(fmt.Sprintf("| `%s` | %d | %d | %s |\n", quality.Name, quality.Score, quality.Assertions, strings.ReplaceAll(issues, "|", "\\|")))
+		}
+		body.WriteString("\n")
+	}
+
+	if len(generated.Candidates) > 0 {
+		body.WriteString("### 🎯 Candidates\n")
+		body.WriteString("Several test files were generated and compared by the statements of the target functions their passing tests cover:\n\n")
+		body.WriteString("| # | Prompt | Temperature | Passing | Covered | Outcome |\n")
+		body.WriteString("|---:|---|---:|---:|---:|---|\n")
+		for _, candidate := range generated.Candidates {
+			outcome := "-"
+			switch {
+			case candidate.Error != "":
+				outcome = "discarded: " + summarizeComment(candidate.Error)
+			case candidate.Selected:
+				outcome = "selected"
+			case len(candidate.Merged) > 0:
+				outcome = "merged " + strings.Join(candidate.Merged, ", ")
+			}
+			passing, covered := "-", "-"
+			if candidate.Error == "" {
+				passing = fmt.Sprintf("%d/%d", candidate.Passing, candidate.Tests)
+				covered = fmt.Sprintf("%d/%d", candidate.Covered, candidate.Total)
+			}
+			body.WriteString(fmt.Sprintf("| %d | `%s` | %.1f | %s | %s | %s |\n", candidate.Index, candidate.Prompt, candidate.Temperature, passing, covered, strings.ReplaceAll(outcome, "|", "\\|")))
+		}
+		body.WriteString("\n")
+	}
+
+	if len(generated.Mutation) > 0 {
+		body.WriteString("### 🧬 Mutation Score\n")
+		body.WriteString("Each function was mutated (operators flipped, constants changed, conditions negated, appends dropped) and the tests run against every mutant:\n\n")
+		body.WriteString("| Function | Killed | Score | Surviving mutants |\n")
+		body.WriteString("|---|---:|---:|---|\n")
+		for _, score := range generated.Mutation {
+			survivors := "-"
+			if len(score.Survivors) > 0 {
+				shown := score.Survivors
+				if len(shown) > 3 {
+					shown = shown[:3]
+				}
+				survivors = strings.Join(shown, "; ")
+				if more := len(score.Survivors) - len(shown); more > 0 {
+					survivors += fmt.Sprintf(" and %d more", more)
+				}
+			}
+			percent := "n/a"
+			if score.Total > 0 {
+				percent = fmt.Sprintf("%.0f%%", score.Percent())
+			}
+			body.WriteString(fmt.Sprintf("| `%s` | %d/%d | %s | %s |\n", score.Function, score.Killed, score.Total, percent, strings.ReplaceAll(survivors, "|", "\\|")))
+		}
+		body.WriteString("\nSurviving mutants point at behavior no test checks.\n\n")
+	}
+
+	if !generated.Skeleton && (generated.Style != DefaultStyleProfile() || generated.StyleNote != "" || len(generated.StyleViolations) > 0) {
+		body.WriteString("### 🎨 Test Style\n")
+		body.WriteString(fmt.Sprintf("The tests follow the `%s` style profile", generated.Style))
+		if generated.StyleNote != "" {
+			body.WriteString(fmt.Sprintf(": %s", generated.StyleNote))
+		}
+		body.WriteString(".\n")
+		if len(generated.StyleViolations) > 0 {
+			body.WriteString("Generated code breaking it was removed:\n")
+			for _, v := range generated.StyleViolations {
+				body.WriteString(fmt.Sprintf("- %s\n", v))
+			}
+		}
+		body.WriteString("\n")
+	}
+
+	if len(generated.Suspicious) > 0 {
+		body.WriteString("### ⚠️ Suspicious Comments\n")
+		body.WriteString("These comments in the source read like instructions to the model generating the tests. Check that they did not steer it:\n")
+		for _, comment := range generated.Suspicious {
+			body.WriteString(fmt.Sprintf("- `%s` (%s): %s\n", comment.Location, comment.Reason, strings.ReplaceAll(comment.Text, "`", "'")))
+		}
+		body.WriteString("\n")
+	}
+
+	if len(generated.Violations) > 0 {
+		body.WriteString("### 🛡️ Safety Policy\n")
+		if generated.Skeleton {
+			body.WriteString("The model's tests were rejected for breaking the safety policy:\n")
+		} else {
+			body.WriteString("Generated tests breaking the safety policy were removed:\n")
+		}
+		for _, v := range generated.Violations {
+			body.WriteString(fmt.Sprintf("- %s\n", v))
+		}
+		body.WriteString("\n")
+	}
+
+	if len(generated.Flaky) > 0 {
+		body.WriteString("### 🔁 Flaky Tests\n")
+		body.WriteString("These tests gave different results over repeated, shuffled runs:\n")
+		for _, flaky := range generated.Flaky {
+			action := "dropped"
+			if flaky.Quarantined {
+				action = "quarantined with `t.Skip`"
+			}
+			body.WriteString(fmt.Sprintf("- `%s` (%s): %s\n", flaky.Name, action, flaky.Reason))
+		}
+		body.WriteString("\n")
+	}
+
+	if len(generated.Dropped) > 0 {
+		body.WriteString("### 🗑️ Dropped Tests\n")
+		body.WriteString("These generated tests failed validation and were removed:\n")
+		for _, dropped := range generated.Dropped {
+			body.WriteString(fmt.Sprintf("- `%s`: %s\n", dropped.Name, dropped.Reason))
+		}
+		body.WriteString("\n")
+	}
+}
+
 func (pc *PRCreator) CommentOnPR(ctx context.Context, prNumber, message string) error {
 	if prNumber == "" {
 		// No PR to comment on, skip
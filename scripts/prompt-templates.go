@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DefaultPromptVariant is the prompt used unless others are configured.
+const DefaultPromptVariant = "default"
+
+// commonTemplate holds the definitions every variant builds on.
+const commonTemplate = "common.tmpl"
+
+//go:embed prompts/*.tmpl
+var embeddedPrompts embed.FS
+
+// PromptTemplates are the prompt variants, one text/template file each,
+// defining "version", "system" and "user" on top of the shared definitions
+// in common.tmpl. Files in an override directory replace the embedded file
+// of the same name or add variants.
+type PromptTemplates struct {
+	sources map[string]string // file name to template text
+}
+
+// LoadPromptTemplates reads the embedded templates and, if dir is not
+// empty, the *.tmpl files in dir over them.
+func LoadPromptTemplates(dir string) (*PromptTemplates, error) {
+	pt := &PromptTemplates{sources: make(map[string]string)}
+	entries, err := embeddedPrompts.ReadDir("prompts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded prompts: %v", err)
+	}
+	for _, entry := range entries {
+		content, err := embeddedPrompts.ReadFile("prompts/" + entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embedded prompt %s: %v", entry.Name(), err)
+		}
+		pt.sources[entry.Name()] = string(content)
+	}
+
+	if dir != "" {
+		overrides, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prompt templates in %s: %v", dir, err)
+		}
+		if len(overrides) == 0 {
+			return nil, fmt.Errorf("no *.tmpl prompt templates in %s", dir)
+		}
+		for _, path := range overrides {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read prompt template: %v", err)
+			}
+			pt.sources[filepath.Base(path)] = string(content)
+		}
+	}
+	return pt, nil
+}
+
+// Variants returns the names of the available variants.
+func (pt *PromptTemplates) Variants() []string {
+	var names []string
+	for name := range pt.sources {
+		if name != commonTemplate {
+			names = append(names, strings.TrimSuffix(name, ".tmpl"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Variant parses the named variant and returns it with its version: the
+// version the template declares followed by a hash of the template text, so
+// that edited overrides are told apart even if nobody bumped the version.
+func (pt *PromptTemplates) Variant(name string) (*template.Template, string, error) {
+	source, ok := pt.sources[name+".tmpl"]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown prompt variant %q (available: %s)", name, strings.Join(pt.Variants(), ", "))
+	}
+
+	// The real data functions are bound per prompt in renderPrompt
+	tmpl := template.New(name).Funcs(template.FuncMap{
+		"join":      strings.Join,
+		"data":      func(label, content string) string { return "" },
+		"dataRules": func() string { return "" },
+	})
+	if _, err := tmpl.New(commonTemplate).Parse(pt.sources[commonTemplate]); err != nil {
+		return nil, "", fmt.Errorf("failed to parse prompt template %s: %v", commonTemplate, err)
+	}
+	if _, err := tmpl.New(name + ".tmpl").Parse(source); err != nil {
+		return nil, "", fmt.Errorf("failed to parse prompt variant %s: %v", name, err)
+	}
+	for _, required := range []string{"version", "system", "user"} {
+		if tmpl.Lookup(required) == nil {
+			return nil, "", fmt.Errorf("prompt variant %s does not define %q", name, required)
+		}
+	}
+
+	var version strings.Builder
+	if err := tmpl.ExecuteTemplate(&version, "version", nil); err != nil {
+		return nil, "", fmt.Errorf("failed to render version of prompt variant %s: %v", name, err)
+	}
+	hash := sha256.Sum256([]byte(pt.sources[commonTemplate] + source))
+	return tmpl, fmt.Sprintf("%s #%s", strings.TrimSpace(version.String()), hex.EncodeToString(hash[:4])), nil
+}
+
+// promptData is what the prompt templates are executed with. Everything
+// read from the repository is already guarded and is only shown through
+// the data function, which wraps it in delimiters.
+type promptData struct {
+	FilePath    string
+	PackageName string
+	ImportPath  string // set for black-box tests
+	Source      string
+	TypeContext string
+	Functions   []promptFunction
+
+	Fakes       []Fake
+	FakeHelpers []HelperFile
+	FakeExample string
+
+	JSON       bool
+	Fuzz       []string // "Name (as FuzzName)" of the functions to fuzz
+	Benchmarks []string
+	Examples   []string
+
+	Style      StyleProfile
+	StyleGuide string
+}
+
+// promptFunction is a target function as the prompt describes it.
+type promptFunction struct {
+	Name          string // qualified, e.g. Calculator.Add
+	Signature     string
+	Complexity    int
+	ReturnsError  bool
+	References    []string
+	ExistingTests []string
+	TestName      string
+	Source        string // doc comment and body, guarded
+
+	TypeParams     []string // e.g. "T comparable"
+	Instantiations []string // e.g. "Max[int]", or "Stack[int]" for methods
+}
+
+// renderPrompt executes a variant with data, delimiting repository
+// content with a fresh delimiter.
+func renderPrompt(tmpl *template.Template, version string, data promptData) (Prompt, error) {
+	delimiter := newDataDelimiter()
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return Prompt{}, fmt.Errorf("failed to clone prompt template: %v", err)
+	}
+	tmpl.Funcs(template.FuncMap{
+		"data":      delimiter.block,
+		"dataRules": delimiter.rules,
+	})
+
+	var system, user strings.Builder
+	if err := tmpl.ExecuteTemplate(&system, "system", data); err != nil {
+		return Prompt{}, fmt.Errorf("failed to render system prompt: %v", err)
+	}
+	if err := tmpl.ExecuteTemplate(&user, "user", data); err != nil {
+		return Prompt{}, fmt.Errorf("failed to render user prompt: %v", err)
+	}
+	return Prompt{System: system.String(), User: user.String(), Version: version}, nil
+}
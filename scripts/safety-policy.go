@@ -0,0 +1,519 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SafetyPolicy lists what generated test code may not do. Generated tests
+// are untrusted: a prompt-injected source file could get the model to emit
+// tests that run commands, reach the network or read the bot's secrets.
+type SafetyPolicy struct {
+	DeniedImports  []string      // import paths; a "/..." suffix covers a whole tree
+	AllowedImports []string      // exceptions to DeniedImports
+	DeniedCalls    []string      // functions as importpath.Name, e.g. os.Getenv
+	AllowedCalls   []string      // exceptions to DeniedCalls
+	MaxSleep       time.Duration // longest constant time.Sleep allowed; zero means no limit
+}
+
+// DefaultSafetyPolicy denies process execution, network and system access,
+// reading the environment, reading files outside the package directory and
+// t.TempDir, and deleting files outside t.TempDir. Of the network packages
+// only net/http and its httptest servers are allowed, without the client
+// and server functions that reach beyond them.
+func DefaultSafetyPolicy() SafetyPolicy {
+	return SafetyPolicy{
+		DeniedImports:  []string{"os/exec", "net/...", "syscall", "unsafe", "plugin", "golang.org/x/sys/..."},
+		AllowedImports: []string{"net/http", "net/http/httptest", "net/url"},
+		DeniedCalls: []string{
+			"os.Getenv", "os.LookupEnv", "os.Environ", "os.ExpandEnv",
+			"os.Remove", "os.RemoveAll",
+			"os.ReadFile", "os.Open", "os.OpenFile", "os.ReadDir", "os.DirFS",
+			"io/ioutil.ReadFile", "io/ioutil.ReadDir",
+			"os.StartProcess", "os.Exit",
+			"net/http.Get", "net/http.Head", "net/http.Post", "net/http.PostForm",
+			"net/http.DefaultClient", "net/http.DefaultTransport", "net/http.Client", "net/http.Transport",
+			"net/http.ListenAndServe", "net/http.ListenAndServeTLS", "net/http.Serve", "net/http.ServeTLS",
+		},
+		MaxSleep: time.Second,
+	}
+}
+
+// Violation is a single breach of the SafetyPolicy.
+type Violation struct {
+	Function string `json:"function,omitempty"` // enclosing top-level function, empty at file level
+	Line     int    `json:"line"`
+	Rule     string `json:"rule"` // import, call or sleep
+	Detail   string `json:"detail"`
+}
+
+func (v Violation) String() string {
+	if v.Function == "" {
+		return fmt.Sprintf("line %d: %s", v.Line, v.Detail)
+	}
+	return fmt.Sprintf("%s (line %d): %s", v.Function, v.Line, v.Detail)
+}
+
+// pathCalls take a path as first argument and are allowed on paths inside
+// t.TempDir even when denied. The ones that only read are also allowed on
+// relative paths inside the package directory, such as testdata files.
+var pathCalls = map[string]bool{
+	"os.Remove": true, "os.RemoveAll": true,
+	"os.ReadFile": false, "os.Open": false, "os.OpenFile": false, "os.ReadDir": false, "os.DirFS": false,
+	"io/ioutil.ReadFile": false, "io/ioutil.ReadDir": false,
+}
+
+// Check parses src and returns every violation of the policy in it.
+func (p SafetyPolicy) Check(src string) ([]Violation, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "generated_test.go", src, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated code: %v", err)
+	}
+
+	var violations []Violation
+	imports := make(map[string]string) // local name to import path
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name, _ := importName(spec, importPath)
+		imports[name] = importPath
+		switch {
+		case p.importDenied(importPath):
+			violations = append(violations, Violation{
+				Line:   fset.Position(spec.Pos()).Line,
+				Rule:   "import",
+				Detail: fmt.Sprintf("import of %q is not allowed", importPath),
+			})
+		case spec.Name != nil && spec.Name.Name == "." && p.packageHasDeniedCalls(importPath):
+			// Unqualified names cannot be told apart from local ones
+			violations = append(violations, Violation{
+				Line:   fset.Position(spec.Pos()).Line,
+				Rule:   "import",
+				Detail: fmt.Sprintf("dot import of %q, which has denied functions, is not allowed", importPath),
+			})
+		}
+	}
+
+	for _, decl := range file.Decls {
+		function := ""
+		tParams := make(map[*ast.Object]bool)
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			if fn.Recv == nil {
+				function = fn.Name.Name
+			}
+			if fn.Body != nil {
+				tNames := testingParams(fn)
+				for _, field := range fn.Type.Params.List {
+					for _, name := range field.Names {
+						if tNames[name.Name] && name.Obj != nil {
+							tParams[name.Obj] = true
+						}
+					}
+				}
+			}
+		}
+		paths := pathChecker{imports: imports, tParams: tParams}
+		paths.temp = tempDirVariables(decl, paths)
+
+		// Denied functions are caught wherever they are named, so that
+		// method values such as rm := os.RemoveAll cannot hide a call
+		var inspect func(n ast.Node) bool
+		inspect = func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				name := selectedFunction(n.Fun, imports)
+				switch {
+				case name == "time.Sleep" && p.MaxSleep > 0 && len(n.Args) == 1:
+					if d, ok := constantDuration(n.Args[0], imports); ok && d > p.MaxSleep {
+						violations = append(violations, Violation{
+							Function: function,
+							Line:     fset.Position(n.Pos()).Line,
+							Rule:     "sleep",
+							Detail:   fmt.Sprintf("time.Sleep(%v) exceeds the %v limit", d, p.MaxSleep),
+						})
+					}
+				case len(n.Args) > 0 && paths.allowed(name, n.Args[0]):
+					// Reading test data and cleaning up inside t.TempDir is harmless
+					for _, arg := range n.Args {
+						ast.Inspect(arg, inspect)
+					}
+					return false
+				case name != "" && p.callDenied(name):
+					violations = append(violations, Violation{
+						Function: function,
+						Line:     fset.Position(n.Pos()).Line,
+						Rule:     "call",
+						Detail:   fmt.Sprintf("call to %s is not allowed", name),
+					})
+					for _, arg := range n.Args {
+						ast.Inspect(arg, inspect)
+					}
+					return false
+				}
+			case *ast.SelectorExpr:
+				if name := selectedFunction(n, imports); name != "" && p.callDenied(name) {
+					violations = append(violations, Violation{
+						Function: function,
+						Line:     fset.Position(n.Pos()).Line,
+						Rule:     "call",
+						Detail:   fmt.Sprintf("use of %s is not allowed", name),
+					})
+				}
+			}
+			return true
+		}
+		ast.Inspect(decl, inspect)
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Line < violations[j].Line })
+	return violations, nil
+}
+
+func (p SafetyPolicy) importDenied(importPath string) bool {
+	return matchesImport(p.DeniedImports, importPath) && !matchesImport(p.AllowedImports, importPath)
+}
+
+func (p SafetyPolicy) callDenied(name string) bool {
+	return matchesCall(p.DeniedCalls, name) && !matchesCall(p.AllowedCalls, name)
+}
+
+// packageHasDeniedCalls reports whether any function of the package at
+// importPath is denied.
+func (p SafetyPolicy) packageHasDeniedCalls(importPath string) bool {
+	for _, pattern := range p.DeniedCalls {
+		slash := strings.LastIndex(pattern, "/")
+		dot := strings.LastIndex(pattern, ".")
+		if dot <= slash || pattern[:dot] != importPath {
+			continue
+		}
+		if name := pattern[dot+1:]; name == "*" || !matchesCall(p.AllowedCalls, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesImport(patterns []string, importPath string) bool {
+	for _, pattern := range patterns {
+		if tree, ok := strings.CutSuffix(pattern, "/..."); ok {
+			if importPath == tree || strings.HasPrefix(importPath, tree+"/") {
+				return true
+			}
+		} else if importPath == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesCall reports whether name matches one of patterns, where
+// "importpath.*" matches every function of the package.
+func matchesCall(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pkg, ok := strings.CutSuffix(pattern, ".*"); ok {
+			if strings.HasPrefix(name, pkg+".") {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// selectedFunction returns the package-qualified name expr selects as
+// importpath.Name, or "" for any other expression.
+func selectedFunction(expr ast.Expr, imports map[string]string) string {
+	sel, ok := ast.Unparen(expr).(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	importPath, ok := imports[pkg.Name]
+	if !ok {
+		return ""
+	}
+	return importPath + "." + sel.Sel.Name
+}
+
+// pathChecker tells paths inside t.TempDir() apart in one function, where
+// tParams are its *testing.T and testing.TB parameters and temp the local
+// variables only ever assigned such paths.
+type pathChecker struct {
+	imports map[string]string
+	tParams map[*ast.Object]bool
+	temp    map[*ast.Object]bool
+}
+
+// allowed reports whether calling the path function name on path is
+// allowed despite the policy, see pathCalls.
+func (pc pathChecker) allowed(name string, path ast.Expr) bool {
+	removes, ok := pathCalls[name]
+	if !ok {
+		return false
+	}
+	return pc.isTempPath(path) || (!removes && pc.isPackagePath(path))
+}
+
+// tempDirVariables returns the variables in decl that hold a path inside
+// t.TempDir() wherever they are used, e.g. dir := t.TempDir() and
+// path := filepath.Join(dir, "x"). A variable assigned anything else, even
+// once, or whose address is taken does not count.
+func tempDirVariables(decl ast.Decl, pc pathChecker) map[*ast.Object]bool {
+	// Every value assigned to each variable, nil when it is unknown
+	assigned := make(map[*ast.Object][]ast.Expr)
+	assign := func(lhs ast.Expr, rhs ast.Expr) {
+		if ident, ok := ast.Unparen(lhs).(*ast.Ident); ok && ident.Obj != nil && ident.Obj.Kind == ast.Var {
+			assigned[ident.Obj] = append(assigned[ident.Obj], rhs)
+		}
+	}
+	ast.Inspect(decl, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for i, lhs := range n.Lhs {
+				var rhs ast.Expr
+				if len(n.Lhs) == len(n.Rhs) && (n.Tok == token.ASSIGN || n.Tok == token.DEFINE) {
+					rhs = n.Rhs[i]
+				}
+				assign(lhs, rhs)
+			}
+		case *ast.ValueSpec:
+			for i, name := range n.Names {
+				var rhs ast.Expr
+				if len(n.Names) == len(n.Values) {
+					rhs = n.Values[i]
+				}
+				assign(name, rhs)
+			}
+		case *ast.RangeStmt:
+			assign(n.Key, nil)
+			if n.Value != nil {
+				assign(n.Value, nil)
+			}
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				assign(n.X, nil)
+			}
+		}
+		return true
+	})
+
+	// Grow the set until no more variables qualify, for chains of
+	// assignments such as path := filepath.Join(dir, "x")
+	pc.temp = make(map[*ast.Object]bool)
+	for changed := true; changed; {
+		changed = false
+		for obj, values := range assigned {
+			if pc.temp[obj] {
+				continue
+			}
+			all := true
+			for _, value := range values {
+				if value == nil || !pc.isTempPath(value) {
+					all = false
+					break
+				}
+			}
+			if all {
+				pc.temp[obj] = true
+				changed = true
+			}
+		}
+	}
+	return pc.temp
+}
+
+// isTempPath reports whether expr is a path inside t.TempDir(): the call
+// itself on one of the testing parameters, a variable in temp, or one of
+// those joined with literal elements that do not climb out with "..".
+func (pc pathChecker) isTempPath(expr ast.Expr) bool {
+	switch e := ast.Unparen(expr).(type) {
+	case *ast.Ident:
+		return e.Obj != nil && pc.temp[e.Obj]
+	case *ast.CallExpr:
+		if sel, ok := e.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "TempDir" && len(e.Args) == 0 {
+			x, ok := sel.X.(*ast.Ident)
+			return ok && x.Obj != nil && pc.tParams[x.Obj]
+		}
+		if !pc.isJoin(e) || !pc.isTempPath(e.Args[0]) {
+			return false
+		}
+		for _, arg := range e.Args[1:] {
+			if !isSafePathElement(arg) {
+				return false
+			}
+		}
+		return true
+	case *ast.BinaryExpr:
+		return e.Op == token.ADD && pc.isTempPath(e.X) && isSafePathElement(e.Y)
+	}
+	return false
+}
+
+// isPackagePath reports whether expr is a relative path inside the package
+// directory: literal elements, possibly joined, that neither start at the
+// root nor climb out with "..".
+func (pc pathChecker) isPackagePath(expr ast.Expr) bool {
+	var elements []ast.Expr
+	switch e := ast.Unparen(expr).(type) {
+	case *ast.BasicLit:
+		elements = []ast.Expr{e}
+	case *ast.CallExpr:
+		if !pc.isJoin(e) {
+			return false
+		}
+		elements = e.Args
+	default:
+		return false
+	}
+	for _, element := range elements {
+		if !isSafePathElement(element) {
+			return false
+		}
+	}
+	value, _ := strconv.Unquote(ast.Unparen(elements[0]).(*ast.BasicLit).Value)
+	return value != "" && !strings.HasPrefix(value, "/") && !strings.HasPrefix(value, `\`) && !strings.Contains(value, ":")
+}
+
+// isJoin reports whether call is filepath.Join or path.Join with at least
+// one argument.
+func (pc pathChecker) isJoin(call *ast.CallExpr) bool {
+	name := selectedFunction(call.Fun, pc.imports)
+	return (name == "path/filepath.Join" || name == "path.Join") && len(call.Args) > 0
+}
+
+// isSafePathElement reports whether expr is a string literal without a
+// ".." path segment.
+func isSafePathElement(expr ast.Expr) bool {
+	lit, ok := ast.Unparen(expr).(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return false
+	}
+	value, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return false
+	}
+	for _, segment := range strings.FieldsFunc(value, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// constantDuration evaluates expr when it is a constant duration built from
+// literals and the time unit constants, e.g. 2 * time.Second.
+func constantDuration(expr ast.Expr, imports map[string]string) (time.Duration, bool) {
+	units := map[string]time.Duration{
+		"Nanosecond": time.Nanosecond, "Microsecond": time.Microsecond, "Millisecond": time.Millisecond,
+		"Second": time.Second, "Minute": time.Minute, "Hour": time.Hour,
+	}
+
+	var eval func(ast.Expr) (constant.Value, bool)
+	eval = func(expr ast.Expr) (constant.Value, bool) {
+		switch e := expr.(type) {
+		case *ast.BasicLit:
+			if e.Kind != token.INT && e.Kind != token.FLOAT {
+				return nil, false
+			}
+			return constant.MakeFromLiteral(e.Value, e.Kind, 0), true
+		case *ast.ParenExpr:
+			return eval(e.X)
+		case *ast.SelectorExpr:
+			pkg, ok := e.X.(*ast.Ident)
+			if !ok || imports[pkg.Name] != "time" {
+				return nil, false
+			}
+			unit, ok := units[e.Sel.Name]
+			return constant.MakeInt64(int64(unit)), ok
+		case *ast.CallExpr:
+			// time.Duration(n) conversions
+			if sel, ok := e.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Duration" && len(e.Args) == 1 {
+				if pkg, ok := sel.X.(*ast.Ident); ok && imports[pkg.Name] == "time" {
+					return eval(e.Args[0])
+				}
+			}
+		case *ast.BinaryExpr:
+			x, ok := eval(e.X)
+			if !ok {
+				return nil, false
+			}
+			y, ok := eval(e.Y)
+			if !ok {
+				return nil, false
+			}
+			switch e.Op {
+			case token.ADD, token.SUB, token.MUL:
+				return constant.BinaryOp(x, e.Op, y), true
+			case token.QUO:
+				if constant.Sign(y) == 0 {
+					return nil, false
+				}
+				return constant.BinaryOp(x, e.Op, y), true
+			}
+		}
+		return nil, false
+	}
+
+	value, ok := eval(expr)
+	if !ok {
+		return 0, false
+	}
+	if f, ok := constant.Float64Val(constant.ToFloat(value)); ok {
+		return time.Duration(f), true
+	}
+	return 0, false
+}
+
+// ParseList splits a comma-separated flag value into its trimmed,
+// non-empty entries.
+func ParseList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// enforceSafetyPolicy removes the test functions in content that violate the
+// safety policy. A violation outside any test function, such as a denied
+// import, makes the whole file suspect and is returned as an error.
+func (tg *TestGenerator) enforceSafetyPolicy(content string, postProcessor PostProcessor) (string, []Violation, []DroppedTest, error) {
+	violations, err := tg.options.Safety.Check(content)
+	if err != nil || len(violations) == 0 {
+		return content, nil, nil, err
+	}
+
+	drop := make(map[string]bool)
+	var dropped []DroppedTest
+	for _, v := range violations {
+		if v.Function == "" {
+			return "", violations, nil, fmt.Errorf("generated code violates the safety policy: %s", v)
+		}
+		if !drop[v.Function] {
+			drop[v.Function] = true
+			dropped = append(dropped, DroppedTest{Name: v.Function, Reason: "violates the safety policy: " + v.Detail})
+		}
+	}
+
+	content, err = postProcessor.removeDeclarations(content, drop)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return content, violations, dropped, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"go/parser"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSafetyPolicy_Check(t *testing.T) {
+	src := `package calc
+
+import (
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCleanup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out")
+	os.RemoveAll(path)
+	os.Remove(t.TempDir())
+	time.Sleep(10 * time.Millisecond)
+	_ = httptest.NewServer(nil)
+}
+
+func TestSecrets(t *testing.T) {
+	_ = os.Getenv("GITHUB_TOKEN")
+	os.RemoveAll("/")
+	time.Sleep(2 * time.Minute)
+	exec.Command("sh").Run()
+}
+`
+	violations, err := DefaultSafetyPolicy().Check(src)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.String())
+	}
+	want := []string{
+		`line 6: import of "os/exec" is not allowed`,
+		"TestSecrets (line 22): call to os.Getenv is not allowed",
+		"TestSecrets (line 23): call to os.RemoveAll is not allowed",
+		"TestSecrets (line 24): time.Sleep(2m0s) exceeds the 1s limit",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Check() violations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSafetyPolicy_Overrides(t *testing.T) {
+	src := `package calc
+
+import (
+	"net"
+	sys "golang.org/x/sys/unix"
+	"os"
+	"testing"
+)
+
+func TestOverrides(t *testing.T) {
+	_, _ = net.Dial("tcp", "example.com:80")
+	_ = os.Getenv("HOME")
+	_ = sys.Getpid()
+}
+`
+	policy := DefaultSafetyPolicy()
+	policy.AllowedImports = []string{"net"}
+	policy.AllowedCalls = []string{"os.Getenv"}
+	policy.DeniedCalls = append(policy.DeniedCalls, "net.*")
+
+	violations, err := policy.Check(src)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(violations) != 2 || violations[0].Rule != "import" || violations[1].Detail != "call to net.Dial is not allowed" {
+		t.Errorf("Check() = %v, want the x/sys import and the net.Dial call", violations)
+	}
+}
+
+func TestSafetyPolicy_Bypasses(t *testing.T) {
+	src := `package calc
+
+import (
+	. "os"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEscapes(t *testing.T) {
+	dir := t.TempDir()
+	os.RemoveAll(os.TempDir())
+	os.RemoveAll(filepath.Join(dir, "..", ".."))
+	os.Remove(dir + "/../x")
+	os.Remove(filepath.Join(dir, name()))
+	os.Remove(filepath.Join(dir, "sub/out.txt"))
+}
+
+func TestMethodValues(t *testing.T) {
+	rm := os.RemoveAll
+	rm("/")
+	f := os.Getenv
+	_ = f("GITHUB_TOKEN")
+	_ = (os.Getenv)("HOME")
+}
+
+func name() string { return ".." }
+`
+	violations, err := DefaultSafetyPolicy().Check(src)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.String())
+	}
+	want := []string{
+		`line 4: dot import of "os", which has denied functions, is not allowed`,
+		"TestEscapes (line 12): call to os.RemoveAll is not allowed",
+		"TestEscapes (line 13): call to os.RemoveAll is not allowed",
+		"TestEscapes (line 14): call to os.Remove is not allowed",
+		"TestEscapes (line 15): call to os.Remove is not allowed",
+		"TestMethodValues (line 20): use of os.RemoveAll is not allowed",
+		"TestMethodValues (line 22): use of os.Getenv is not allowed",
+		"TestMethodValues (line 24): call to os.Getenv is not allowed",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Check() violations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A dot import is fine once every denied function of the package is allowed
+	policy := SafetyPolicy{DeniedCalls: []string{"os.Getenv"}, AllowedCalls: []string{"os.Getenv"}}
+	if violations, err := policy.Check("package calc\n\nimport . \"os\"\n"); err != nil || len(violations) != 0 {
+		t.Errorf("Check() = %v, %v, want no violations", violations, err)
+	}
+}
+
+func TestSafetyPolicy_PathsAndNetwork(t *testing.T) {
+	src := `package calc
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReassigned(t *testing.T) {
+	dir := t.TempDir()
+	dir = "/home/runner"
+	os.RemoveAll(dir)
+	_, _ = os.ReadFile("/proc/self/environ")
+	_, _ = http.Get("https://example.com")
+}
+
+func TestShadowed(t *testing.T) {
+	out := t.TempDir()
+	if true {
+		out := "/"
+		os.RemoveAll(out)
+	}
+	os.RemoveAll(out)
+	p := &out
+	_ = p
+}
+
+func TestAllowedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+	_, _ = os.ReadFile(path)
+	_, _ = os.ReadFile("testdata/input.json")
+	f, _ := os.Open(filepath.Join("testdata", "input.json"))
+	_, _ = io.ReadAll(f)
+	os.Remove(path)
+}
+
+func TestDeniedFiles(t *testing.T, name string) {
+	_, _ = os.ReadFile(name)
+	_, _ = os.Open(filepath.Join("testdata", "..", "..", "secret"))
+	_, _ = os.ReadDir("/proc/1")
+	read := os.ReadFile
+	_, _ = read("/proc/self/cmdline")
+}
+
+func TestServer(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	_, _ = http.DefaultClient.Get(srv.URL)
+	_ = rpc.ErrShutdown
+}
+`
+	violations, err := DefaultSafetyPolicy().Check(src)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.String())
+	}
+	want := []string{
+		`line 7: import of "net/rpc" is not allowed`,
+		"TestReassigned (line 16): call to os.RemoveAll is not allowed",
+		"TestReassigned (line 17): call to os.ReadFile is not allowed",
+		"TestReassigned (line 18): call to net/http.Get is not allowed",
+		"TestShadowed (line 25): call to os.RemoveAll is not allowed",
+		"TestShadowed (line 27): call to os.RemoveAll is not allowed",
+		"TestDeniedFiles (line 43): call to os.ReadFile is not allowed",
+		"TestDeniedFiles (line 44): call to os.Open is not allowed",
+		"TestDeniedFiles (line 45): call to os.ReadDir is not allowed",
+		"TestDeniedFiles (line 46): use of os.ReadFile is not allowed",
+		"TestServer (line 57): use of net/http.DefaultClient is not allowed",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Check() violations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestConstantDuration(t *testing.T) {
+	imports := map[string]string{"time": "time"}
+	tests := []struct {
+		expr string
+		want time.Duration
+		ok   bool
+	}{
+		{"time.Second", time.Second, true},
+		{"3 * time.Minute", 3 * time.Minute, true},
+		{"(time.Hour + 30*time.Minute) / 2", 45 * time.Minute, true},
+		{"time.Duration(1.5 * float64(time.Second))", 0, false},
+		{"time.Duration(500) * time.Millisecond", 500 * time.Millisecond, true},
+		{"d", 0, false},
+	}
+	for _, tt := range tests {
+		expr, err := parser.ParseExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.expr, err)
+		}
+		got, ok := constantDuration(expr, imports)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("constantDuration(%s) = %v, %v, want %v, %v", tt.expr, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var (
+	namespacesOnce      sync.Once
+	namespacesAvailable bool
+)
+
+// isolatedCommand runs name in its own process group, killed as a whole on
+// cancellation, and in new user, network, PID and mount namespaces when the
+// kernel allows unprivileged ones. Inside, only a loopback interface exists,
+// /proc shows no process of the bot and the readOnly directories cannot be
+// written to.
+func isolatedCommand(ctx context.Context, readOnly []string, name string, args ...string) *exec.Cmd {
+	namespacesOnce.Do(func() {
+		probe := namespacedCommand(context.Background(), readOnly, name, "version")
+		namespacesAvailable = probe != nil && probe.Run() == nil
+	})
+
+	var cmd *exec.Cmd
+	if namespacesAvailable {
+		cmd = namespacedCommand(ctx, readOnly, name, args...)
+	} else {
+		cmd = exec.CommandContext(ctx, name, args...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd
+}
+
+// sandboxIsolated reports whether sandboxed commands run in namespaces,
+// once one has been created.
+func sandboxIsolated() bool {
+	return namespacesAvailable
+}
+
+// namespacedCommand wraps name in a shell that sets up the namespaces
+// before running it: a private mount table with a fresh /proc and the
+// readOnly directories remounted read-only, and the loopback interface up
+// so tests using httptest still work. It returns nil without a shell.
+func namespacedCommand(ctx context.Context, readOnly []string, name string, args ...string) *exec.Cmd {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return nil
+	}
+	script := []string{
+		"set -e",
+		"mount --make-rprivate /",
+		"mount -t proc proc /proc",
+	}
+	for _, dir := range readOnly {
+		if dir == "" {
+			continue
+		}
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		script = append(script, "mount --bind "+shellQuote(dir)+" "+shellQuote(dir), "mount -o remount,bind,ro "+shellQuote(dir))
+	}
+	if ip, err := exec.LookPath("ip"); err == nil {
+		script = append(script, shellQuote(ip)+" link set lo up 2>/dev/null || true")
+	}
+	// Not exec'd: the shell stays PID 1 and reaps orphaned processes
+	script = append(script, `"$@"`)
+
+	cmd := exec.CommandContext(ctx, sh, append([]string{"-c", strings.Join(script, "\n"), "sh", name}, args...)...)
+	cmd.SysProcAttr = namespaceAttr()
+	return cmd
+}
+
+// namespaceAttr maps the current user to root in a new user namespace,
+// which grants the rights to configure the new network, PID and mount
+// namespaces.
+func namespaceAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+}
+
+// shellQuote quotes s as a single sh word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
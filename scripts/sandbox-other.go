@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// isolatedCommand runs name without namespaces; outside Linux the sandbox
+// relies on the scrubbed environment, its own build cache and the
+// blackhole proxy alone, and readOnly directories stay writable.
+func isolatedCommand(ctx context.Context, readOnly []string, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
+
+// sandboxIsolated reports whether sandboxed commands run in namespaces,
+// which they never do outside Linux.
+func sandboxIsolated() bool {
+	return false
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+)
+
+// defaultMaxOutput caps the go test output kept from a sandboxed run.
+const defaultMaxOutput = 1 << 20
+
+// blackholeProxy is an address nothing listens on; routing HTTP through it
+// makes network access fail fast when namespaces are not available.
+const blackholeProxy = "http://127.0.0.1:9"
+
+// passthroughEnv lists the only variables of the bot's environment a
+// sandboxed run sees. Tokens and other secrets are never among them.
+var passthroughEnv = []string{"PATH", "CC", "CXX", "CGO_ENABLED", "GOARCH", "GOOS", "GOAMD64"}
+
+// readSecret returns the secret held in the file at path and deletes the
+// file, so that it is gone by the time generated tests run. Without a path
+// the secret is taken from the environment variable name instead, which is
+// then unset; sandboxed runs never inherit it, but only namespaces keep
+// them from reading the bot's initial environment in /proc.
+func readSecret(path, name string) (string, error) {
+	if path == "" {
+		value := os.Getenv(name)
+		os.Unsetenv(name)
+		return value, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("failed to delete %s after reading it: %v", path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Sandbox is a throwaway copy of a module in which untrusted generated
+// tests are run, with a scrubbed environment and a build cache of their
+// own. Where Linux namespaces are available they also get no network, no
+// view of the bot's processes, so neither its environment nor its command
+// line, and read-only access to the checkout, the toolchain and the bot's
+// caches. The bot reads its credentials from files it removes at startup,
+// see readSecret, so they are not left on disk either.
+type Sandbox struct {
+	root       string // temporary directory holding everything below
+	moduleRoot string // the module being copied
+	moduleDir  string // copy of the module
+	packageDir string // copy of the package under test
+}
+
+// NewSandbox copies the module containing packageDir into a temporary
+// directory. The caller must Close the sandbox.
+func NewSandbox(packageDir string) (*Sandbox, error) {
+	moduleRoot, err := findModuleRoot(packageDir)
+	if err != nil {
+		return nil, err
+	}
+	absPackageDir, err := filepath.Abs(packageDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	rel, err := filepath.Rel(moduleRoot, absPackageDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package path relative to module root: %v", err)
+	}
+
+	root, err := os.MkdirTemp("", "autotest-sandbox-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox: %v", err)
+	}
+	sb := &Sandbox{
+		root:       root,
+		moduleRoot: moduleRoot,
+		moduleDir:  filepath.Join(root, "module"),
+		packageDir: filepath.Join(root, "module", rel),
+	}
+	for _, dir := range []string{sb.home(), sb.tmp()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			sb.Close()
+			return nil, fmt.Errorf("failed to create sandbox: %v", err)
+		}
+	}
+
+	if err := copyModule(moduleRoot, sb.moduleDir); err != nil {
+		sb.Close()
+		return nil, err
+	}
+	if err := absolutizeReplaces(filepath.Join(sb.moduleDir, "go.mod"), moduleRoot); err != nil {
+		sb.Close()
+		return nil, err
+	}
+	if err := addWorkspaceReplaces(sb.moduleDir, moduleRoot); err != nil {
+		sb.Close()
+		return nil, err
+	}
+
+	return sb, nil
+}
+
+// Close removes the sandbox and everything the tests wrote into it.
+func (sb *Sandbox) Close() error {
+	return os.RemoveAll(sb.root)
+}
+
+func (sb *Sandbox) home() string { return filepath.Join(sb.root, "home") }
+func (sb *Sandbox) tmp() string  { return filepath.Join(sb.root, "tmp") }
+
+// Command returns a go command running in the sandboxed package with a
+// scrubbed environment and, where the platform allows it, in namespaces
+// isolating it from the network and the bot. It is killed when ctx is done.
+func (sb *Sandbox) Command(ctx context.Context, args ...string) (*exec.Cmd, error) {
+	goEnv, err := hostGoEnv()
+	if err != nil {
+		return nil, err
+	}
+	cache, err := sandboxGoCache()
+	if err != nil {
+		return nil, err
+	}
+
+	env := []string{
+		"HOME=" + sb.home(),
+		"TMPDIR=" + sb.tmp(),
+		"GOROOT=" + goEnv.GOROOT,
+		"GOPATH=" + goEnv.GOPATH,
+		"GOCACHE=" + cache,
+		"GOMODCACHE=" + goEnv.GOMODCACHE,
+		"GOFLAGS=-mod=readonly",
+		"GOPROXY=off",
+		"GOTOOLCHAIN=local",
+		"GOWORK=off",
+		"HTTP_PROXY=" + blackholeProxy,
+		"HTTPS_PROXY=" + blackholeProxy,
+		"http_proxy=" + blackholeProxy,
+		"https_proxy=" + blackholeProxy,
+		"NO_PROXY=",
+		"no_proxy=",
+	}
+	for _, name := range passthroughEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+
+	readOnly := []string{sb.moduleRoot, goEnv.GOROOT, goEnv.GOCACHE, goEnv.GOMODCACHE}
+	cmd := isolatedCommand(ctx, readOnly, filepath.Join(goEnv.GOROOT, "bin", "go"), args...)
+	cmd.Dir = sb.packageDir
+	cmd.Env = env
+	return cmd, nil
+}
+
+// goEnv holds the go environment of the bot, resolved once so sandboxed
+// runs share its toolchain and module cache.
+type goEnv struct {
+	GOROOT     string
+	GOPATH     string
+	GOCACHE    string
+	GOMODCACHE string
+}
+
+var (
+	hostGoEnvOnce   sync.Once
+	hostGoEnvResult goEnv
+	hostGoEnvErr    error
+)
+
+func hostGoEnv() (goEnv, error) {
+	hostGoEnvOnce.Do(func() {
+		output, err := exec.Command("go", "env", "-json", "GOROOT", "GOPATH", "GOCACHE", "GOMODCACHE").Output()
+		if err != nil {
+			hostGoEnvErr = fmt.Errorf("failed to run go env: %v", err)
+			return
+		}
+		if err := json.Unmarshal(output, &hostGoEnvResult); err != nil {
+			hostGoEnvErr = fmt.Errorf("failed to parse go env: %v", err)
+		}
+	})
+	return hostGoEnvResult, hostGoEnvErr
+}
+
+var (
+	sandboxGoCacheOnce sync.Once
+	sandboxGoCacheDir  string
+	sandboxGoCacheErr  error
+)
+
+// sandboxGoCache returns the build cache of sandboxed runs, created once
+// per process apart from the bot's own so that a generated test cannot
+// poison the builds of the bot or of later jobs; RemoveSandboxGoCache
+// deletes it.
+func sandboxGoCache() (string, error) {
+	sandboxGoCacheOnce.Do(func() {
+		sandboxGoCacheDir, sandboxGoCacheErr = os.MkdirTemp("", "autotest-gocache-*")
+		if sandboxGoCacheErr != nil {
+			sandboxGoCacheErr = fmt.Errorf("failed to create sandbox build cache: %v", sandboxGoCacheErr)
+		}
+	})
+	return sandboxGoCacheDir, sandboxGoCacheErr
+}
+
+// RemoveSandboxGoCache deletes the build cache of sandboxed runs, if one
+// was created.
+func RemoveSandboxGoCache() error {
+	if sandboxGoCacheDir == "" {
+		return nil
+	}
+	return os.RemoveAll(sandboxGoCacheDir)
+}
+
+// copyModule copies the module at src to dst, leaving out version control
+// data and nested modules.
+func copyModule(src, dst string) error {
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if rel != "." {
+				if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+					return filepath.SkipDir
+				}
+			}
+			return os.MkdirAll(target, 0755)
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy module into sandbox: %v", err)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// absolutizeReplaces rewrites replace directives pointing at local
+// directories relative to the original module root, since the copy lives
+// elsewhere.
+func absolutizeReplaces(goModPath, moduleRoot string) error {
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return fmt.Errorf("failed to read go.mod: %v", err)
+	}
+	file, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		return fmt.Errorf("failed to parse go.mod: %v", err)
+	}
+
+	changed := false
+	for _, replace := range file.Replace {
+		if replace.New.Version != "" || filepath.IsAbs(replace.New.Path) {
+			continue
+		}
+		newPath := filepath.Join(moduleRoot, replace.New.Path)
+		if err := file.AddReplace(replace.Old.Path, replace.Old.Version, newPath, ""); err != nil {
+			return fmt.Errorf("failed to rewrite replace of %s: %v", replace.Old.Path, err)
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	formatted, err := file.Format()
+	if err != nil {
+		return fmt.Errorf("failed to format go.mod: %v", err)
+	}
+	return os.WriteFile(goModPath, formatted, 0644)
+}
+
+// addWorkspaceReplaces makes the copy in moduleDir of a module used by a
+// go.work workspace build as the module does in place, since sandboxed runs
+// have GOWORK=off: the other modules of the workspace are required and
+// replaced by their directories, the workspace's own replace directives
+// are added, and the go.sum entries of the workspace are merged in.
+func addWorkspaceReplaces(moduleDir, moduleRoot string) error {
+	ws, err := findWorkspace(moduleRoot)
+	if err != nil {
+		return err
+	}
+	if ws == nil || !ws.Uses(moduleRoot) {
+		return nil
+	}
+
+	goModPath := filepath.Join(moduleDir, "go.mod")
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return fmt.Errorf("failed to read go.mod: %v", err)
+	}
+	file, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		return fmt.Errorf("failed to parse go.mod: %v", err)
+	}
+	required := make(map[string]bool)
+	for _, require := range file.Require {
+		required[require.Mod.Path] = true
+	}
+
+	sums := []string{filepath.Join(ws.Dir, "go.work.sum")}
+	for _, root := range ws.Modules {
+		if root == moduleRoot {
+			continue
+		}
+		modulePath, err := readModulePath(root)
+		if err != nil {
+			return err
+		}
+		// Workspace modules take precedence over any version required
+		if !required[modulePath] {
+			if err := file.AddRequire(modulePath, "v0.0.0-00010101000000-000000000000"); err != nil {
+				return fmt.Errorf("failed to require workspace module %s: %v", modulePath, err)
+			}
+		}
+		if err := file.AddReplace(modulePath, "", root, ""); err != nil {
+			return fmt.Errorf("failed to replace workspace module %s: %v", modulePath, err)
+		}
+		sums = append(sums, filepath.Join(root, "go.sum"))
+	}
+	for _, replace := range ws.file.Replace {
+		newPath := replace.New.Path
+		if replace.New.Version == "" && !filepath.IsAbs(newPath) {
+			newPath = filepath.Join(ws.Dir, newPath)
+		}
+		if err := file.AddReplace(replace.Old.Path, replace.Old.Version, newPath, replace.New.Version); err != nil {
+			return fmt.Errorf("failed to add workspace replace of %s: %v", replace.Old.Path, err)
+		}
+	}
+
+	formatted, err := file.Format()
+	if err != nil {
+		return fmt.Errorf("failed to format go.mod: %v", err)
+	}
+	if err := os.WriteFile(goModPath, formatted, 0644); err != nil {
+		return fmt.Errorf("failed to write go.mod: %v", err)
+	}
+	return mergeGoSums(filepath.Join(moduleDir, "go.sum"), sums)
+}
+
+// mergeGoSums appends the lines of the go.sum files in sums missing from
+// the one at path. Files that do not exist are skipped.
+func mergeGoSums(path string, sums []string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read go.sum: %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, line := range bytes.Split(existing, []byte("\n")) {
+		seen[string(line)] = true
+	}
+
+	merged := existing
+	if len(merged) > 0 && merged[len(merged)-1] != '\n' {
+		merged = append(merged, '\n')
+	}
+	for _, sum := range sums {
+		data, err := os.ReadFile(sum)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", sum, err)
+		}
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 || seen[string(line)] {
+				continue
+			}
+			seen[string(line)] = true
+			merged = append(append(merged, line...), '\n')
+		}
+	}
+	if len(merged) == len(existing) {
+		return nil
+	}
+	return os.WriteFile(path, merged, 0644)
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a test printing in a loop cannot exhaust memory.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	if room := lb.limit - lb.buf.Len(); room < len(p) {
+		lb.truncated = true
+		if room > 0 {
+			lb.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return lb.buf.Write(p)
+}
+
+func (lb *limitedBuffer) String() string {
+	if lb.truncated {
+		return lb.buf.String() + fmt.Sprintf("\n... output truncated after %d bytes", lb.limit)
+	}
+	return lb.buf.String()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	RemoveSandboxGoCache()
+	os.Exit(code)
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	lb := &limitedBuffer{limit: 8}
+	for _, chunk := range []string{"abc", "defgh", "ijk"} {
+		if n, err := lb.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+
+	if got := lb.String(); !strings.HasPrefix(got, "abcdefgh\n") || !strings.Contains(got, "truncated after 8 bytes") {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestAbsolutizeReplaces(t *testing.T) {
+	dir := t.TempDir()
+	goMod := filepath.Join(dir, "go.mod")
+	data := `module example.com/app
+
+go 1.22
+
+replace example.com/lib => ../lib
+
+replace example.com/remote => example.com/fork v1.2.0
+`
+	if err := os.WriteFile(goMod, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := absolutizeReplaces(goMod, "/src/app"); err != nil {
+		t.Fatalf("absolutizeReplaces returned error: %v", err)
+	}
+
+	got, err := os.ReadFile(goMod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "example.com/lib => "+filepath.Join("/src", "lib")) {
+		t.Errorf("local replace not made absolute:\n%s", got)
+	}
+	if !strings.Contains(string(got), "example.com/remote => example.com/fork v1.2.0") {
+		t.Errorf("module replace was changed:\n%s", got)
+	}
+}
+
+func TestSandboxWorkspace(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"go.work":        "go 1.22\n\nuse (\n\t./app\n\t./lib\n)\n",
+		"app/go.mod":     "module example.com/app\n\ngo 1.22\n",
+		"app/app.go":     "package app\n\nimport \"example.com/lib\"\n\nfunc Double(n int) int { return lib.Add(n, n) }\n",
+		"lib/go.mod":     "module example.com/lib\n\ngo 1.22\n",
+		"lib/lib.go":     "package lib\n\nfunc Add(a, b int) int { return a + b }\n",
+		"outside/go.mod": "module example.com/outside\n\ngo 1.22\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("GOWORK", "")
+
+	if env := commandEnv(filepath.Join(root, "app"), BuildContext{}); env != nil {
+		t.Errorf("commandEnv() for a workspace module = %v, want nil", env)
+	}
+	if env := commandEnv(filepath.Join(root, "outside"), BuildContext{}); len(env) == 0 || env[len(env)-1] != "GOWORK=off" {
+		t.Errorf("commandEnv() outside the workspace does not turn it off")
+	}
+
+	sb, err := NewSandbox(filepath.Join(root, "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Close()
+	cmd, err := sb.Command(context.Background(), "build", ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("workspace module does not build in the sandbox: %v\n%s", err, output)
+	}
+}
+
+func TestRunnerPublishedFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":       "module example.com/calc\n\ngo 1.22\n",
+		"calc.go":      "package calc\n\nfunc Add(a, b int) int { return a + b }\n",
+		"calc_test.go": "package calc\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {}\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runner := TestRunner{
+		TestFile: "calc_gen_test.go",
+		Helpers:  []HelperFile{{Name: "calc_gen_fakes_test.go", Content: "package calc\n\nfunc helper() int { return 1 }\n"}},
+	}
+	content := "package calc\n\nimport \"testing\"\n\nfunc TestAdd_generated(t *testing.T) { _ = helper() }\n"
+	output, err := runner.Run(context.Background(), dir, content, "-v", "-count=1")
+	if err != nil {
+		t.Fatalf("Run returned error: %v\n%s", err, output)
+	}
+	for _, test := range []string{"TestAdd ", "TestAdd_generated"} {
+		if !strings.Contains(output, "--- PASS: "+test) {
+			t.Errorf("output does not show %s passing:\n%s", test, output)
+		}
+	}
+
+	// A test named like an existing one does not compile next to it
+	if _, err := runner.Run(context.Background(), dir, "package calc\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {}\n", "-run=^$"); err == nil {
+		t.Error("Run accepted a test redeclaring an existing one")
+	}
+
+	runner.TestFile = "../escape_test.go"
+	if _, err := runner.Run(context.Background(), dir, content); err == nil {
+		t.Error("Run accepted a test file name with a directory")
+	}
+}
+
+func TestSandboxIsolation(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "secret-token")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", os.Getpid()))
+	if err != nil {
+		t.Skipf("no /proc: %v", err)
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":  "module example.com/calc\n\ngo 1.22\n",
+		"calc.go": "package calc\n\nfunc Add(a, b int) int { return a + b }\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := fmt.Sprintf(`package calc
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvironment(t *testing.T) {
+	for _, kv := range os.Environ() {
+		if strings.Contains(kv, "secret-token") {
+			t.Errorf("environment has %%s", kv)
+		}
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	if conn, err := net.DialTimeout("tcp", %q, 2*time.Second); err == nil {
+		conn.Close()
+		t.Error("connected to a listener of the host")
+	}
+}
+
+func TestProcesses(t *testing.T) {
+	if data, err := os.ReadFile(%q); err == nil && string(data) == %q {
+		t.Error("read the command line of the bot")
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	if err := os.WriteFile(filepath.Join(%q, "calc.go"), nil, 0644); err == nil {
+		t.Error("wrote into the checkout")
+	}
+}
+`, listener.Addr().String(), fmt.Sprintf("/proc/%d/cmdline", os.Getpid()), cmdline, dir)
+
+	runner := TestRunner{TestFile: "calc_gen_test.go"}
+	if output, err := runner.Run(context.Background(), dir, content, "-run=^TestEnvironment$", "-count=1"); err != nil {
+		t.Fatalf("environment is not scrubbed: %v\n%s", err, output)
+	}
+	if !sandboxIsolated() {
+		t.Skip("namespaces are not available")
+	}
+	if output, err := runner.Run(context.Background(), dir, content, "-count=1"); err != nil {
+		t.Fatalf("sandbox is not isolated: %v\n%s", err, output)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "calc.go")); err != nil || len(data) == 0 {
+		t.Errorf("checkout was modified: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// skipDirective excludes a function from test generation when it appears in
+// the function's doc comment.
+const skipDirective = "//autotest:skip"
+
+// Target modes select which functions are considered at all. A function
+// counts as exported if black-box tests can call it, see
+// FunctionInfo.Exported.
+const (
+	TargetsExported = "exported" // exported functions only
+	TargetsComplex  = "complex"  // plus unexported ones of at least ComplexUnexported complexity
+	TargetsAll      = "all"      // exported and unexported functions alike
+)
+
+// SelectionPolicy decides which functions of a file are targeted for test
+// generation. Targets decides whether unexported functions are in scope;
+// the other rules apply to exported and unexported functions alike.
+type SelectionPolicy struct {
+	Targets           string
+	ComplexUnexported int              // least complexity of unexported targets with TargetsComplex
+	MinStatements     int              // functions with fewer top-level statements are treated as trivial
+	MinComplexity     int              // functions below this cyclomatic complexity are skipped
+	IncludePatterns   []*regexp.Regexp // if set, the qualified name must match at least one
+	ExcludePatterns   []*regexp.Regexp // the qualified name must match none
+}
+
+// DefaultSelectionPolicy targets every non-trivial exported function and
+// the unexported ones complex enough to deserve their own tests, skipping
+// one-statement getters, setters and constructors.
+func DefaultSelectionPolicy() SelectionPolicy {
+	return SelectionPolicy{
+		Targets:           TargetsComplex,
+		ComplexUnexported: 5,
+		MinStatements:     2,
+		MinComplexity:     1,
+	}
+}
+
+// ParsePatterns compiles a comma-separated list of regular expressions
+// matched against qualified function names such as "Calculator.Add".
+func ParsePatterns(list string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, expr := range strings.Split(list, ",") {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", expr, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// Include reports whether fn should get generated tests. When it should not,
+// the returned reason explains why.
+func (p SelectionPolicy) Include(fn *ast.FuncDecl, info FunctionInfo) (bool, string) {
+	if fn.Name == nil {
+		return false, "anonymous declaration"
+	}
+
+	name := fn.Name.Name
+	if isTestingEntryPoint(fn) {
+		return false, "test, benchmark, fuzz or example function"
+	}
+	if fn.Recv == nil && (name == "init" || name == "main") {
+		return false, "program entry point"
+	}
+	if fn.Body == nil {
+		return false, "no body"
+	}
+	if hasSkipDirective(fn) {
+		return false, "marked with " + skipDirective
+	}
+
+	if !info.Exported() {
+		switch p.Targets {
+		case TargetsExported:
+			return false, "unexported, only exported functions are targeted"
+		case TargetsComplex:
+			if info.Complexity < p.ComplexUnexported {
+				return false, fmt.Sprintf("unexported with complexity %d below %d", info.Complexity, p.ComplexUnexported)
+			}
+		}
+	}
+
+	if len(fn.Body.List) < p.MinStatements {
+		return false, fmt.Sprintf("fewer than %d statements", p.MinStatements)
+	}
+	if info.Complexity < p.MinComplexity {
+		return false, fmt.Sprintf("complexity %d below %d", info.Complexity, p.MinComplexity)
+	}
+
+	qualified := info.QualifiedName()
+	for _, re := range p.ExcludePatterns {
+		if re.MatchString(qualified) {
+			return false, fmt.Sprintf("matches exclude pattern %q", re)
+		}
+	}
+	if len(p.IncludePatterns) > 0 {
+		for _, re := range p.IncludePatterns {
+			if re.MatchString(qualified) {
+				return true, ""
+			}
+		}
+		return false, "matches no include pattern"
+	}
+
+	return true, ""
+}
+
+// isTestingEntryPoint reports whether fn would be picked up by go test as a
+// Test, Benchmark, Fuzz or Example function, or is TestMain.
+func isTestingEntryPoint(fn *ast.FuncDecl) bool {
+	if fn.Recv != nil {
+		return false
+	}
+	name := fn.Name.Name
+	for _, prefix := range []string{"Test", "Benchmark", "Fuzz", "Example"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		// Same rule as go test: the prefix must be the whole name or be
+		// followed by something other than a lower-case letter.
+		rest := name[len(prefix):]
+		if rest == "" {
+			return true
+		}
+		r, _ := utf8.DecodeRuneInString(rest)
+		if !unicode.IsLower(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSkipDirective(fn *ast.FuncDecl) bool {
+	if fn.Doc == nil {
+		return false
+	}
+	for _, comment := range fn.Doc.List {
+		if comment.Text == skipDirective || strings.HasPrefix(comment.Text, skipDirective+" ") {
+			return true
+		}
+	}
+	return false
+}
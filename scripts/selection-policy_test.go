@@ -0,0 +1,206 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+const selectionSource = `package calc
+
+// Calculator does arithmetic.
+type Calculator struct{ total int }
+
+// Total is a simple getter.
+func (c *Calculator) Total() int {
+	return c.total
+}
+
+// Add adds to the total.
+func (c *Calculator) Add(n int) int {
+	c.total += n
+	return c.total
+}
+
+func clamp(n, lo, hi int) int {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
+
+// Reset is excluded on purpose.
+//
+//autotest:skip
+func (c *Calculator) Reset() {
+	c.total = 0
+	c.total = 0
+}
+
+func init() {
+	_ = 1
+	_ = 2
+}
+
+func TestHelper() {
+	_ = 1
+	_ = 2
+}
+
+func Testify() {
+	_ = 1
+	_ = 2
+}
+
+func FuzzAdd() {
+	_ = 1
+	_ = 2
+}
+
+func ExampleCalculator() {
+	_ = 1
+	_ = 2
+}
+`
+
+func parseSelectionSource(t *testing.T) (*CoverageAnalyzer, map[string]*ast.FuncDecl) {
+	t.Helper()
+
+	ca := NewCoverageAnalyzer(DefaultSelectionPolicy(), "")
+	file, err := parser.ParseFile(ca.fileSet, "calc.go", selectionSource, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+
+	decls := make(map[string]*ast.FuncDecl)
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			decls[fn.Name.Name] = fn
+		}
+	}
+	return ca, decls
+}
+
+func TestSelectionPolicy_Include(t *testing.T) {
+	ca, decls := parseSelectionSource(t)
+
+	mustPatterns := func(list string) SelectionPolicy {
+		policy := DefaultSelectionPolicy()
+		patterns, err := ParsePatterns(list)
+		if err != nil {
+			t.Fatalf("ParsePatterns(%q): %v", list, err)
+		}
+		policy.IncludePatterns = patterns
+		return policy
+	}
+
+	allTargets := DefaultSelectionPolicy()
+	allTargets.Targets = TargetsAll
+
+	complexUnexported := DefaultSelectionPolicy()
+	complexUnexported.Targets = TargetsComplex
+	complexUnexported.ComplexUnexported = 3
+
+	simpleUnexported := complexUnexported
+	simpleUnexported.ComplexUnexported = 4
+
+	exportedOnly := DefaultSelectionPolicy()
+	exportedOnly.Targets = TargetsExported
+
+	complexOnly := allTargets
+	complexOnly.MinComplexity = 2
+
+	excludeAdd := DefaultSelectionPolicy()
+	excludeAdd.ExcludePatterns, _ = ParsePatterns(`^Calculator\.Add$`)
+
+	tests := []struct {
+		name   string
+		policy SelectionPolicy
+		fn     string
+		want   bool
+	}{
+		{"exported getter is trivial", DefaultSelectionPolicy(), "Total", false},
+		{"exported method with logic", DefaultSelectionPolicy(), "Add", true},
+		{"unexported function with logic", allTargets, "clamp", true},
+		{"simple unexported not targeted by default", DefaultSelectionPolicy(), "clamp", false},
+		{"unexported not targeted in exported mode", exportedOnly, "clamp", false},
+		{"complex unexported", complexUnexported, "clamp", true},
+		{"unexported below complexity", simpleUnexported, "clamp", false},
+		{"complex mode keeps simple exported", simpleUnexported, "Add", true},
+		{"skip directive", DefaultSelectionPolicy(), "Reset", false},
+		{"init", DefaultSelectionPolicy(), "init", false},
+		{"test function", DefaultSelectionPolicy(), "TestHelper", false},
+		{"Test prefix followed by lower case", DefaultSelectionPolicy(), "Testify", true},
+		{"fuzz target", DefaultSelectionPolicy(), "FuzzAdd", false},
+		{"example", DefaultSelectionPolicy(), "ExampleCalculator", false},
+		{"below min complexity", complexOnly, "Add", false},
+		{"at min complexity", complexOnly, "clamp", true},
+		{"exclude pattern", excludeAdd, "Add", false},
+		{"include pattern matches", mustPatterns(`^Calculator\.`), "Add", true},
+		{"include pattern misses", mustPatterns(`^Calculator\.`), "clamp", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, ok := decls[tt.fn]
+			if !ok {
+				t.Fatalf("function %s not found in source", tt.fn)
+			}
+			info := ca.newFunctionInfo(fn, []byte(selectionSource))
+
+			got, reason := tt.policy.Include(fn, info)
+			if got != tt.want {
+				t.Errorf("Include(%s) = %v (%s), want %v", info.QualifiedName(), got, reason, tt.want)
+			}
+			if !got && reason == "" {
+				t.Errorf("Include(%s) returned no reason for skipping", info.QualifiedName())
+			}
+		})
+	}
+}
+
+func TestParsePatterns(t *testing.T) {
+	patterns, err := ParsePatterns(` ^Calculator\. , ,Divide$`)
+	if err != nil {
+		t.Fatalf("ParsePatterns returned error: %v", err)
+	}
+	if len(patterns) != 2 {
+		t.Fatalf("got %d patterns, want 2", len(patterns))
+	}
+
+	if _, err := ParsePatterns("("); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestIsTestingEntryPoint(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "x_test.go", `package x
+func TestMain() {}
+func Test() {}
+func Benchmark_Add() {}
+func Testing() {}
+func Examples() {}
+`, 0)
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+
+	want := map[string]bool{
+		"TestMain":      true,
+		"Test":          true,
+		"Benchmark_Add": true,
+		"Testing":       false,
+		"Examples":      false,
+	}
+	for _, decl := range file.Decls {
+		fn := decl.(*ast.FuncDecl)
+		if got := isTestingEntryPoint(fn); got != want[fn.Name.Name] {
+			t.Errorf("isTestingEntryPoint(%s) = %v, want %v", fn.Name.Name, got, want[fn.Name.Name])
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/types"
+	"log"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// SkeletonGenerator emits compiling table-driven test skeletons without a
+// model: one case per function with zero-value inputs and expectations for a
+// reviewer to fill in. It is the fallback when no model is available.
+type SkeletonGenerator struct {
+	Renderer TableRenderer
+	Fakes    []Fake       // used instead of nil for interface parameters
+	Build    BuildContext // tags and platform the package is loaded with
+}
+
+// Generate loads the package in dir to resolve the exact parameter and
+// result types of functions and renders a skeleton test for each.
+func (sg SkeletonGenerator) Generate(ctx context.Context, dir string, functions []FunctionInfo) (string, error) {
+	pkg, err := loadPackageDir(ctx, dir, sg.Build)
+	if err != nil {
+		return "", err
+	}
+
+	objects := make(map[string]*types.Func)
+	for fn, qualified := range packageFunctions(pkg) {
+		objects[qualified] = fn
+	}
+
+	qualifier := func(p *types.Package) string {
+		if p == pkg.Types {
+			return sg.Renderer.Qualifier
+		}
+		return p.Name()
+	}
+
+	var targets []FunctionInfo
+	cases := make(map[string][]TestCase)
+	for _, fn := range functions {
+		obj, ok := objects[fn.QualifiedName()]
+		if !ok {
+			log.Printf("Skipping skeleton for %s: not found in package", fn.QualifiedName())
+			continue
+		}
+		sig := obj.Type().(*types.Signature)
+		if sig.TypeParams().Len() > 0 || (sig.Recv() != nil && sig.RecvTypeParams().Len() > 0) {
+			if len(fn.Instantiations) == 0 {
+				log.Printf("Skipping skeleton for generic %s: no type arguments satisfy its constraints", fn.QualifiedName())
+				continue
+			}
+			var err error
+			if sig, err = instantiateSignature(pkg, obj, fn.Instantiations[0]); err != nil {
+				log.Printf("Skipping skeleton for generic %s: %v", fn.QualifiedName(), err)
+				continue
+			}
+			fn = fn.Instantiate(fn.Instantiations[0])
+		}
+
+		tc := TestCase{Name: "zero values"}
+		for i := 0; i < sig.Params().Len(); i++ {
+			t := sig.Params().At(i).Type()
+			if sig.Variadic() && i == sig.Params().Len()-1 {
+				// The table stores variadic arguments as a slice
+				tc.Inputs = append(tc.Inputs, "nil")
+				continue
+			}
+			tc.Inputs = append(tc.Inputs, sg.inputValue(t, qualifier))
+		}
+		for i := 0; i < sig.Results().Len(); i++ {
+			t := sig.Results().At(i).Type()
+			if i == sig.Results().Len()-1 && isErrorType(t) {
+				continue
+			}
+			tc.Want = append(tc.Want, zeroValue(t, qualifier))
+		}
+		if fn.Receiver != "" {
+			tc.Receiver = constructorCall(pkg, fn, sg.Renderer.Qualifier)
+		}
+
+		targets = append(targets, fn)
+		cases[fn.QualifiedName()] = []TestCase{tc}
+	}
+
+	if len(targets) == 0 {
+		return "", fmt.Errorf("no functions to generate skeletons for")
+	}
+
+	renderer := sg.Renderer
+	renderer.Skeleton = true
+	return renderer.Render(targets, cases)
+}
+
+// inputValue returns the zero value of t, or a new fake when t is an
+// interface with one.
+func (sg SkeletonGenerator) inputValue(t types.Type, qualifier types.Qualifier) string {
+	typeString := types.TypeString(t, qualifier)
+	for _, fake := range sg.Fakes {
+		if fake.Interface == typeString {
+			return "&" + fake.Name + "{}"
+		}
+	}
+	return zeroValue(t, qualifier)
+}
+
+// constructorCall returns a call to a parameterless New<Type> constructor
+// of the receiver type if the package has one, or "" for a zero receiver.
+func constructorCall(pkg *packages.Package, fn FunctionInfo, qualifier string) string {
+	name := "New" + capitalize(fn.Receiver)
+	obj, ok := pkg.Types.Scope().Lookup(name).(*types.Func)
+	if !ok || (qualifier != "" && !obj.Exported()) {
+		return ""
+	}
+
+	sig := obj.Type().(*types.Signature)
+	if sig.Params().Len() != 0 || sig.Results().Len() != 1 || sig.TypeParams().Len() != len(fn.TypeArgs) {
+		return ""
+	}
+
+	result := sig.Results().At(0).Type()
+	ptr, isPtr := result.(*types.Pointer)
+	if isPtr != fn.PointerRecv {
+		return ""
+	}
+	if isPtr {
+		result = ptr.Elem()
+	}
+	if named, ok := result.(*types.Named); !ok || named.Obj().Name() != fn.Receiver {
+		return ""
+	}
+
+	call := name + TableRenderer{Qualifier: qualifier}.typeArgs(fn) + "()"
+	if qualifier != "" {
+		return qualifier + "." + call
+	}
+	return call
+}
+
+// receiverValue returns an expression building a fresh receiver for fn:
+// a call to its constructor if there is one, otherwise a zero value.
+func receiverValue(pkg *packages.Package, fn FunctionInfo, qualifier string) string {
+	if constructor := constructorCall(pkg, fn, qualifier); constructor != "" {
+		return constructor
+	}
+	recvType := TableRenderer{Qualifier: qualifier}.receiverType(fn)
+	if fn.PointerRecv {
+		return "new(" + recvType + ")"
+	}
+	return "*new(" + recvType + ")"
+}
+
+// zeroValue returns a Go expression for the zero value of t.
+func zeroValue(t types.Type, qualifier types.Qualifier) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return "false"
+		case u.Info()&types.IsString != 0:
+			return `""`
+		case u.Info()&types.IsNumeric != 0:
+			return "0"
+		case u.Kind() == types.UnsafePointer:
+			return "nil"
+		}
+	case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature, *types.Interface:
+		return "nil"
+	case *types.Struct, *types.Array:
+		return types.TypeString(t, qualifier) + "{}"
+	}
+	return "*new(" + types.TypeString(t, qualifier) + ")"
+}
+
+func isErrorType(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
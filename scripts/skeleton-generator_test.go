@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestZeroValue(t *testing.T) {
+	pkg := types.NewPackage("example.com/calc", "calc")
+	named := func(name string, underlying types.Type) types.Type {
+		return types.NewNamed(types.NewTypeName(token.NoPos, pkg, name, nil), underlying, nil)
+	}
+
+	qualifier := func(p *types.Package) string { return p.Name() }
+
+	tests := []struct {
+		name string
+		typ  types.Type
+		want string
+	}{
+		{"int", types.Typ[types.Int], "0"},
+		{"float", types.Typ[types.Float64], "0"},
+		{"string", types.Typ[types.String], `""`},
+		{"bool", types.Typ[types.Bool], "false"},
+		{"error", types.Universe.Lookup("error").Type(), "nil"},
+		{"pointer", types.NewPointer(types.Typ[types.Int]), "nil"},
+		{"slice", types.NewSlice(types.Typ[types.String]), "nil"},
+		{"map", types.NewMap(types.Typ[types.String], types.Typ[types.Int]), "nil"},
+		{"named basic", named("Celsius", types.Typ[types.Float64]), "0"},
+		{"named struct", named("Point", types.NewStruct(nil, nil)), "calc.Point{}"},
+		{"array", types.NewArray(types.Typ[types.Int], 4), "[4]int{}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zeroValue(tt.typ, qualifier); got != tt.want {
+				t.Errorf("zeroValue(%s) = %s, want %s", tt.typ, got, tt.want)
+			}
+		})
+	}
+}
+
+// writeCalcModule writes the module example.com/calc with calc.go holding
+// src, and returns the functions of calc.go as the tool would target them.
+func writeCalcModule(t *testing.T, src string) (string, []FunctionInfo) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":  "module example.com/calc\n\ngo 1.22\n",
+		"calc.go": src,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ca := NewCoverageAnalyzer(SelectionPolicy{Targets: TargetsAll}, dir)
+	functions, err := ca.ExtractModifiedFunctions(context.Background(), "calc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, functions
+}
+
+func TestSkeletonGenerator_Generate(t *testing.T) {
+	dir, functions := writeCalcModule(t, `package calc
+
+import (
+	"errors"
+	"io"
+)
+
+type Point struct{ X, Y int }
+
+type Calculator struct{ history []string }
+
+func (c *Calculator) Divide(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a / b, nil
+}
+
+func Centroid(points []Point, weights map[string]float64) Point { return Point{} }
+
+func Copy(w io.Writer, data ...byte) error {
+	_, err := w.Write(data)
+	return err
+}
+
+func Max[T int | float64](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+`)
+
+	for _, tt := range []struct {
+		name string
+		pp   PostProcessor
+	}{
+		{"internal", PostProcessor{PackageName: "calc"}},
+		{"external", PostProcessor{PackageName: "calc_test", ImportName: "calc", ImportPath: "example.com/calc"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			renderer := TableRenderer{PackageName: tt.pp.PackageName}
+			if tt.pp.ImportPath != "" {
+				renderer.Qualifier = tt.pp.ImportName
+			}
+			src, err := SkeletonGenerator{Renderer: renderer}.Generate(context.Background(), dir, functions)
+			if err != nil {
+				t.Fatalf("Generate returned error: %v", err)
+			}
+			content, err := tt.pp.Process(src)
+			if err != nil {
+				t.Fatalf("generated skeletons are invalid: %v\n%s", err, src)
+			}
+			for _, name := range []string{"TestCalculator_Divide", "TestCentroid", "TestCopy", "TestMax"} {
+				if !strings.Contains(content, "func "+name+"(") {
+					t.Errorf("no %s generated:\n%s", name, content)
+				}
+			}
+
+			// Skeletons compile and skip themselves until filled in
+			runner := TestRunner{TestFile: "calc_gen_test.go"}
+			if output, err := runner.Run(context.Background(), dir, content, "-count=1"); err != nil {
+				t.Fatalf("generated skeletons fail: %v\n%s\n%s", err, output, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// StyleStdlib asserts with the testing package only.
+	StyleStdlib = "stdlib"
+	// StyleTestify asserts with testify's assert and require packages.
+	StyleTestify = "testify"
+	// StyleTableDriven ranges over a table of cases with a subtest each.
+	StyleTableDriven = "table-driven"
+	// StyleParallel runs tests and subtests in parallel.
+	StyleParallel = "parallel"
+)
+
+const testifyModule = "github.com/stretchr/testify"
+
+// testifyImports are the testify packages generated tests may use, by name.
+var testifyImports = map[string]string{
+	"assert":  testifyModule + "/assert",
+	"require": testifyModule + "/require",
+}
+
+// StyleProfile is the shape generated tests must have. It is described to
+// the model and checked on its output by CheckStyle.
+type StyleProfile struct {
+	Assertions  string         // StyleStdlib or StyleTestify
+	TableDriven bool           // each test ranges over cases, running each with t.Run
+	Parallel    bool           // tests and their subtests call t.Parallel
+	HelperNames *regexp.Regexp // names helper functions must match; nil allows any
+}
+
+// DefaultStyleProfile asserts with the standard library and imposes no
+// structure.
+func DefaultStyleProfile() StyleProfile {
+	return StyleProfile{Assertions: StyleStdlib}
+}
+
+// ParseStyleProfile parses a comma-separated list of traits: stdlib or
+// testify, table-driven and parallel.
+func ParseStyleProfile(spec string) (StyleProfile, error) {
+	profile := DefaultStyleProfile()
+	assertions := ""
+	for _, trait := range ParseList(spec) {
+		switch trait {
+		case StyleStdlib, StyleTestify:
+			if assertions != "" && assertions != trait {
+				return profile, fmt.Errorf("%s and %s exclude each other", assertions, trait)
+			}
+			assertions = trait
+			profile.Assertions = trait
+		case StyleTableDriven:
+			profile.TableDriven = true
+		case StyleParallel:
+			profile.Parallel = true
+		default:
+			return profile, fmt.Errorf("unknown style trait %q: must be %s, %s, %s or %s", trait, StyleStdlib, StyleTestify, StyleTableDriven, StyleParallel)
+		}
+	}
+	return profile, nil
+}
+
+// String lists the traits of the profile, e.g. "testify, table-driven".
+func (sp StyleProfile) String() string {
+	traits := []string{sp.Assertions}
+	if sp.TableDriven {
+		traits = append(traits, StyleTableDriven)
+	}
+	if sp.Parallel {
+		traits = append(traits, StyleParallel)
+	}
+	if sp.HelperNames != nil {
+		traits = append(traits, "helpers matching "+sp.HelperNames.String())
+	}
+	return strings.Join(traits, ", ")
+}
+
+// ForModule adapts the profile to the module containing dir: generated
+// tests must not add dependencies, so testify falls back to stdlib unless
+// the module already requires it. It returns why it changed the profile.
+func (sp StyleProfile) ForModule(dir string) (StyleProfile, string) {
+	if sp.Assertions != StyleTestify {
+		return sp, ""
+	}
+	requires, err := moduleRequires(dir, testifyModule)
+	if err != nil {
+		log.Printf("Could not check go.mod for %s: %v", testifyModule, err)
+	}
+	if requires {
+		return sp, ""
+	}
+	sp.Assertions = StyleStdlib
+	return sp, fmt.Sprintf("the module does not require %s, so the tests use the standard library instead", testifyModule)
+}
+
+// CheckStyle returns where the functions in src break the profile. Helper
+// functions are checked for their names only; CheckStyle does not mind a
+// missing t.Helper call, which addHelperCalls fixes.
+func CheckStyle(src string, profile StyleProfile) ([]Violation, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "generated_test.go", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated code: %v", err)
+	}
+
+	testify := make(map[string]bool)
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err == nil && (importPath == testifyModule || strings.HasPrefix(importPath, testifyModule+"/")) {
+			name, _ := importName(spec, importPath)
+			testify[name] = true
+		}
+	}
+
+	var violations []Violation
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Body == nil {
+			continue
+		}
+		name := fn.Name.Name
+		add := func(rule, detail string) {
+			violations = append(violations, Violation{Function: name, Line: fset.Position(fn.Pos()).Line, Rule: rule, Detail: detail})
+		}
+
+		if !isTestingEntryPoint(fn) {
+			if profile.HelperNames != nil && !profile.HelperNames.MatchString(name) {
+				add("helper name", fmt.Sprintf("helper %s does not match %s", name, profile.HelperNames))
+			}
+			continue
+		}
+
+		if profile.Assertions == StyleStdlib {
+			if used := selectorsOn(fn.Body, testify); len(used) > 0 {
+				add(StyleStdlib, fmt.Sprintf("uses testify (%s) instead of the testing package", strings.Join(used, ", ")))
+			}
+		}
+		if !strings.HasPrefix(name, "Test") || name == "TestMain" || skipsFirst(fn.Body) {
+			continue
+		}
+
+		tNames := testingParams(fn)
+		if profile.Assertions == StyleTestify {
+			if used := testingFailureCalls(fn.Body, tNames); len(used) > 0 {
+				add(StyleTestify, fmt.Sprintf("reports failures with %s instead of assert or require", strings.Join(used, ", ")))
+			}
+		}
+		if profile.TableDriven && !runsSubtestsInLoop(fn.Body, tNames) {
+			add(StyleTableDriven, "is not table-driven: no t.Run inside a loop over test cases")
+		}
+		if profile.Parallel {
+			if !callsFirst(fn.Body, tNames, "Parallel") {
+				add(StyleParallel, "does not call t.Parallel")
+			} else if !subtestsParallel(fn.Body, tNames) {
+				add(StyleParallel, "has subtests that do not call t.Parallel")
+			}
+		}
+	}
+	return violations, nil
+}
+
+// selectorsOn returns the sorted pkg.Name selectors in n whose operand is
+// one of names.
+func selectorsOn(n ast.Node, names map[string]bool) []string {
+	seen := make(map[string]bool)
+	ast.Inspect(n, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && names[x.Name] {
+				seen[x.Name+"."+sel.Sel.Name] = true
+			}
+		}
+		return true
+	})
+	var used []string
+	for name := range seen {
+		used = append(used, name)
+	}
+	sort.Strings(used)
+	return used
+}
+
+// testingFailureCalls returns the sorted t.Error-like methods called on the
+// testing parameters in n.
+func testingFailureCalls(n ast.Node, tNames map[string]bool) []string {
+	seen := make(map[string]bool)
+	ast.Inspect(n, func(n ast.Node) bool {
+		if method, ok := testingCall(n, tNames); ok && testingFailures[method] {
+			seen["t."+method] = true
+		}
+		return true
+	})
+	var used []string
+	for name := range seen {
+		used = append(used, name)
+	}
+	sort.Strings(used)
+	return used
+}
+
+// testingCall returns the method called if n calls one on a testing
+// parameter.
+func testingCall(n ast.Node, tNames map[string]bool) (string, bool) {
+	call, ok := n.(*ast.CallExpr)
+	if !ok {
+		return "", false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok || !tNames[x.Name] {
+		return "", false
+	}
+	return sel.Sel.Name, true
+}
+
+// runsSubtestsInLoop reports whether body calls t.Run inside a range loop.
+func runsSubtestsInLoop(body *ast.BlockStmt, tNames map[string]bool) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		loop, ok := n.(*ast.RangeStmt)
+		if !ok || found {
+			return !found
+		}
+		ast.Inspect(loop.Body, func(n ast.Node) bool {
+			if method, ok := testingCall(n, tNames); ok && method == "Run" {
+				found = true
+			}
+			return !found
+		})
+		return !found
+	})
+	return found
+}
+
+// callsFirst reports whether one of the leading statements of body, before
+// anything but other calls on t, calls the method on a testing parameter.
+func callsFirst(body *ast.BlockStmt, tNames map[string]bool, method string) bool {
+	for _, stmt := range body.List {
+		expr, ok := stmt.(*ast.ExprStmt)
+		if !ok {
+			return false
+		}
+		called, ok := testingCall(expr.X, tNames)
+		if !ok {
+			return false
+		}
+		if called == method {
+			return true
+		}
+	}
+	return false
+}
+
+// subtestsParallel reports whether every function literal run by t.Run in
+// body calls t.Parallel first.
+func subtestsParallel(body *ast.BlockStmt, tNames map[string]bool) bool {
+	parallel := true
+	ast.Inspect(body, func(n ast.Node) bool {
+		if method, ok := testingCall(n, tNames); ok && method == "Run" {
+			call := n.(*ast.CallExpr)
+			if len(call.Args) == 2 {
+				if lit, ok := call.Args[1].(*ast.FuncLit); ok && !callsFirst(lit.Body, tNames, "Parallel") {
+					parallel = false
+				}
+			}
+		}
+		return parallel
+	})
+	return parallel
+}
+
+// addHelperCalls makes the helper functions in src that take a *testing.T
+// or testing.TB call its Helper method first, so failures point at the
+// caller.
+func (pp PostProcessor) addHelperCalls(src string) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "generated_test.go", src, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse generated code: %v", err)
+	}
+
+	type insertion struct {
+		offset int
+		text   string
+	}
+	var insertions []insertion
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Body == nil || isTestingEntryPoint(fn) {
+			continue
+		}
+		var param string
+		for _, field := range fn.Type.Params.List {
+			if t := types.ExprString(field.Type); (t == "*testing.T" || t == "testing.TB") && len(field.Names) > 0 {
+				param = field.Names[0].Name
+				break
+			}
+		}
+		if param == "" || param == "_" || callsFirst(fn.Body, map[string]bool{param: true}, "Helper") {
+			continue
+		}
+		insertions = append(insertions, insertion{
+			offset: fset.Position(fn.Body.Lbrace).Offset + 1,
+			text:   fmt.Sprintf("\n%s.Helper()", param),
+		})
+	}
+	if len(insertions) == 0 {
+		return src, nil
+	}
+
+	sort.Slice(insertions, func(i, j int) bool { return insertions[i].offset > insertions[j].offset })
+	for _, ins := range insertions {
+		src = src[:ins.offset] + ins.text + src[ins.offset:]
+	}
+	return pp.Process(src)
+}
+
+// enforceStyle adds missing t.Helper calls and drops the functions that
+// break the style profile, along with the tests calling a dropped helper.
+// It fails if no test is left.
+func (tg *TestGenerator) enforceStyle(content string, profile StyleProfile, postProcessor PostProcessor) (string, []Violation, []DroppedTest, error) {
+	content, err := postProcessor.addHelperCalls(content)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	violations, err := CheckStyle(content, profile)
+	if err != nil || len(violations) == 0 {
+		return content, nil, nil, err
+	}
+
+	entryPoints, err := testingEntryPoints(content)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	reasons := make(map[string]string)
+	badHelpers := make(map[string]bool)
+	for _, v := range violations {
+		if _, ok := reasons[v.Function]; !ok {
+			reasons[v.Function] = "breaks the style profile: " + v.Detail
+		}
+		if !entryPoints[v.Function] {
+			badHelpers[v.Function] = true
+		}
+	}
+	// Helpers calling a dropped helper go too, and so on
+	for len(badHelpers) > 0 {
+		callers, err := helperCallers(content, badHelpers)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		badHelpers = make(map[string]bool)
+		for caller, helper := range callers {
+			if _, ok := reasons[caller]; ok {
+				continue
+			}
+			reasons[caller] = fmt.Sprintf("calls helper %s, which breaks the style profile", helper)
+			if !entryPoints[caller] {
+				badHelpers[caller] = true
+			}
+		}
+	}
+
+	declared, err := declaredFunctions(content)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	drop := make(map[string]bool)
+	var dropped []DroppedTest
+	left := 0
+	for _, name := range sortedNames(declared) {
+		reason, ok := reasons[name]
+		if !ok {
+			if strings.HasPrefix(name, "Test") {
+				left++
+			}
+			continue
+		}
+		drop[name] = true
+		dropped = append(dropped, DroppedTest{Name: name, Reason: reason})
+	}
+	if left == 0 {
+		return "", violations, nil, fmt.Errorf("no generated test follows the %s style profile: %s", profile, violations[0])
+	}
+
+	content, err = postProcessor.removeDeclarations(content, drop)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return content, violations, dropped, nil
+}
+
+// testingEntryPoints returns the names of the functions in src that go
+// test runs.
+func testingEntryPoints(src string) (map[string]bool, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "generated_test.go", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated code: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && isTestingEntryPoint(fn) {
+			names[fn.Name.Name] = true
+		}
+	}
+	return names, nil
+}
+
+// helperCallers maps each function in src that uses one of helpers to the
+// first such helper.
+func helperCallers(src string, helpers map[string]bool) (map[string]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "generated_test.go", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated code: %v", err)
+	}
+	callers := make(map[string]string)
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil || helpers[fn.Name.Name] {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok && helpers[ident.Name] {
+				if _, seen := callers[fn.Name.Name]; !seen {
+					callers[fn.Name.Name] = ident.Name
+				}
+			}
+			return true
+		})
+	}
+	return callers, nil
+}
+
+func sortedNames(names map[string]bool) []string {
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
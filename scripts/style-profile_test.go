@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseStyleProfile(t *testing.T) {
+	profile, err := ParseStyleProfile("testify, table-driven,parallel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := StyleProfile{Assertions: StyleTestify, TableDriven: true, Parallel: true}
+	if profile != want {
+		t.Errorf("ParseStyleProfile() = %+v, want %+v", profile, want)
+	}
+	if got := profile.String(); got != "testify, table-driven, parallel" {
+		t.Errorf("String() = %q", got)
+	}
+
+	for _, spec := range []string{"stdlib,testify", "gomega"} {
+		if _, err := ParseStyleProfile(spec); err == nil {
+			t.Errorf("ParseStyleProfile(%q) did not fail", spec)
+		}
+	}
+}
+
+func TestCheckStyle(t *testing.T) {
+	src := `package calc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTable(t *testing.T) {
+	t.Parallel()
+	tests := []struct{ a, want int }{{1, 1}}
+	for _, tt := range tests {
+		t.Run("case", func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, Abs(tt.a))
+		})
+	}
+}
+
+func TestPlain(t *testing.T) {
+	if Abs(-1) != 1 {
+		t.Errorf("Abs(-1) != 1")
+	}
+}
+
+func TestSerialSubtests(t *testing.T) {
+	t.Parallel()
+	for _, a := range []int{1} {
+		t.Run("case", func(t *testing.T) {
+			assert.Equal(t, a, Abs(a))
+		})
+	}
+}
+
+func check_abs(t *testing.T, a int) {
+	t.Helper()
+}
+
+// Not run by go test: a lower-case letter follows the prefix
+func Testdata(t *testing.T) []int {
+	t.Helper()
+	return []int{1}
+}
+`
+	violations, err := CheckStyle(src, StyleProfile{Assertions: StyleTestify, TableDriven: true, Parallel: true, HelperNames: regexp.MustCompile(`^[a-z][a-zA-Z]*$`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.Function+": "+v.Detail)
+	}
+	want := []string{
+		"TestPlain: reports failures with t.Errorf instead of assert or require",
+		"TestPlain: is not table-driven: no t.Run inside a loop over test cases",
+		"TestPlain: does not call t.Parallel",
+		"TestSerialSubtests: has subtests that do not call t.Parallel",
+		"check_abs: helper check_abs does not match ^[a-z][a-zA-Z]*$",
+		"Testdata: helper Testdata does not match ^[a-z][a-zA-Z]*$",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("CheckStyle() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	violations, err = CheckStyle(src, DefaultStyleProfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 2 || violations[0].Function != "TestTable" || !strings.Contains(violations[0].Detail, "assert.Equal") {
+		t.Errorf("CheckStyle() with stdlib = %v, want testify uses in TestTable and TestSerialSubtests", violations)
+	}
+}
+
+func TestEnforceStyle(t *testing.T) {
+	src := `package calc
+
+import "testing"
+
+func TestGood(t *testing.T) {
+	checkAbs(t, 1)
+}
+
+func TestUsesBadHelper(t *testing.T) {
+	outer(t)
+}
+
+func outer(t *testing.T) {
+	Check_abs(t, 2)
+}
+
+func Check_abs(t *testing.T, a int) {
+	if Abs(a) != a {
+		t.Errorf("Abs(%d) != %d", a, a)
+	}
+}
+
+func checkAbs(tb testing.TB, a int) {
+	if Abs(a) != a {
+		tb.Errorf("Abs(%d) != %d", a, a)
+	}
+}
+`
+	tg := &TestGenerator{}
+	profile := StyleProfile{Assertions: StyleStdlib, HelperNames: regexp.MustCompile(`^[a-z]`)}
+	content, violations, dropped, err := tg.enforceStyle(src, profile, PostProcessor{PackageName: "calc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0].Function != "Check_abs" {
+		t.Errorf("violations = %v, want Check_abs only", violations)
+	}
+	var names []string
+	for _, d := range dropped {
+		names = append(names, d.Name)
+	}
+	if got := strings.Join(names, ","); got != "Check_abs,TestUsesBadHelper,outer" {
+		t.Errorf("dropped = %s, want the helper and its callers", got)
+	}
+	if !strings.Contains(content, "func checkAbs(tb testing.TB, a int) {\n\ttb.Helper()\n") {
+		t.Errorf("t.Helper() was not added to checkAbs:\n%s", content)
+	}
+	if strings.Contains(content, "outer") || !strings.Contains(content, "TestGood") {
+		t.Errorf("unexpected content:\n%s", content)
+	}
+
+	if _, _, _, err := tg.enforceStyle(src, StyleProfile{Assertions: StyleStdlib, TableDriven: true}, PostProcessor{PackageName: "calc"}); err == nil {
+		t.Errorf("enforceStyle() did not fail with no test left")
+	}
+}
+
+func TestStyleProfileForModule(t *testing.T) {
+	dir := t.TempDir()
+	write := func(goMod string) {
+		if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	profile := StyleProfile{Assertions: StyleTestify, TableDriven: true}
+
+	write("module example.com/calc\n\ngo 1.22\n")
+	got, note := profile.ForModule(dir)
+	if got.Assertions != StyleStdlib || !got.TableDriven || note == "" {
+		t.Errorf("ForModule() without testify = %+v, %q", got, note)
+	}
+
+	write("module example.com/calc\n\ngo 1.22\n\nrequire github.com/stretchr/testify v1.9.0\n")
+	if got, note := profile.ForModule(dir); got != profile || note != "" {
+		t.Errorf("ForModule() with testify = %+v, %q", got, note)
+	}
+}
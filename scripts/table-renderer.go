@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"strings"
+	"text/template"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Output modes for the model response.
+const (
+	OutputModeCode = "code" // the model writes the whole test file
+	OutputModeJSON = "json" // the model returns a TestSpec rendered by TableRenderer
+)
+
+// TestSpec is the structured response requested from the model in JSON
+// output mode: test cases per function, with Go expressions as values.
+type TestSpec struct {
+	Functions []FunctionSpec `json:"functions"`
+}
+
+// FunctionSpec holds the test cases for one function.
+type FunctionSpec struct {
+	Function string     `json:"function"` // qualified name, e.g. "Calculator.Add"
+	Cases    []TestCase `json:"cases"`
+}
+
+// TestCase is a single row of a table-driven test.
+type TestCase struct {
+	Name     string   `json:"name"`
+	Receiver string   `json:"receiver,omitempty"` // expression building the receiver, e.g. "NewCalculator()"
+	Setup    []string `json:"setup,omitempty"`    // statements run before the call, with the receiver as recv
+	Inputs   []string `json:"inputs"`             // one expression per parameter
+	Want     []string `json:"want"`               // one expression per non-error result
+	WantErr  bool     `json:"wantErr"`
+}
+
+// tableTest is the template data for one table-driven test function.
+type tableTest struct {
+	TestName     string
+	FuncName     string
+	Call         string
+	RecvType     string
+	Args         []tableField
+	Wants        []tableField
+	Results      []string // left-hand side of the call, e.g. got, err
+	ReturnsError bool
+	Cases        []tableCase
+	Skeleton     bool
+}
+
+type tableField struct {
+	Name     string
+	Type     string
+	Variadic bool
+}
+
+type tableCase struct {
+	Name     string
+	Receiver string
+	Setup    []string
+	Args     string
+	Wants    []string
+	WantErr  bool
+}
+
+var tableTestTemplate = template.Must(template.New("table").Funcs(template.FuncMap{"join": strings.Join}).Parse(`
+func {{.TestName}}(t *testing.T) {
+	type args struct {
+{{- range .Args}}
+		{{.Name}} {{.Type}}
+{{- end}}
+	}
+	tests := []struct {
+		name string
+{{- if .RecvType}}
+		recv {{.RecvType}}
+		setup func(recv {{.RecvType}})
+{{- else}}
+		setup func()
+{{- end}}
+		args args
+{{- range .Wants}}
+		{{.Name}} {{.Type}}
+{{- end}}
+{{- if .ReturnsError}}
+		wantErr bool
+{{- end}}
+	}{
+{{- range .Cases}}
+{{- if $.Skeleton}}
+		// TODO: replace the zero values with real inputs and expected results.
+{{- end}}
+		{
+			name: {{printf "%q" .Name}},
+{{- if $.RecvType}}
+			recv: {{.Receiver}},
+{{- end}}
+{{- if .Setup}}
+			setup: func({{if $.RecvType}}recv {{$.RecvType}}{{end}}) {
+{{- range .Setup}}
+				{{.}}
+{{- end}}
+			},
+{{- end}}
+			args: args{ {{.Args}} },
+{{- range .Wants}}
+			{{.}},
+{{- end}}
+{{- if .WantErr}}
+			wantErr: true,
+{{- end}}
+		},
+{{- end}}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+{{- if .Skeleton}}
+			t.Skip("TODO: fill in this generated test case")
+{{- end}}
+{{- if .RecvType}}
+			recv := tt.recv
+			if tt.setup != nil {
+				tt.setup(recv)
+			}
+{{- else}}
+			if tt.setup != nil {
+				tt.setup()
+			}
+{{- end}}
+			{{if .Results}}{{join .Results ", "}} := {{end}}{{.Call}}({{range $i, $a := .Args}}{{if $i}}, {{end}}tt.args.{{$a.Name}}{{if $a.Variadic}}...{{end}}{{end}})
+{{- if .ReturnsError}}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("{{.FuncName}}() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+{{- end}}
+{{- range $i, $w := .Wants}}
+			if !reflect.DeepEqual({{index $.Results $i}}, tt.{{$w.Name}}) {
+				t.Errorf("{{$.FuncName}}() {{index $.Results $i}} = %v, want %v", {{index $.Results $i}}, tt.{{$w.Name}})
+			}
+{{- end}}
+		})
+	}
+}
+`))
+
+// TableRenderer renders table-driven tests from structured test cases.
+type TableRenderer struct {
+	PackageName string // package clause of the test file
+	Qualifier   string // package name to qualify identifiers with in external tests, or ""
+	Skeleton    bool   // cases are placeholders; mark them TODO and skip them
+}
+
+// Render produces a test file with one table-driven test per function.
+// Functions without cases get a test with an empty table.
+func (tr TableRenderer) Render(functions []FunctionInfo, cases map[string][]TestCase) (string, error) {
+	var out bytes.Buffer
+	out.WriteString(fmt.Sprintf("package %s\n\nimport (\n\t\"reflect\"\n\t\"testing\"\n)\n", tr.PackageName))
+
+	for _, fn := range functions {
+		test := tr.tableTest(fn, cases[fn.QualifiedName()])
+		if err := tableTestTemplate.Execute(&out, test); err != nil {
+			return "", fmt.Errorf("failed to render test for %s: %v", fn.QualifiedName(), err)
+		}
+	}
+
+	return out.String(), nil
+}
+
+func (tr TableRenderer) tableTest(fn FunctionInfo, cases []TestCase) tableTest {
+	test := tableTest{
+		TestName: fn.TestName(),
+		FuncName: fn.QualifiedName(),
+		Skeleton: tr.Skeleton,
+	}
+
+	// Generic functions are tested with their first instantiation
+	if len(fn.TypeArgs) == 0 && len(fn.Instantiations) > 0 {
+		fn = fn.Instantiate(fn.Instantiations[0])
+	}
+
+	if fn.Receiver != "" {
+		test.RecvType = tr.receiverType(fn)
+		if fn.PointerRecv {
+			test.RecvType = "*" + test.RecvType
+		}
+		test.Call = "recv." + fn.Name
+	} else if tr.Qualifier != "" {
+		test.Call = tr.Qualifier + "." + fn.Name + tr.typeArgs(fn)
+	} else {
+		test.Call = fn.Name + tr.typeArgs(fn)
+	}
+
+	for _, param := range fn.Params {
+		field := tableField{Name: param.Name, Type: tr.qualify(param.Type)}
+		if strings.HasPrefix(param.Type, "...") {
+			field.Type = "[]" + tr.qualify(strings.TrimPrefix(param.Type, "..."))
+			field.Variadic = true
+		}
+		test.Args = append(test.Args, field)
+	}
+
+	for i, res := range fn.Results {
+		if fn.ReturnsError && i == len(fn.Results)-1 {
+			test.Results = append(test.Results, "err")
+			test.ReturnsError = true
+			continue
+		}
+		name := "want"
+		got := "got"
+		if n := len(test.Wants); n > 0 {
+			name = fmt.Sprintf("want%d", n)
+			got = fmt.Sprintf("got%d", n)
+		}
+		test.Wants = append(test.Wants, tableField{Name: name, Type: tr.qualify(res.Type)})
+		test.Results = append(test.Results, got)
+	}
+
+	for _, tc := range cases {
+		row := tableCase{
+			Name:     tc.Name,
+			Receiver: tc.Receiver,
+			Setup:    tc.Setup,
+			WantErr:  tc.WantErr && test.ReturnsError,
+		}
+		if row.Receiver == "" {
+			row.Receiver = tr.zeroReceiver(fn)
+		}
+
+		var args []string
+		for i, input := range tc.Inputs {
+			args = append(args, fmt.Sprintf("%s: %s", test.Args[i].Name, input))
+		}
+		row.Args = strings.Join(args, ", ")
+
+		for i, want := range tc.Want {
+			row.Wants = append(row.Wants, fmt.Sprintf("%s: %s", test.Wants[i].Name, want))
+		}
+		test.Cases = append(test.Cases, row)
+	}
+
+	return test
+}
+
+// zeroReceiver returns an expression for a zero-valued receiver that
+// compiles whatever the underlying type is.
+func (tr TableRenderer) zeroReceiver(fn FunctionInfo) string {
+	if fn.PointerRecv {
+		return "new(" + tr.receiverType(fn) + ")"
+	}
+	return "*new(" + tr.receiverType(fn) + ")"
+}
+
+// receiverType returns the qualified receiver type of fn, instantiated
+// with its type arguments if it is generic.
+func (tr TableRenderer) receiverType(fn FunctionInfo) string {
+	return tr.qualify(fn.Receiver) + tr.typeArgs(fn)
+}
+
+// typeArgs returns the qualified type argument list of an instantiated
+// function, e.g. "[int, calc.Celsius]", or "" if it has none.
+func (tr TableRenderer) typeArgs(fn FunctionInfo) string {
+	if len(fn.TypeArgs) == 0 {
+		return ""
+	}
+	var args []string
+	for _, arg := range fn.TypeArgs {
+		args = append(args, tr.qualify(arg))
+	}
+	return "[" + strings.Join(args, ", ") + "]"
+}
+
+// qualify prefixes the package-level identifiers in a type expression with
+// the package qualifier, e.g. "*Calculator" becomes "*calculator.Calculator".
+func (tr TableRenderer) qualify(typeExpr string) string {
+	if tr.Qualifier == "" {
+		return typeExpr
+	}
+
+	variadic := strings.HasPrefix(typeExpr, "...")
+	expr, err := parser.ParseExpr(strings.TrimPrefix(typeExpr, "..."))
+	if err != nil {
+		return typeExpr
+	}
+
+	expr = astutil.Apply(expr, func(c *astutil.Cursor) bool {
+		ident, ok := c.Node().(*ast.Ident)
+		if !ok {
+			return true
+		}
+		switch c.Parent().(type) {
+		case *ast.SelectorExpr:
+			return true
+		case *ast.Field:
+			if c.Name() == "Names" {
+				return true
+			}
+		}
+		if types.Universe.Lookup(ident.Name) == nil {
+			c.Replace(&ast.SelectorExpr{X: ast.NewIdent(tr.Qualifier), Sel: ast.NewIdent(ident.Name)})
+		}
+		return true
+	}, nil).(ast.Expr)
+
+	if variadic {
+		return "..." + types.ExprString(expr)
+	}
+	return types.ExprString(expr)
+}
+
+// ParseTestSpec decodes the model's JSON response and keeps only the cases
+// that fit the signatures of the targeted functions, logging the rest.
+func ParseTestSpec(raw string, functions []FunctionInfo) (map[string][]TestCase, error) {
+	var spec TestSpec
+	if err := json.Unmarshal([]byte(extractJSON(raw)), &spec); err != nil {
+		return nil, fmt.Errorf("model response is not valid test case JSON: %v", err)
+	}
+
+	byName := make(map[string]FunctionInfo)
+	for _, fn := range functions {
+		byName[fn.QualifiedName()] = fn
+	}
+
+	cases := make(map[string][]TestCase)
+	valid := 0
+	for _, fs := range spec.Functions {
+		fn, ok := byName[fs.Function]
+		if !ok {
+			log.Printf("Ignoring test cases for unknown function %s", fs.Function)
+			continue
+		}
+		for _, tc := range fs.Cases {
+			if err := validateTestCase(fn, tc); err != nil {
+				log.Printf("Dropping test case %q for %s: %v", tc.Name, fs.Function, err)
+				continue
+			}
+			cases[fs.Function] = append(cases[fs.Function], tc)
+			valid++
+		}
+	}
+
+	if valid == 0 {
+		return nil, fmt.Errorf("model response contains no valid test cases")
+	}
+	return cases, nil
+}
+
+// validateTestCase checks a case against the function signature and makes
+// sure every value and statement is syntactically valid Go.
+func validateTestCase(fn FunctionInfo, tc TestCase) error {
+	if strings.TrimSpace(tc.Name) == "" {
+		return fmt.Errorf("missing name")
+	}
+	if len(tc.Inputs) != len(fn.Params) {
+		return fmt.Errorf("got %d inputs, want %d", len(tc.Inputs), len(fn.Params))
+	}
+
+	wantCount := len(fn.Results)
+	if fn.ReturnsError {
+		wantCount--
+	}
+	if len(tc.Want) != wantCount && !(tc.WantErr && len(tc.Want) == 0) {
+		return fmt.Errorf("got %d expected values, want %d", len(tc.Want), wantCount)
+	}
+	if tc.WantErr && !fn.ReturnsError {
+		return fmt.Errorf("expects an error from a function that cannot return one")
+	}
+	if tc.Receiver != "" && fn.Receiver == "" {
+		return fmt.Errorf("receiver given for a plain function")
+	}
+
+	exprs := append(append([]string{}, tc.Inputs...), tc.Want...)
+	if tc.Receiver != "" {
+		exprs = append(exprs, tc.Receiver)
+	}
+	for _, expr := range exprs {
+		if _, err := parser.ParseExpr(expr); err != nil {
+			return fmt.Errorf("invalid expression %q: %v", expr, err)
+		}
+	}
+
+	if len(tc.Setup) > 0 {
+		body := "package p\nfunc _() {\n" + strings.Join(tc.Setup, "\n") + "\n}\n"
+		if _, err := parser.ParseFile(token.NewFileSet(), "", body, 0); err != nil {
+			return fmt.Errorf("invalid setup statements: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// extractJSON returns the JSON object in raw model output, from a fenced
+// block if there is one and otherwise between the outermost braces.
+func extractJSON(raw string) string {
+	text := extractGoCode(raw)
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return text
+	}
+	return text[start : end+1]
+}
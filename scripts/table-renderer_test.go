@@ -0,0 +1,142 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"strings"
+	"testing"
+)
+
+var rendererFunctions = []FunctionInfo{
+	{
+		Name:         "Divide",
+		Receiver:     "Calculator",
+		PointerRecv:  true,
+		Params:       []Param{{Name: "a", Type: "int"}, {Name: "b", Type: "int"}},
+		Results:      []Param{{Name: "res0", Type: "int"}, {Name: "res1", Type: "error"}},
+		ReturnsError: true,
+	},
+	{
+		Name:    "Sum",
+		Params:  []Param{{Name: "nums", Type: "...int"}},
+		Results: []Param{{Name: "res0", Type: "int"}},
+	},
+}
+
+func TestParseTestSpec(t *testing.T) {
+	raw := "Sure!\n```json\n" + `{"functions": [
+	{"function": "Calculator.Divide", "cases": [
+		{"name": "divides", "receiver": "NewCalculator()", "inputs": ["6", "3"], "want": ["2"]},
+		{"name": "by zero", "inputs": ["1", "0"], "want": [], "wantErr": true},
+		{"name": "too few inputs", "inputs": ["1"], "want": ["1"]},
+		{"name": "bad expression", "inputs": ["1", "2 +"], "want": ["0"]}
+	]},
+	{"function": "Sum", "cases": [
+		{"name": "no error possible", "inputs": ["nil"], "want": [], "wantErr": true}
+	]},
+	{"function": "Unknown", "cases": [{"name": "x", "inputs": [], "want": []}]}
+]}` + "\n```"
+
+	cases, err := ParseTestSpec(raw, rendererFunctions)
+	if err != nil {
+		t.Fatalf("ParseTestSpec returned error: %v", err)
+	}
+	if got := len(cases["Calculator.Divide"]); got != 2 {
+		t.Errorf("got %d valid Divide cases, want 2", got)
+	}
+	if got := len(cases["Sum"]); got != 0 {
+		t.Errorf("got %d valid Sum cases, want 0", got)
+	}
+
+	if _, err := ParseTestSpec("not json", rendererFunctions); err == nil {
+		t.Error("expected error for a non-JSON response")
+	}
+}
+
+func TestTableRenderer_LeadingError(t *testing.T) {
+	// Only a trailing error is checked separately; any other one is a value
+	ca := NewCoverageAnalyzer(DefaultSelectionPolicy(), "")
+	src := "package p\n\nfunc Split(s string) (error, string) {\n\treturn nil, s\n}\n"
+	file, err := parser.ParseFile(ca.fileSet, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := ca.newFunctionInfo(file.Decls[0].(*ast.FuncDecl), []byte(src))
+	if fn.ReturnsError {
+		t.Fatal("ReturnsError is set for a leading error result")
+	}
+
+	if err := validateTestCase(fn, TestCase{Name: "splits", Inputs: []string{`"a"`}, Want: []string{"nil", `"a"`}}); err != nil {
+		t.Errorf("validateTestCase rejected a case with both results: %v", err)
+	}
+	if err := validateTestCase(fn, TestCase{Name: "fails", Inputs: []string{`"a"`}, WantErr: true}); err == nil {
+		t.Error("validateTestCase accepted wantErr for a leading error")
+	}
+
+	cases := map[string][]TestCase{"Split": {{Name: "splits", Inputs: []string{`"a"`}, Want: []string{"nil", `"a"`}}}}
+	rendered, err := TableRenderer{PackageName: "p"}.Render([]FunctionInfo{fn}, cases)
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if !strings.Contains(rendered, "got, got1 := Split(tt.args.s)") {
+		t.Errorf("rendered test does not compare both results:\n%s", rendered)
+	}
+}
+
+func TestTableRenderer_Render(t *testing.T) {
+	cases := map[string][]TestCase{
+		"Calculator.Divide": {
+			{Name: "divides", Receiver: "calculator.NewCalculator()", Setup: []string{"recv.Add(1, 2)"}, Inputs: []string{"6", "3"}, Want: []string{"2"}},
+			{Name: "by zero", Inputs: []string{"1", "0"}, WantErr: true},
+		},
+		"Sum": {
+			{Name: "sums", Inputs: []string{"[]int{1, 2}"}, Want: []string{"3"}},
+		},
+	}
+
+	renderer := TableRenderer{PackageName: "calculator_test", Qualifier: "calculator"}
+	src, err := renderer.Render(rendererFunctions, cases)
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+
+	pp := PostProcessor{PackageName: "calculator_test", ImportName: "calculator", ImportPath: "example.com/calculator"}
+	got, err := pp.Process(src)
+	if err != nil {
+		t.Fatalf("rendered code is invalid: %v\n%s", err, src)
+	}
+
+	for _, want := range []string{
+		"func TestCalculator_Divide(t *testing.T)",
+		"recv    *calculator.Calculator",
+		"recv:    new(calculator.Calculator)",
+		"recv.Add(1, 2)",
+		"got, err := recv.Divide(tt.args.a, tt.args.b)",
+		"wantErr: true",
+		"func TestSum(t *testing.T)",
+		"got := calculator.Sum(tt.args.nums...)",
+		`"example.com/calculator"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rendered test missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestTableRenderer_Qualify(t *testing.T) {
+	renderer := TableRenderer{Qualifier: "calc"}
+
+	tests := map[string]string{
+		"int":                         "int",
+		"*Calculator":                 "*calc.Calculator",
+		"map[string][]Entry":          "map[string][]calc.Entry",
+		"...Option":                   "...calc.Option",
+		"time.Duration":               "time.Duration",
+		"func(x int) (Result, error)": "func(x int) (calc.Result, error)",
+	}
+	for in, want := range tests {
+		if got := renderer.qualify(in); got != want {
+			t.Errorf("qualify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTestGenerator_TestFileNames(t *testing.T) {
+	dir := t.TempDir()
+	tg := &TestGenerator{options: GeneratorOptions{RepoRoot: dir}}
+
+	if got := tg.newTestFile("pkg/example", "_test.go"); got != "pkg/example_gen_test.go" {
+		t.Errorf("newTestFile() = %s, want pkg/example_gen_test.go", got)
+	}
+	if got := tg.internalTestFile("pkg/example"); got != "pkg/example_internal_test.go" {
+		t.Errorf("internalTestFile() = %s, want pkg/example_internal_test.go", got)
+	}
+
+	// Existing tests and fakes helpers are never overwritten
+	if err := os.MkdirAll(filepath.Join(dir, "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"example_gen_fakes_test.go", "example_internal_test.go"} {
+		if err := os.WriteFile(filepath.Join(dir, "pkg", name), []byte("package example\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := tg.newTestFile("pkg/example", "_test.go"); got != "pkg/example_gen2_test.go" {
+		t.Errorf("newTestFile() = %s, want pkg/example_gen2_test.go", got)
+	}
+	if got := tg.internalTestFile("pkg/example"); got != "pkg/example_gen_internal_test.go" {
+		t.Errorf("internalTestFile() = %s, want pkg/example_gen_internal_test.go", got)
+	}
+}
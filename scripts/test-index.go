@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Modes for handling functions that already have dedicated tests.
+const (
+	ExistingTestsSkip         = "skip"
+	ExistingTestsDeprioritize = "deprioritize"
+	ExistingTestsIgnore       = "ignore"
+)
+
+// TestIndex maps the functions of a package, by qualified name such as
+// "Calculator.Add", to the existing Test functions that exercise them.
+type TestIndex struct {
+	tests map[string]map[string]bool
+}
+
+func newTestIndex() *TestIndex {
+	return &TestIndex{tests: make(map[string]map[string]bool)}
+}
+
+func (ti *TestIndex) add(function, test string) {
+	if ti.tests[function] == nil {
+		ti.tests[function] = make(map[string]bool)
+	}
+	ti.tests[function][test] = true
+}
+
+// TestsFor returns the sorted names of existing tests that exercise fn.
+func (ti *TestIndex) TestsFor(fn FunctionInfo) []string {
+	var names []string
+	for name := range ti.tests[fn.QualifiedName()] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IndexExistingTests finds the Test functions in the package of filePath,
+// from both the internal and the external _test package, and attributes each
+// to the functions it exercises by naming convention and by the calls whose
+// results it asserts on. With attributeCoverage set, every test is also run on its own and
+// credited with the functions its coverage profile reaches.
+func (ca *CoverageAnalyzer) IndexExistingTests(ctx context.Context, filePath string, attributeCoverage bool) (*TestIndex, error) {
+	absPath, err := filepath.Abs(ca.resolveFilePath(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	packageDir := filepath.Dir(absPath)
+	build, err := ReadBuildContext(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &packages.Config{
+		Context:    ctx,
+		Mode:       typeContextLoadMode,
+		Dir:        packageDir,
+		Fset:       ca.fileSet,
+		Tests:      true,
+		BuildFlags: build.Flags(),
+	}
+	cfg.Env = commandEnv(packageDir, build)
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load package with tests: %v", err)
+	}
+
+	index := newTestIndex()
+	var targetPath string
+	var functions map[*types.Func]string
+	var testNames []string
+
+	for _, pkg := range pkgs {
+		if pkg.Types != nil && !strings.HasSuffix(pkg.PkgPath, "_test") && !strings.HasSuffix(pkg.PkgPath, ".test") {
+			targetPath = pkg.PkgPath
+			functions = packageFunctions(pkg)
+			break
+		}
+	}
+	if targetPath == "" {
+		return nil, fmt.Errorf("no package found for %s", filePath)
+	}
+
+	knownNames := make(map[string]bool)
+	for _, qualified := range functions {
+		knownNames[qualified] = true
+	}
+
+	seenTests := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if pkg.Types == nil || strings.HasSuffix(pkg.PkgPath, ".test") || !hasTestFiles(pkg) {
+			continue
+		}
+		for _, file := range pkg.Syntax {
+			if !strings.HasSuffix(ca.fileSet.Position(file.Pos()).Filename, "_test.go") {
+				continue
+			}
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv != nil || !strings.HasPrefix(fn.Name.Name, "Test") || !isTestingEntryPoint(fn) {
+					continue
+				}
+				if !seenTests[fn.Name.Name] {
+					seenTests[fn.Name.Name] = true
+					testNames = append(testNames, fn.Name.Name)
+				}
+
+				for _, qualified := range conventionTargets(fn.Name.Name, knownNames) {
+					index.add(qualified, fn.Name.Name)
+				}
+				for _, qualified := range calledFunctions(pkg, fn, targetPath) {
+					index.add(qualified, fn.Name.Name)
+				}
+			}
+		}
+	}
+
+	if attributeCoverage && build.CrossCompiled() {
+		log.Printf("Not attributing coverage to the tests of %s: they cannot run on this host", filePath)
+	} else if attributeCoverage {
+		if err := ca.attributeCoverage(ctx, packageDir, build, targetPath, testNames, functions, index); err != nil {
+			return index, err
+		}
+	}
+
+	return index, nil
+}
+
+// ApplyExistingTests records existing tests on each function and, depending
+// on mode, drops the already-tested ones or moves them to the end.
+func ApplyExistingTests(functions []FunctionInfo, index *TestIndex, mode string) []FunctionInfo {
+	if index == nil || mode == ExistingTestsIgnore {
+		return functions
+	}
+
+	var untested, tested []FunctionInfo
+	for _, fn := range functions {
+		fn.ExistingTests = index.TestsFor(fn)
+		if len(fn.ExistingTests) == 0 {
+			untested = append(untested, fn)
+		} else {
+			tested = append(tested, fn)
+		}
+	}
+
+	if mode == ExistingTestsSkip {
+		return untested
+	}
+	return append(untested, tested...)
+}
+
+// existingTestDecls returns the package-level names declared by the test
+// files in dir belonging to package packageName.
+func existingTestDecls(dir, packageName string) (map[string]bool, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list test files: %v", err)
+	}
+	names := make(map[string]bool)
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", filepath.Base(path), err)
+		}
+		if file.Name.Name != packageName {
+			continue
+		}
+		for _, name := range packageLevelNames(file) {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+func hasTestFiles(pkg *packages.Package) bool {
+	for _, file := range pkg.GoFiles {
+		if strings.HasSuffix(file, "_test.go") {
+			return true
+		}
+	}
+	return false
+}
+
+// packageFunctions maps every function and method declared in the non-test
+// files of pkg to its qualified name.
+func packageFunctions(pkg *packages.Package) map[*types.Func]string {
+	functions := make(map[*types.Func]string)
+	for ident, obj := range pkg.TypesInfo.Defs {
+		fn, ok := obj.(*types.Func)
+		if !ok || ident.Name == "_" {
+			continue
+		}
+		if strings.HasSuffix(pkg.Fset.Position(fn.Pos()).Filename, "_test.go") {
+			continue
+		}
+		functions[fn] = qualifiedFuncName(fn)
+	}
+	return functions
+}
+
+// qualifiedFuncName returns "Type.Method" for methods and the plain name for
+// functions, matching FunctionInfo.QualifiedName.
+func qualifiedFuncName(fn *types.Func) string {
+	sig, ok := fn.Type().(*types.Signature)
+	if !ok || sig.Recv() == nil {
+		return fn.Name()
+	}
+	recv := sig.Recv().Type()
+	if ptr, ok := recv.(*types.Pointer); ok {
+		recv = ptr.Elem()
+	}
+	if named, ok := recv.(*types.Named); ok {
+		return named.Obj().Name() + "." + fn.Name()
+	}
+	return fn.Name()
+}
+
+// conventionTargets maps a test name to the functions it is named after:
+// TestCalculator_Add and TestCalculatorAdd cover Calculator.Add,
+// TestAdd and TestAdd_Negative cover the function Add, or the method Add
+// if only one type has it, and TestClamp or Test_clamp cover clamp.
+func conventionTargets(testName string, known map[string]bool) []string {
+	subject := strings.TrimPrefix(testName, "Test")
+	if subject == "" {
+		return nil
+	}
+
+	// Test_helper is the usual spelling for unexported functions.
+	parts := strings.Split(strings.TrimPrefix(subject, "_"), "_")
+	if len(parts) >= 2 && known[parts[0]+"."+parts[1]] {
+		return []string{parts[0] + "." + parts[1]}
+	}
+
+	name := capitalize(parts[0])
+	var functions, methods []string
+	for qualified := range known {
+		typeName, method, isMethod := strings.Cut(qualified, ".")
+		switch {
+		case !isMethod && capitalize(qualified) == name:
+			functions = append(functions, qualified)
+		case isMethod && name == typeName+method:
+			// TestCalculatorAdd style: type name immediately followed by method.
+			functions = append(functions, qualified)
+		case isMethod && capitalize(method) == name:
+			methods = append(methods, qualified)
+		}
+	}
+	if len(functions) == 0 && len(methods) == 1 {
+		return methods
+	}
+	sort.Strings(functions)
+	return functions
+}
+
+// calledFunctions returns the qualified names of the functions from the
+// package under test whose results the test asserts on, directly or
+// through helpers declared in the same test package. Calls that only set
+// up the test, such as constructors whose error is checked to be nil
+// before going on, are not credited.
+func calledFunctions(pkg *packages.Package, test *ast.FuncDecl, targetPath string) []string {
+	helpers := make(map[*types.Func]*ast.FuncDecl)
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok {
+				if obj, ok := pkg.TypesInfo.Defs[fn.Name].(*types.Func); ok {
+					helpers[obj] = fn
+				}
+			}
+		}
+	}
+
+	// calledObject returns the function call calls, if it is one
+	calledObject := func(call *ast.CallExpr) *types.Func {
+		var ident *ast.Ident
+		switch f := ast.Unparen(call.Fun).(type) {
+		case *ast.Ident:
+			ident = f
+		case *ast.SelectorExpr:
+			ident = f.Sel
+		case *ast.IndexExpr:
+			if id, ok := f.X.(*ast.Ident); ok {
+				ident = id
+			}
+		}
+		if ident == nil {
+			return nil
+		}
+		obj, ok := pkg.TypesInfo.Uses[ident].(*types.Func)
+		if !ok {
+			return nil
+		}
+		return obj.Origin()
+	}
+
+	// walk follows helpers with the functions whose results their
+	// parameters receive, so what they assert on is known
+	called := make(map[string]bool)
+	walking := make(map[*ast.FuncDecl]bool)
+	var walk func(fn *ast.FuncDecl, params [][]string)
+	walk = func(fn *ast.FuncDecl, params [][]string) {
+		if walking[fn] || fn.Body == nil {
+			return
+		}
+		walking[fn] = true
+		defer delete(walking, fn)
+		tNames := testingParams(fn)
+
+		// Functions whose results each variable holds
+		fed := make(map[types.Object][]string)
+		i := 0
+		for _, field := range fn.Type.Params.List {
+			for _, name := range field.Names {
+				if obj := pkg.TypesInfo.ObjectOf(name); obj != nil && i < len(params) {
+					fed[obj] = params[i]
+				}
+				i++
+			}
+			if len(field.Names) == 0 {
+				i++
+			}
+		}
+		sources := func(expr ast.Expr) []string {
+			var names []string
+			ast.Inspect(expr, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.CallExpr:
+					// The result is the function's; its receiver and
+					// arguments only set it up
+					if obj := calledObject(n); obj != nil && obj.Pkg() != nil && obj.Pkg().Path() == targetPath && !isTestFile(pkg, obj) {
+						names = append(names, qualifiedFuncName(obj))
+						return false
+					}
+				case *ast.Ident:
+					names = append(names, fed[pkg.TypesInfo.ObjectOf(n)]...)
+				}
+				return true
+			})
+			return names
+		}
+		credit := func(expr ast.Expr) {
+			for _, name := range sources(expr) {
+				called[name] = true
+			}
+		}
+		feed := func(lhs []ast.Expr, rhs []ast.Expr) {
+			for i, target := range lhs {
+				ident, ok := target.(*ast.Ident)
+				if !ok {
+					continue
+				}
+				obj := pkg.TypesInfo.ObjectOf(ident)
+				if obj == nil {
+					continue
+				}
+				if len(rhs) == len(lhs) {
+					fed[obj] = append(fed[obj], sources(rhs[i])...)
+				} else if len(rhs) == 1 {
+					fed[obj] = append(fed[obj], sources(rhs[0])...)
+				}
+			}
+		}
+
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.AssignStmt:
+				feed(n.Lhs, n.Rhs)
+			case *ast.ValueSpec:
+				var lhs []ast.Expr
+				for _, name := range n.Names {
+					lhs = append(lhs, name)
+				}
+				feed(lhs, n.Values)
+			case *ast.RangeStmt:
+				feed([]ast.Expr{n.Key, n.Value}, []ast.Expr{n.X})
+			case *ast.IfStmt:
+				if isSetupGuard(n, tNames) {
+					return false
+				}
+				credit(n.Cond)
+			case *ast.SwitchStmt:
+				if n.Tag != nil {
+					credit(n.Tag)
+				}
+			case *ast.CallExpr:
+				if obj := calledObject(n); obj != nil {
+					if helper, ok := helpers[obj]; ok && !(obj.Pkg() != nil && obj.Pkg().Path() == targetPath && !isTestFile(pkg, obj)) {
+						var args [][]string
+						for _, arg := range n.Args {
+							args = append(args, sources(arg))
+						}
+						walk(helper, args)
+						return true
+					}
+				}
+				if _, ok := assertionKind(n, tNames); ok && !isSetupAssertion(n) {
+					for _, arg := range n.Args {
+						credit(arg)
+					}
+				}
+			}
+			return true
+		})
+	}
+	walk(test, nil)
+
+	names := make([]string, 0, len(called))
+	for name := range called {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isSetupGuard reports whether ifStmt only stops the test when a step
+// before the one under test failed: if err != nil { t.Fatal(err) }.
+func isSetupGuard(ifStmt *ast.IfStmt, tNames map[string]bool) bool {
+	cond, ok := ifStmt.Cond.(*ast.BinaryExpr)
+	if !ok || cond.Op != token.NEQ || ifStmt.Else != nil || len(ifStmt.Body.List) == 0 {
+		return false
+	}
+	if nilIdent, ok := cond.Y.(*ast.Ident); !ok || nilIdent.Name != "nil" {
+		return false
+	}
+	stmt, ok := ifStmt.Body.List[len(ifStmt.Body.List)-1].(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	call, ok := stmt.X.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && tNames[x.Name] && (sel.Sel.Name == "Fatal" || sel.Sel.Name == "Fatalf" || sel.Sel.Name == "FailNow")
+}
+
+// isSetupAssertion reports whether call is require.NoError, which stops
+// the test when a setup step failed.
+func isSetupAssertion(call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && x.Name == "require" && (sel.Sel.Name == "NoError" || sel.Sel.Name == "NoErrorf")
+}
+
+func isTestFile(pkg *packages.Package, obj types.Object) bool {
+	return strings.HasSuffix(pkg.Fset.Position(obj.Pos()).Filename, "_test.go")
+}
+
+// attributeCoverage runs each test on its own and credits it with every
+// function that has at least one covered block in the resulting profile.
+func (ca *CoverageAnalyzer) attributeCoverage(ctx context.Context, packageDir string, build BuildContext, importPath string, testNames []string, functions map[*types.Func]string, index *TestIndex) error {
+	tmpDir, err := os.MkdirTemp("", "autotest-attribution-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Line ranges of each function keyed by file name.
+	type lineRange struct {
+		qualified  string
+		start, end int
+	}
+	ranges := make(map[string][]lineRange)
+	for fn, qualified := range functions {
+		pos := ca.fileSet.Position(fn.Pos())
+		file := importPath + "/" + filepath.Base(pos.Filename)
+		ranges[file] = append(ranges[file], lineRange{qualified: qualified, start: pos.Line})
+	}
+	for file, rs := range ranges {
+		sort.Slice(rs, func(i, j int) bool { return rs[i].start < rs[j].start })
+		for i := range rs {
+			// A function ends at most one line before the next begins.
+			if i+1 < len(rs) {
+				rs[i].end = rs[i+1].start - 1
+			} else {
+				rs[i].end = int(^uint(0) >> 1)
+			}
+		}
+		ranges[file] = rs
+	}
+
+	for i, test := range testNames {
+		profile := filepath.Join(tmpDir, fmt.Sprintf("cover-%d.out", i))
+		args := append([]string{"test", "-run", "^" + test + "$", "-coverprofile=" + profile}, build.Flags()...)
+		cmd := exec.CommandContext(ctx, "go", append(args, ".")...)
+		cmd.Dir = packageDir
+		cmd.Env = commandEnv(packageDir, build)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			// A failing test proves nothing about what it covers
+			log.Printf("Could not run %s for coverage attribution: %v, output: %s", test, err, string(output))
+			continue
+		}
+
+		blocks, err := parseCoverProfile(profile)
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if block.count == 0 {
+				continue
+			}
+			for _, r := range ranges[block.file] {
+				if block.startLine >= r.start && block.startLine <= r.end {
+					index.add(r.qualified, test)
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// coverBlock is one line of a go test -coverprofile file.
+type coverBlock struct {
+	file               string
+	pos                string // startLine.startCol,endLine.endCol
+	startLine, endLine int
+	statements, count  int
+}
+
+func parseCoverProfile(path string) ([]coverBlock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open coverage profile: %v", err)
+	}
+	defer f.Close()
+
+	var blocks []coverBlock
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "mode:") || line == "" {
+			continue
+		}
+
+		// Format: file:startLine.startCol,endLine.endCol numStmts count
+		colon := strings.LastIndex(line, ":")
+		if colon < 0 {
+			continue
+		}
+		fields := strings.Fields(line[colon+1:])
+		if len(fields) != 3 {
+			continue
+		}
+		start, end, ok := strings.Cut(fields[0], ",")
+		if !ok {
+			continue
+		}
+		startLine, _ := strconv.Atoi(strings.Split(start, ".")[0])
+		endLine, _ := strconv.Atoi(strings.Split(end, ".")[0])
+		statements, _ := strconv.Atoi(fields[1])
+		count, _ := strconv.Atoi(fields[2])
+
+		blocks = append(blocks, coverBlock{
+			file:       line[:colon],
+			pos:        fields[0],
+			startLine:  startLine,
+			endLine:    endLine,
+			statements: statements,
+			count:      count,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read coverage profile: %v", err)
+	}
+
+	return blocks, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConventionTargets(t *testing.T) {
+	known := map[string]bool{
+		"NewCalculator":       true,
+		"Calculator.Add":      true,
+		"Calculator.Multiply": true,
+		"Matrix.Add":          true,
+		"Matrix.Transpose":    true,
+		"Sub":                 true,
+		"clamp":               true,
+	}
+
+	tests := []struct {
+		test string
+		want []string
+	}{
+		{"TestAdd", nil},
+		{"TestCalculator_Add", []string{"Calculator.Add"}},
+		{"TestCalculatorMultiply", []string{"Calculator.Multiply"}},
+		{"TestMatrix_Add", []string{"Matrix.Add"}},
+		{"TestTranspose", []string{"Matrix.Transpose"}},
+		{"TestSub_Negative", []string{"Sub"}},
+		{"TestNewCalculator", []string{"NewCalculator"}},
+		{"TestClamp", []string{"clamp"}},
+		{"Test_clamp", []string{"clamp"}},
+		{"TestDivide", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.test, func(t *testing.T) {
+			got := conventionTargets(tt.test, known)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("conventionTargets(%s) = %v, want %v", tt.test, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyExistingTests(t *testing.T) {
+	index := newTestIndex()
+	index.add("Calculator.Add", "TestAdd")
+
+	functions := []FunctionInfo{
+		{Name: "Add", Receiver: "Calculator"},
+		{Name: "Divide", Receiver: "Calculator"},
+	}
+
+	skipped := ApplyExistingTests(functions, index, ExistingTestsSkip)
+	if len(skipped) != 1 || skipped[0].Name != "Divide" {
+		t.Errorf("skip mode returned %v, want only Divide", skipped)
+	}
+
+	reordered := ApplyExistingTests(functions, index, ExistingTestsDeprioritize)
+	if len(reordered) != 2 || reordered[0].Name != "Divide" || reordered[1].Name != "Add" {
+		t.Fatalf("deprioritize mode returned %v, want Divide then Add", reordered)
+	}
+	if !reflect.DeepEqual(reordered[1].ExistingTests, []string{"TestAdd"}) {
+		t.Errorf("ExistingTests = %v, want [TestAdd]", reordered[1].ExistingTests)
+	}
+
+	if got := ApplyExistingTests(functions, index, ExistingTestsIgnore); len(got) != 2 {
+		t.Errorf("ignore mode returned %d functions, want 2", len(got))
+	}
+}
+
+func TestIndexExistingTests(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/calc\n\ngo 1.22\n",
+		"calc.go": `package calc
+
+type Calculator struct{}
+
+func NewCalculator() (*Calculator, error) { return &Calculator{}, nil }
+
+func (c *Calculator) Add(a, b int) int { return a + b }
+
+func (c *Calculator) Sub(a, b int) int { return a - b }
+
+func (c *Calculator) Reset() {}
+`,
+		"calc_test.go": `package calc
+
+import "testing"
+
+func TestArithmetic(t *testing.T) {
+	c, err := NewCalculator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Reset()
+	got := c.Add(1, 2)
+	if got != 3 {
+		t.Errorf("Add() = %d", got)
+	}
+	checkSub(t, c)
+}
+
+func checkSub(t *testing.T, c *Calculator) {
+	if diff := c.Sub(3, 1); diff != 2 {
+		t.Errorf("Sub() = %d", diff)
+	}
+}
+
+func TestFailing(t *testing.T) {
+	t.Fatal("broken")
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ca := NewCoverageAnalyzer(DefaultSelectionPolicy(), "")
+	index, err := ca.IndexExistingTests(context.Background(), filepath.Join(dir, "calc.go"), true)
+	if err != nil {
+		t.Fatalf("IndexExistingTests returned error: %v", err)
+	}
+	for _, tt := range []struct {
+		fn   FunctionInfo
+		want []string
+	}{
+		{FunctionInfo{Name: "Add", Receiver: "Calculator"}, []string{"TestArithmetic"}},
+		{FunctionInfo{Name: "Sub", Receiver: "Calculator"}, []string{"TestArithmetic"}},
+		{FunctionInfo{Name: "Reset", Receiver: "Calculator"}, []string{"TestArithmetic"}},
+		{FunctionInfo{Name: "NewCalculator"}, []string{"TestArithmetic"}},
+	} {
+		if got := index.TestsFor(tt.fn); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TestsFor(%s) = %v, want %v", tt.fn.QualifiedName(), got, tt.want)
+		}
+	}
+
+	// Without coverage attribution only the asserted calls count
+	index, err = ca.IndexExistingTests(context.Background(), filepath.Join(dir, "calc.go"), false)
+	if err != nil {
+		t.Fatalf("IndexExistingTests returned error: %v", err)
+	}
+	for _, name := range []string{"NewCalculator", "Reset"} {
+		fn := FunctionInfo{Name: name}
+		if name == "Reset" {
+			fn.Receiver = "Calculator"
+		}
+		if got := index.TestsFor(fn); len(got) != 0 {
+			t.Errorf("TestsFor(%s) = %v, want none", fn.QualifiedName(), got)
+		}
+	}
+}
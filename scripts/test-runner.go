@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TestRunner runs go test on generated tests inside a Sandbox: a copy of
+// the module with a scrubbed environment and no network access.
+type TestRunner struct {
+	TestFile  string        // name the tests are published under, e.g. calc_gen_test.go
+	Timeout   time.Duration // per go test invocation; zero means no limit
+	Helpers   []HelperFile  // extra test files the generated tests depend on
+	Overrides []HelperFile  // package files replaced in the sandbox, e.g. by a mutant
+	MaxOutput int           // bytes of output kept; zero means defaultMaxOutput
+	Build     BuildContext  // tags and platform the package under test needs
+}
+
+// Run places content as TestFile and the helpers under their own names in
+// a sandboxed copy of packageDir, next to the existing tests, so the files
+// run are the ones published, and runs go test with args there. It returns
+// the combined output and a non-nil error if go test failed or timed out.
+// When Build targets another platform, args are ignored and the tests are
+// only compiled and vetted.
+func (tr TestRunner) Run(ctx context.Context, packageDir, content string, args ...string) (string, error) {
+	sandbox, err := NewSandbox(packageDir)
+	if err != nil {
+		return "", err
+	}
+	defer sandbox.Close()
+
+	if !strings.HasSuffix(tr.TestFile, "_test.go") || filepath.Base(tr.TestFile) != tr.TestFile {
+		return "", fmt.Errorf("invalid test file name %q", tr.TestFile)
+	}
+	if err := os.WriteFile(filepath.Join(sandbox.packageDir, tr.TestFile), []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("failed to write test file into sandbox: %v", err)
+	}
+	for _, helper := range tr.Helpers {
+		if !strings.HasSuffix(helper.Name, "_test.go") || filepath.Base(helper.Name) != helper.Name {
+			return "", fmt.Errorf("invalid helper file name %q", helper.Name)
+		}
+		if err := os.WriteFile(filepath.Join(sandbox.packageDir, helper.Name), []byte(helper.Content), 0644); err != nil {
+			return "", fmt.Errorf("failed to write helper file into sandbox: %v", err)
+		}
+	}
+	for _, override := range tr.Overrides {
+		if err := os.WriteFile(filepath.Join(sandbox.packageDir, override.Name), []byte(override.Content), 0644); err != nil {
+			return "", fmt.Errorf("failed to write %s into sandbox: %v", override.Name, err)
+		}
+	}
+
+	if tr.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tr.Timeout)
+		defer cancel()
+	}
+
+	maxOutput := tr.MaxOutput
+	if maxOutput == 0 {
+		maxOutput = defaultMaxOutput
+	}
+	output := &limitedBuffer{limit: maxOutput}
+
+	// Test binaries for another platform cannot run here: building them
+	// and vetting the package is all that can be checked
+	commands := [][]string{append([]string{"test"}, append(append(tr.Build.Flags(), args...), ".")...)}
+	if tr.Build.CrossCompiled() {
+		commands = [][]string{
+			append(append([]string{"test", "-c", "-o", os.DevNull}, tr.Build.Flags()...), "."),
+			append(append([]string{"vet"}, tr.Build.Flags()...), "."),
+		}
+	}
+	for _, command := range commands {
+		cmd, err := sandbox.Command(ctx, command...)
+		if err != nil {
+			return "", err
+		}
+		cmd.Env = append(cmd.Env, tr.Build.Env()...)
+		cmd.Stdout = output
+		cmd.Stderr = output
+
+		err = cmd.Run()
+		if ctx.Err() == context.DeadlineExceeded {
+			return output.String(), fmt.Errorf("go %s timed out after %v", strings.Join(command[:len(command)-1], " "), tr.Timeout)
+		}
+		if err != nil {
+			return output.String(), fmt.Errorf("go %s failed: %v", strings.Join(command[:len(command)-1], " "), err)
+		}
+	}
+
+	return output.String(), nil
+}
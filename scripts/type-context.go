@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// typeContextLoadMode is the set of package facts needed to resolve the
+// identifiers used by the target functions back to their declarations.
+// Dependencies are type-checked from source rather than export data so the
+// loader does not depend on the export format of the installed toolchain.
+const typeContextLoadMode = packages.NeedName |
+	packages.NeedFiles |
+	packages.NeedSyntax |
+	packages.NeedTypes |
+	packages.NeedTypesInfo |
+	packages.NeedImports |
+	packages.NeedDeps
+
+// loadPackage loads the package containing filePath with full syntax and
+// type information.
+func (ca *CoverageAnalyzer) loadPackage(ctx context.Context, filePath string) (*packages.Package, error) {
+	absPath, err := filepath.Abs(ca.resolveFilePath(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	build, err := ReadBuildContext(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadPackageDir(ctx, filepath.Dir(absPath), build)
+}
+
+// packageCache holds the packages loadPackageDir loaded, by directory and
+// build context. Sources do not change during a run, and loading with all
+// dependencies is the slowest part of analyzing a file, which the type
+// context, the generators and mutation testing each need.
+var packageCache struct {
+	sync.Mutex
+	packages map[string]*packages.Package
+}
+
+// loadPackageDir loads the package in dir with full syntax and type
+// information, including the files build selects. Positions are recorded
+// in the package's Fset. Packages are loaded once and then shared, so they
+// must not be modified.
+func loadPackageDir(ctx context.Context, dir string, build BuildContext) (*packages.Package, error) {
+	key := filepath.Clean(dir) + "\x00" + build.String()
+	packageCache.Lock()
+	defer packageCache.Unlock()
+	if pkg, ok := packageCache.packages[key]; ok {
+		return pkg, nil
+	}
+
+	cfg := &packages.Config{
+		Context:    ctx,
+		Mode:       typeContextLoadMode,
+		Dir:        dir,
+		Fset:       token.NewFileSet(),
+		BuildFlags: build.Flags(),
+	}
+	cfg.Env = commandEnv(dir, build)
+
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load package: %v", err)
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no package found in %s", dir)
+	}
+
+	pkg := pkgs[0]
+	if len(pkg.Errors) > 0 && pkg.Types == nil {
+		return nil, fmt.Errorf("failed to type-check package: %v", pkg.Errors[0])
+	}
+
+	if packageCache.packages == nil {
+		packageCache.packages = make(map[string]*packages.Package)
+	}
+	packageCache.packages[key] = pkg
+	return pkg, nil
+}
+
+// ExtractTypeContext collects the declarations of every package-level type,
+// constructor and interface referenced by the given functions, including
+// those defined in sibling files, and renders them as Go source for the prompt.
+func (ca *CoverageAnalyzer) ExtractTypeContext(ctx context.Context, filePath string, functions []FunctionInfo) (string, error) {
+	pkg, err := ca.loadPackage(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	absPath, err := filepath.Abs(ca.resolveFilePath(filePath))
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %v", err)
+	}
+
+	// Index the target functions by their starting line so they can be
+	// matched against the loaded syntax trees.
+	targetLines := make(map[int]bool)
+	for _, fn := range functions {
+		targetLines[fn.StartLine] = true
+	}
+
+	referenced := make(map[*types.TypeName]bool)
+	for _, file := range pkg.Syntax {
+		if !sameFile(pkg.Fset.Position(file.Pos()).Filename, absPath) {
+			continue
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || !targetLines[pkg.Fset.Position(fn.Pos()).Line] {
+				continue
+			}
+			ca.collectReferencedTypes(pkg, fn, referenced)
+		}
+	}
+
+	if len(referenced) == 0 {
+		return "", nil
+	}
+
+	// Pull in package types used by the fields of referenced structs, and
+	// by their fields in turn, so the model can construct values of them too.
+	worklist := make([]*types.TypeName, 0, len(referenced))
+	for tn := range referenced {
+		worklist = append(worklist, tn)
+	}
+	for len(worklist) > 0 {
+		tn := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		st, ok := tn.Type().Underlying().(*types.Struct)
+		if !ok {
+			continue
+		}
+		for i := 0; i < st.NumFields(); i++ {
+			found := make(map[*types.TypeName]bool)
+			addPackageTypes(pkg.Types, st.Field(i).Type(), found)
+			for fieldType := range found {
+				if !referenced[fieldType] {
+					referenced[fieldType] = true
+					worklist = append(worklist, fieldType)
+				}
+			}
+		}
+	}
+
+	return ca.renderTypeContext(pkg, referenced), nil
+}
+
+// collectReferencedTypes records every named type from the package that is
+// used in the signature or body of fn.
+func (ca *CoverageAnalyzer) collectReferencedTypes(pkg *packages.Package, fn *ast.FuncDecl, referenced map[*types.TypeName]bool) {
+	ast.Inspect(fn, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		obj := pkg.TypesInfo.Uses[ident]
+		if obj == nil {
+			obj = pkg.TypesInfo.Defs[ident]
+		}
+		if obj == nil || obj.Pkg() != pkg.Types {
+			return true
+		}
+		switch o := obj.(type) {
+		case *types.TypeName:
+			referenced[o] = true
+		case *types.Var, *types.Const:
+			addPackageTypes(pkg.Types, o.Type(), referenced)
+		case *types.Func:
+			if sig, ok := o.Type().(*types.Signature); ok {
+				addPackageTypes(pkg.Types, sig, referenced)
+			}
+		}
+		return true
+	})
+}
+
+// addPackageTypes records the named types from pkg that appear in t.
+func addPackageTypes(pkg *types.Package, t types.Type, referenced map[*types.TypeName]bool) {
+	switch t := t.(type) {
+	case *types.Named:
+		if tn := t.Obj(); tn.Pkg() == pkg {
+			referenced[tn] = true
+		}
+		if args := t.TypeArgs(); args != nil {
+			for i := 0; i < args.Len(); i++ {
+				addPackageTypes(pkg, args.At(i), referenced)
+			}
+		}
+	case *types.Pointer:
+		addPackageTypes(pkg, t.Elem(), referenced)
+	case *types.Slice:
+		addPackageTypes(pkg, t.Elem(), referenced)
+	case *types.Array:
+		addPackageTypes(pkg, t.Elem(), referenced)
+	case *types.Map:
+		addPackageTypes(pkg, t.Key(), referenced)
+		addPackageTypes(pkg, t.Elem(), referenced)
+	case *types.Chan:
+		addPackageTypes(pkg, t.Elem(), referenced)
+	case *types.Signature:
+		for _, tuple := range []*types.Tuple{t.Params(), t.Results()} {
+			for i := 0; i < tuple.Len(); i++ {
+				addPackageTypes(pkg, tuple.At(i).Type(), referenced)
+			}
+		}
+	}
+}
+
+// renderTypeContext prints the type declarations, constructors and method
+// signatures of the referenced types, grouped by the file they come from.
+func (ca *CoverageAnalyzer) renderTypeContext(pkg *packages.Package, referenced map[*types.TypeName]bool) string {
+	var decls []typeContextDecl
+
+	for _, file := range pkg.Syntax {
+		fileName := filepath.Base(pkg.Fset.Position(file.Pos()).Filename)
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				if d.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range d.Specs {
+					ts := spec.(*ast.TypeSpec)
+					tn, ok := pkg.TypesInfo.Defs[ts.Name].(*types.TypeName)
+					if !ok || !referenced[tn] {
+						continue
+					}
+					// Grouped declarations are split so only the referenced
+					// specs are shown, each with its own doc comment.
+					source := ca.printNode(pkg.Fset, file, d)
+					if len(d.Specs) > 1 {
+						var doc strings.Builder
+						if ts.Doc != nil {
+							for _, comment := range ts.Doc.List {
+								doc.WriteString(comment.Text + "\n")
+							}
+						}
+						spec := *ts
+						spec.Doc = nil
+						source = doc.String() + "type " + ca.printNode(pkg.Fset, file, &spec)
+					}
+					decls = append(decls, typeContextDecl{
+						file:   fileName,
+						pos:    ts.Pos(),
+						source: source,
+					})
+				}
+			case *ast.FuncDecl:
+				if !ca.isContextFunc(pkg, d, referenced) {
+					continue
+				}
+				// Only the signature is useful context; bodies are noise.
+				sig := &ast.FuncDecl{Doc: d.Doc, Recv: d.Recv, Name: d.Name, Type: d.Type}
+				decls = append(decls, typeContextDecl{
+					file:   fileName,
+					pos:    d.Pos(),
+					source: ca.printNode(pkg.Fset, file, sig),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(decls, func(i, j int) bool {
+		if decls[i].file != decls[j].file {
+			return decls[i].file < decls[j].file
+		}
+		return decls[i].pos < decls[j].pos
+	})
+
+	var out strings.Builder
+	currentFile := ""
+	for _, d := range decls {
+		if d.file != currentFile {
+			currentFile = d.file
+			out.WriteString(fmt.Sprintf("// From %s\n", d.file))
+		}
+		out.WriteString(d.source)
+		out.WriteString("\n\n")
+	}
+
+	return strings.TrimSpace(out.String())
+}
+
+// isContextFunc reports whether fn is a method of a referenced type or a
+// constructor returning one.
+func (ca *CoverageAnalyzer) isContextFunc(pkg *packages.Package, fn *ast.FuncDecl, referenced map[*types.TypeName]bool) bool {
+	obj, ok := pkg.TypesInfo.Defs[fn.Name].(*types.Func)
+	if !ok {
+		return false
+	}
+	sig := obj.Type().(*types.Signature)
+
+	if recv := sig.Recv(); recv != nil {
+		found := make(map[*types.TypeName]bool)
+		addPackageTypes(pkg.Types, recv.Type(), found)
+		for tn := range found {
+			if referenced[tn] {
+				return true
+			}
+		}
+		return false
+	}
+
+	results := sig.Results()
+	for i := 0; i < results.Len(); i++ {
+		found := make(map[*types.TypeName]bool)
+		addPackageTypes(pkg.Types, results.At(i).Type(), found)
+		for tn := range found {
+			if referenced[tn] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ca *CoverageAnalyzer) printNode(fset *token.FileSet, file *ast.File, node ast.Node) string {
+	var buf bytes.Buffer
+	commented := &printer.CommentedNode{Node: node, Comments: file.Comments}
+	config := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := config.Fprint(&buf, fset, commented); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+type typeContextDecl struct {
+	file   string
+	pos    token.Pos
+	source string
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractTypeContext(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/jobs\n\ngo 1.22\n",
+		"run.go": `package jobs
+
+// Run runs the job.
+func Run(cfg *Config) error {
+	return cfg.Validate()
+}
+`,
+		"config.go": `package jobs
+
+type (
+	// Config configures a job.
+	Config struct {
+		Name  string
+		Retry Retry
+	}
+
+	// Unused is not referenced.
+	Unused struct{}
+)
+
+// NewConfig returns a Config.
+func NewConfig(name string) *Config {
+	return &Config{Name: name}
+}
+
+// Validate checks the config.
+func (c *Config) Validate() error {
+	return nil
+}
+
+func (u Unused) Do() {}
+`,
+		"retry.go": `package jobs
+
+// Retry says how often to retry.
+type Retry struct {
+	Backoff Backoff
+}
+
+// Backoff is the delay policy.
+type Backoff int
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := `// From config.go
+// Config configures a job.
+type Config struct {
+	Name  string
+	Retry Retry
+}
+
+// NewConfig returns a Config.
+func NewConfig(name string) *Config
+
+// Validate checks the config.
+func (c *Config) Validate() error
+
+// From retry.go
+// Retry says how often to retry.
+type Retry struct {
+	Backoff Backoff
+}
+
+// Backoff is the delay policy.
+type Backoff int`
+
+	ca := NewCoverageAnalyzer(DefaultSelectionPolicy(), "")
+	functions := []FunctionInfo{{Name: "Run", StartLine: 4}}
+	for run := 0; run < 3; run++ {
+		got, err := ca.ExtractTypeContext(context.Background(), filepath.Join(dir, "run.go"), functions)
+		if err != nil {
+			t.Fatalf("ExtractTypeContext returned error: %v", err)
+		}
+		if got != want {
+			t.Fatalf("ExtractTypeContext() =\n%s\nwant\n%s", got, want)
+		}
+	}
+}
+
+func TestLoadPackageDirCache(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":  "module example.com/calc\n\ngo 1.22\n",
+		"calc.go": "package calc\n\nfunc Add(a, b int) int { return a + b }\n",
+		"slow.go": "//go:build slow\n\npackage calc\n\nfunc Slow() {}\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	first, err := loadPackageDir(ctx, dir, BuildContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := loadPackageDir(ctx, dir+"/", BuildContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Errorf("loadPackageDir() loaded the package again")
+	}
+	slow, err := loadPackageDir(ctx, dir, BuildContext{Tags: []string{"slow"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slow == first || slow.Types.Scope().Lookup("Slow") == nil {
+		t.Errorf("loadPackageDir() with -tags=slow did not load slow.go")
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// maxInstantiations is how many instantiations are picked per generic
+// function: enough to show it working on more than one type.
+const maxInstantiations = 2
+
+// maxInstantiationTries bounds the combinations of type arguments tried
+// for functions with several type parameters.
+const maxInstantiationTries = 10000
+
+// instantiationCandidates are the type arguments tried first, in order.
+// Package types follow for constraints none of them satisfies.
+var instantiationCandidates = []string{
+	"int", "string", "float64", "bool", "int64", "uint", "[]int", "[]string", "map[string]int",
+}
+
+// TypeParam is a type parameter of a generic function or of the receiver
+// type of a method, as named in the declaration.
+type TypeParam struct {
+	Name       string
+	Constraint string // e.g. "comparable" or "~int | ~float64", empty until resolved for methods
+}
+
+func (tp TypeParam) String() string {
+	if tp.Constraint == "" {
+		return tp.Name
+	}
+	return tp.Name + " " + tp.Constraint
+}
+
+// typeParamsOf returns the type parameters of fn, or of its receiver type
+// for a method. Receiver type parameters carry no constraint in the
+// method declaration; instantiateGenerics fills it in.
+func typeParamsOf(fn *ast.FuncDecl) []TypeParam {
+	var params []TypeParam
+	if fn.Type.TypeParams != nil {
+		for _, field := range fn.Type.TypeParams.List {
+			for _, name := range field.Names {
+				params = append(params, TypeParam{Name: name.Name, Constraint: types.ExprString(field.Type)})
+			}
+		}
+		return params
+	}
+
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return nil
+	}
+	expr := fn.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+			continue
+		case *ast.ParenExpr:
+			expr = e.X
+			continue
+		case *ast.IndexExpr:
+			return append(params, TypeParam{Name: types.ExprString(e.Index)})
+		case *ast.IndexListExpr:
+			for _, index := range e.Indices {
+				params = append(params, TypeParam{Name: types.ExprString(index)})
+			}
+		}
+		return params
+	}
+}
+
+// instantiateGenerics resolves the constraints of the generic functions
+// among functions and picks type arguments satisfying them.
+func (ca *CoverageAnalyzer) instantiateGenerics(ctx context.Context, filePath string, functions []FunctionInfo) error {
+	generic := false
+	for _, fn := range functions {
+		generic = generic || len(fn.TypeParams) > 0
+	}
+	if !generic {
+		return nil
+	}
+
+	pkg, err := ca.loadPackage(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	objects := make(map[string]*types.Func)
+	for fn, qualified := range packageFunctions(pkg) {
+		objects[qualified] = fn
+	}
+
+	for i, fn := range functions {
+		if len(fn.TypeParams) == 0 {
+			continue
+		}
+		obj, ok := objects[fn.QualifiedName()]
+		if !ok {
+			continue
+		}
+		genericType, tparams := genericOf(obj)
+		if tparams.Len() != len(fn.TypeParams) {
+			continue
+		}
+		for j := 0; j < tparams.Len(); j++ {
+			functions[i].TypeParams[j].Constraint = types.TypeString(tparams.At(j).Constraint(), types.RelativeTo(pkg.Types))
+		}
+		functions[i].Instantiations = pickInstantiations(pkg.Types, genericType, tparams.Len(), fn.Exported())
+	}
+	return nil
+}
+
+// genericOf returns what has to be instantiated to call fn: its signature
+// for a generic function, the origin of its receiver type for a method.
+func genericOf(fn *types.Func) (types.Type, *types.TypeParamList) {
+	sig := fn.Type().(*types.Signature)
+	if sig.TypeParams().Len() > 0 {
+		return sig, sig.TypeParams()
+	}
+	if sig.Recv() == nil || sig.RecvTypeParams().Len() == 0 {
+		return nil, nil
+	}
+	recv := sig.Recv().Type()
+	if ptr, ok := recv.(*types.Pointer); ok {
+		recv = ptr.Elem()
+	}
+	named, ok := recv.(*types.Named)
+	if !ok {
+		return nil, nil
+	}
+	return named.Origin(), named.Origin().TypeParams()
+}
+
+// pickInstantiations tries type arguments for the n type parameters of
+// genericType, built-in types first, then the package's own types, and
+// returns up to maxInstantiations combinations the type checker accepts,
+// as different from each other as possible. Unexported package types are
+// left out for exported functions, whose tests may live outside the
+// package.
+func pickInstantiations(pkg *types.Package, genericType types.Type, n int, exported bool) [][]string {
+	if n == 0 {
+		return nil
+	}
+
+	names := append([]string(nil), instantiationCandidates...)
+	for _, name := range pkg.Scope().Names() {
+		tn, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok || tn.IsAlias() || (exported && !tn.Exported()) {
+			continue
+		}
+		if named, ok := tn.Type().(*types.Named); ok && named.TypeParams().Len() > 0 {
+			continue
+		}
+		if _, ok := tn.Type().Underlying().(*types.Interface); ok {
+			continue
+		}
+		names = append(names, name, "*"+name)
+	}
+
+	var candidates []types.Type
+	var candidateNames []string
+	for _, name := range names {
+		if tv, err := types.Eval(token.NewFileSet(), pkg, token.NoPos, name); err == nil && tv.IsType() {
+			candidates = append(candidates, tv.Type)
+			candidateNames = append(candidateNames, name)
+		}
+	}
+
+	var valid [][]int
+	index := make([]int, n)
+	for tries := 0; tries < maxInstantiationTries; tries++ {
+		targs := make([]types.Type, n)
+		for i, c := range index {
+			targs[i] = candidates[c]
+		}
+		if _, err := types.Instantiate(nil, genericType, targs, true); err == nil {
+			valid = append(valid, append([]int(nil), index...))
+		}
+
+		// Next combination, the last type parameter varying fastest
+		i := n - 1
+		for ; i >= 0; i-- {
+			index[i]++
+			if index[i] < len(candidates) {
+				break
+			}
+			index[i] = 0
+		}
+		if i < 0 {
+			break
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+
+	// The first valid combination, then those differing most from the
+	// ones already picked
+	picked := [][]int{valid[0]}
+	for len(picked) < maxInstantiations {
+		best, bestDistance := -1, 0
+		for i, combination := range valid {
+			distance := n + 1
+			for _, p := range picked {
+				d := 0
+				for j := range combination {
+					if combination[j] != p[j] {
+						d++
+					}
+				}
+				distance = min(distance, d)
+			}
+			if distance > bestDistance {
+				best, bestDistance = i, distance
+			}
+		}
+		if best < 0 {
+			break
+		}
+		picked = append(picked, valid[best])
+	}
+
+	var instantiations [][]string
+	for _, combination := range picked {
+		var args []string
+		for _, c := range combination {
+			args = append(args, candidateNames[c])
+		}
+		instantiations = append(instantiations, args)
+	}
+	return instantiations
+}
+
+// Instantiate returns fi with its type parameters replaced by typeArgs in
+// its parameter and result types, and TypeArgs set so that it is called
+// with them.
+func (fi FunctionInfo) Instantiate(typeArgs []string) FunctionInfo {
+	subst := make(map[string]string)
+	for i, tp := range fi.TypeParams {
+		if i < len(typeArgs) {
+			subst[tp.Name] = typeArgs[i]
+		}
+	}
+
+	instantiated := fi
+	instantiated.TypeArgs = typeArgs
+	instantiated.Params = substituteParams(fi.Params, subst)
+	instantiated.Results = substituteParams(fi.Results, subst)
+	return instantiated
+}
+
+// GenericName returns how the function is instantiated with typeArgs,
+// e.g. "Max[int]", or "Stack[int]" for a method of Stack.
+func (fi FunctionInfo) GenericName(typeArgs []string) string {
+	name := fi.Name
+	if fi.Receiver != "" {
+		name = fi.Receiver
+	}
+	return name + "[" + strings.Join(typeArgs, ", ") + "]"
+}
+
+func substituteParams(params []Param, subst map[string]string) []Param {
+	if params == nil {
+		return nil
+	}
+	substituted := make([]Param, len(params))
+	for i, param := range params {
+		substituted[i] = Param{Name: param.Name, Type: substituteTypeParams(param.Type, subst)}
+	}
+	return substituted
+}
+
+// substituteTypeParams replaces the type parameter names in a type
+// expression, e.g. "map[K][]V" becomes "map[string][]int".
+func substituteTypeParams(typeExpr string, subst map[string]string) string {
+	variadic := strings.HasPrefix(typeExpr, "...")
+	expr, err := parser.ParseExpr(strings.TrimPrefix(typeExpr, "..."))
+	if err != nil {
+		return typeExpr
+	}
+
+	expr = astutil.Apply(expr, func(c *astutil.Cursor) bool {
+		ident, ok := c.Node().(*ast.Ident)
+		if !ok {
+			return true
+		}
+		switch parent := c.Parent().(type) {
+		case *ast.SelectorExpr:
+			if parent.Sel == ident {
+				return true
+			}
+		case *ast.Field:
+			if c.Name() == "Names" {
+				return true
+			}
+		}
+		if arg, ok := subst[ident.Name]; ok {
+			if argExpr, err := parser.ParseExpr(arg); err == nil {
+				c.Replace(argExpr)
+			}
+		}
+		return true
+	}, nil).(ast.Expr)
+
+	if variadic {
+		return "..." + types.ExprString(expr)
+	}
+	return types.ExprString(expr)
+}
+
+// instantiateSignature returns the signature of fn with typeArgs
+// substituted for its type parameters, or those of its receiver type.
+func instantiateSignature(pkg *packages.Package, fn *types.Func, typeArgs []string) (*types.Signature, error) {
+	genericType, tparams := genericOf(fn)
+	if genericType == nil || tparams.Len() != len(typeArgs) {
+		return nil, fmt.Errorf("%s takes %d type arguments, not %d", fn.Name(), tparams.Len(), len(typeArgs))
+	}
+
+	var targs []types.Type
+	for _, arg := range typeArgs {
+		tv, err := types.Eval(pkg.Fset, pkg.Types, token.NoPos, arg)
+		if err != nil || !tv.IsType() {
+			return nil, fmt.Errorf("invalid type argument %s: %v", arg, err)
+		}
+		targs = append(targs, tv.Type)
+	}
+
+	instance, err := types.Instantiate(nil, genericType, targs, true)
+	if err != nil {
+		return nil, err
+	}
+	if sig, ok := instance.(*types.Signature); ok {
+		return sig, nil
+	}
+	method, _, _ := types.LookupFieldOrMethod(instance, true, pkg.Types, fn.Name())
+	if method == nil {
+		return nil, fmt.Errorf("method %s not found on %s", fn.Name(), instance)
+	}
+	return method.Type().(*types.Signature), nil
+}
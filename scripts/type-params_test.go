@@ -0,0 +1,95 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"testing"
+)
+
+func TestPickInstantiations(t *testing.T) {
+	src := `package calc
+
+type Number interface{ ~int | ~float64 }
+
+type Named interface{ Name() string }
+
+type User struct{}
+
+func (User) Name() string { return "" }
+
+type Stack[T any] struct{ items []T }
+
+func Max[T Number](a, b T) T { return a }
+
+func Keys[M ~map[K]V, K comparable, V any](m M) []K { return nil }
+
+func Greet[T Named](v T) string { return v.Name() }
+
+func Parse[T interface{ Parse() T }](s string) T { var t T; return t }
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "calc.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := new(types.Config).Check("example.com/calc", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fn   string
+		want [][]string
+	}{
+		{"Max", [][]string{{"int"}, {"float64"}}},
+		{"Keys", [][]string{{"map[string]int", "string", "int"}}},
+		{"Greet", [][]string{{"User"}, {"*User"}}},
+		{"Parse", nil},
+	}
+	for _, tt := range tests {
+		genericType, tparams := genericOf(pkg.Scope().Lookup(tt.fn).(*types.Func))
+		if got := pickInstantiations(pkg, genericType, tparams.Len(), true); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pickInstantiations(%s) = %v, want %v", tt.fn, got, tt.want)
+		}
+	}
+}
+
+func TestInstantiate(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "stack.go", `package calc
+
+func (s *Stack[E]) PushAll(v ...E) map[E][]E { return nil }
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decl := file.Decls[0].(*ast.FuncDecl)
+	fn := FunctionInfo{
+		Name:        "PushAll",
+		Receiver:    "Stack",
+		PointerRecv: true,
+		Params:      fieldListParams(decl.Type.Params, "arg"),
+		Results:     fieldListParams(decl.Type.Results, "res"),
+		TypeParams:  typeParamsOf(decl),
+	}
+	if !reflect.DeepEqual(fn.TypeParams, []TypeParam{{Name: "E"}}) {
+		t.Fatalf("typeParamsOf() = %v", fn.TypeParams)
+	}
+
+	instantiated := fn.Instantiate([]string{"Celsius"})
+	if got := instantiated.Params[0].Type; got != "...Celsius" {
+		t.Errorf("param type = %s, want ...Celsius", got)
+	}
+	if got := instantiated.Results[0].Type; got != "map[Celsius][]Celsius" {
+		t.Errorf("result type = %s, want map[Celsius][]Celsius", got)
+	}
+
+	fn.Instantiations = [][]string{{"Celsius"}}
+	test := TableRenderer{Qualifier: "calc"}.tableTest(fn, nil)
+	if test.RecvType != "*calc.Stack[calc.Celsius]" || test.Args[0].Type != "[]calc.Celsius" {
+		t.Errorf("tableTest() = recv %s, args %v", test.RecvType, test.Args)
+	}
+}